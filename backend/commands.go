@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/protest-tracker/internal/config"
+	"github.com/protest-tracker/internal/encryption"
+	"github.com/protest-tracker/internal/repository"
+	"github.com/protest-tracker/internal/scanner"
+	"github.com/protest-tracker/internal/services"
+	"github.com/protest-tracker/internal/storage"
+)
+
+// runCommand executes a one-off maintenance command
+func runCommand(name string, args []string, db *sql.DB, cfg *config.Config) error {
+	switch name {
+	case "rotate-keys":
+		return rotateKeys(db, cfg)
+	case "migrate-storage":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: migrate-storage <from> <to>, e.g. migrate-storage local s3")
+		}
+		return migrateStorage(db, cfg, args[0], args[1])
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// rotateKeys re-wraps media data keys sealed by a previous master key
+func rotateKeys(db *sql.DB, cfg *config.Config) error {
+	mediaSvc, err := newMediaService(db, cfg)
+	if err != nil {
+		return err
+	}
+
+	rotated, err := mediaSvc.RotateKeys()
+	log.Printf("Re-wrapped %d data keys", rotated)
+	return err
+}
+
+// migrateStorage moves media files from one storage backend to another
+func migrateStorage(db *sql.DB, cfg *config.Config, from, to string) error {
+	mediaSvc, err := newMediaService(db, cfg)
+	if err != nil {
+		return err
+	}
+
+	moved, err := mediaSvc.MigrateStorage(from, to)
+	log.Printf("Moved %d files from %s to %s", moved, from, to)
+	return err
+}
+
+func newMediaService(db *sql.DB, cfg *config.Config) (*services.MediaService, error) {
+	keyring, err := encryption.LoadKeyring(cfg.MediaMasterKey, cfg.MediaMasterKeyFile, cfg.MediaPreviousKeys)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.Load(cfg.StorageBackend, cfg.MediaDir, cfg.S3Config())
+	if err != nil {
+		return nil, err
+	}
+
+	scan, err := scanner.Load(cfg.MalwareScanner, cfg.ClamdAddress, cfg.MalwareScanTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaRepo := repository.NewMediaRepository(db)
+	eventRepo := repository.NewEventRepository(db)
+	return services.NewMediaService(mediaRepo, eventRepo, store, keyring, scan, cfg.MediaDeleteGrace,
+		services.ProvenanceSettings{RecordIP: cfg.RecordUploadIP, RecordDevice: cfg.RecordDeviceFingerprint},
+		cfg.UploadLimits(), nil, nil, nil), nil
+}
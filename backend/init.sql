@@ -7,8 +7,11 @@ CREATE EXTENSION IF NOT EXISTS postgis;
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
     email VARCHAR(255) UNIQUE NOT NULL,
+    phone_number VARCHAR(255),
     password VARCHAR(255) NOT NULL,
     role VARCHAR(50) NOT NULL DEFAULT 'spotter',
+    -- Comma-separated channels the user wants notifications on
+    notification_channels VARCHAR(100),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -19,17 +22,196 @@ CREATE TABLE IF NOT EXISTS arrest_events (
     longitude FLOAT NOT NULL,
     notes TEXT,
     created_by INTEGER REFERENCES users(id),
+    legal_hold BOOLEAN NOT NULL DEFAULT FALSE,
+    -- RFC 3161 token over the SHA-256 of the event as created
+    timestamp_record TEXT,
+    timestamp_token BYTEA,
+    timestamped_at TIMESTAMP,
+    -- Outcome of validating an embedded C2PA manifest; NULL without one
+    credentials_status VARCHAR(16),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS media (
     id SERIAL PRIMARY KEY,
-    event_id INTEGER REFERENCES arrest_events(id) ON DELETE CASCADE,
+    -- No cascade: an event can only be deleted once its media is purged
+    event_id INTEGER REFERENCES arrest_events(id),
     file_path VARCHAR(500) NOT NULL,
     type VARCHAR(50) NOT NULL,
+    sha256 VARCHAR(64),
+    key_id VARCHAR(16),
+    wrapped_key TEXT,
+    mime_type VARCHAR(100),
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    extension VARCHAR(16),
+    parent_id INTEGER REFERENCES media(id) ON DELETE SET NULL,
+    redaction TEXT,
+    legal_hold BOOLEAN NOT NULL DEFAULT FALSE,
+    deleted_at TIMESTAMP,
+    deleted_by INTEGER REFERENCES users(id),
+    delete_reason TEXT,
+    purge_after TIMESTAMP,
+    phash BIGINT,
+    scan_status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    scan_result TEXT,
+    scanned_at TIMESTAMP,
+    uploaded_by INTEGER REFERENCES users(id),
+    captured_at TIMESTAMP,
+    capture_source VARCHAR(16),
+    width INTEGER,
+    height INTEGER,
+    duration_seconds DOUBLE PRECISION,
+    upload_ip VARCHAR(64),
+    device_fingerprint VARCHAR(64),
+    processing_status VARCHAR(16) NOT NULL DEFAULT 'done',
+    -- RFC 3161 token over sha256
+    timestamp_token BYTEA,
+    timestamped_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Chain-of-custody log; rows reference media by ID only so they outlive deletion
+CREATE TABLE IF NOT EXISTS custody_log (
+    id SERIAL PRIMARY KEY,
+    media_id INTEGER NOT NULL,
+    event_id INTEGER NOT NULL,
+    user_id INTEGER,
+    action VARCHAR(50) NOT NULL,
+    details TEXT,
+    prev_hash VARCHAR(64) NOT NULL,
+    entry_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_custody_log_media ON custody_log(media_id);
+
+CREATE OR REPLACE FUNCTION custody_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'custody_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS custody_log_no_modify ON custody_log;
+CREATE TRIGGER custody_log_no_modify BEFORE UPDATE OR DELETE ON custody_log
+    FOR EACH ROW EXECUTE FUNCTION custody_log_append_only();
+
+CREATE TABLE IF NOT EXISTS media_metadata (
+    media_id INTEGER PRIMARY KEY REFERENCES media(id) ON DELETE CASCADE,
+    captured_at TIMESTAMP,
+    latitude FLOAT,
+    longitude FLOAT
+);
+
+-- What a media item's C2PA content credentials say, as JSON
+CREATE TABLE IF NOT EXISTS media_credentials (
+    media_id INTEGER PRIMARY KEY REFERENCES media(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL,
+    details TEXT NOT NULL,
+    inspected_at TIMESTAMP NOT NULL
+);
+
+-- Content-addressed files shared by media rows with identical content
+CREATE TABLE IF NOT EXISTS blobs (
+    sha256 VARCHAR(64) PRIMARY KEY,
+    file_path VARCHAR(500) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    key_id VARCHAR(16),
+    wrapped_key TEXT,
+    ref_count INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_event_sha256 ON media(event_id, sha256);
+-- One live upload of a file per event
+CREATE UNIQUE INDEX IF NOT EXISTS idx_media_event_sha256_live ON media(event_id, sha256)
+    WHERE deleted_at IS NULL AND parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_media_event_captured ON media(event_id, captured_at);
+CREATE INDEX IF NOT EXISTS idx_media_uploaded_by ON media(uploaded_by);
+
+CREATE TABLE IF NOT EXISTS media_thumbnails (
+    media_id INTEGER NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+    size VARCHAR(16) NOT NULL,
+    file_path VARCHAR(500) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    key_id VARCHAR(16),
+    wrapped_key TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (media_id, size)
+);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    event_id INTEGER REFERENCES arrest_events(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id),
+    type VARCHAR(50) NOT NULL,
+    length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    sha256 VARCHAR(64),
+    key_id VARCHAR(16),
+    wrapped_key TEXT,
+    media_id INTEGER,
+    duplicate BOOLEAN NOT NULL DEFAULT FALSE,
+    captured_at TIMESTAMP,
+    upload_ip VARCHAR(64),
+    device_fingerprint VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Signed links giving people without an account access to one media item
+-- or a whole event
+CREATE TABLE IF NOT EXISTS share_links (
+    id SERIAL PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES arrest_events(id) ON DELETE CASCADE,
+    media_id INTEGER REFERENCES media(id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES users(id),
+    note TEXT,
+    password_hash VARCHAR(100),
+    max_views INTEGER,
+    view_count INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_event ON share_links(event_id);
+
+CREATE TABLE IF NOT EXISTS share_link_access (
+    id SERIAL PRIMARY KEY,
+    link_id INTEGER NOT NULL REFERENCES share_links(id) ON DELETE CASCADE,
+    outcome VARCHAR(20) NOT NULL,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    accessed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_link_access_link ON share_link_access(link_id);
+
+-- Durable background job queue. A job of a type runs at most once at a
+-- time per media item or event; dead jobs stay until retried.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(64) NOT NULL,
+    media_id INTEGER REFERENCES media(id) ON DELETE CASCADE,
+    event_id INTEGER REFERENCES arrest_events(id) ON DELETE CASCADE,
+    payload TEXT,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMP NOT NULL,
+    locked_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_ready ON jobs(run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_media ON jobs(media_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_pending ON jobs(type, media_id) WHERE status IN ('queued', 'running');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_pending_event ON jobs(type, event_id) WHERE status IN ('queued', 'running');
+
 CREATE TABLE IF NOT EXISTS subscriptions (
     id SERIAL PRIMARY KEY,
     event_id INTEGER REFERENCES arrest_events(id) ON DELETE CASCADE,
@@ -38,6 +220,52 @@ CREATE TABLE IF NOT EXISTS subscriptions (
     UNIQUE(event_id, user_id)
 );
 
+-- Accounts of an event given by its witnesses. Statements are never edited;
+-- they stay when the witness's account is deleted.
+CREATE TABLE IF NOT EXISTS witness_statements (
+    id SERIAL PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES arrest_events(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    statement TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_witness_statements_event ON witness_statements(event_id);
+
+-- Messages advocates send an event's witnesses
+CREATE TABLE IF NOT EXISTS contact_requests (
+    id SERIAL PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES arrest_events(id) ON DELETE CASCADE,
+    sent_by INTEGER REFERENCES users(id),
+    subject TEXT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_contact_requests_event ON contact_requests(event_id);
+
+-- One row per witness and channel of a contact request, written with the
+-- request and worked off by the notification dispatcher. Rows stay queued
+-- until sent or given up on; channel is NULL when no channel could reach
+-- the witness.
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    contact_request_id INTEGER NOT NULL REFERENCES contact_requests(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel VARCHAR(16),
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    locked_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_ready ON notification_outbox(next_attempt_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_request ON notification_outbox(contact_request_id);
+
 -- Insert sample users (passwords are hashed version of "password")
 INSERT INTO users (email, password, role) VALUES 
     ('spotter@example.com', '$2a$14$6b3UlWUGpDJ8Ye7JhzZ5TuJsJ5oL5.K5WkL8ZhzZ5TuJsJ5oL5.K5W', 'spotter'),
@@ -3,15 +3,26 @@ package api
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/protest-tracker/internal/auth"
+	"github.com/protest-tracker/internal/c2pa"
 	"github.com/protest-tracker/internal/config"
+	"github.com/protest-tracker/internal/encryption"
 	"github.com/protest-tracker/internal/handlers"
+	"github.com/protest-tracker/internal/jobs"
 	"github.com/protest-tracker/internal/middleware"
+	"github.com/protest-tracker/internal/notify"
+	"github.com/protest-tracker/internal/realtime"
 	"github.com/protest-tracker/internal/repository"
+	"github.com/protest-tracker/internal/scanner"
 	"github.com/protest-tracker/internal/services"
+	"github.com/protest-tracker/internal/signing"
+	"github.com/protest-tracker/internal/storage"
+	"github.com/protest-tracker/internal/timestamp"
 )
 
 type Server struct {
@@ -19,27 +30,127 @@ type Server struct {
 	config *config.Config
 }
 
-func NewServer(db *sql.DB, cfg *config.Config) *Server {
+func NewServer(db *sql.DB, cfg *config.Config) (*Server, error) {
+	// Load the media master keys
+	keyring, err := encryption.LoadKeyring(cfg.MediaMasterKey, cfg.MediaMasterKeyFile, cfg.MediaPreviousKeys)
+	if err != nil {
+		return nil, err
+	}
+	if keyring == nil {
+		log.Println("WARNING: no media master key configured, uploads will be stored unencrypted")
+	}
+
+	// Load the key evidence exports are signed with
+	signer, err := signing.LoadSigner(cfg.ExportSigningKey, cfg.ExportSigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		log.Println("WARNING: no export signing key configured, evidence exports are disabled")
+	}
+
+	// Set up malware scanning
+	scan, err := scanner.Load(cfg.MalwareScanner, cfg.ClamdAddress, cfg.MalwareScanTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := scan.(scanner.NoOp); ok {
+		log.Println("WARNING: no malware scanner configured, uploads are released without scanning")
+	}
+
+	// Set up trusted timestamping
+	tsa, tsaRoots, err := timestamp.Load(cfg.TSAURL, cfg.TSACAFile, cfg.TSATimeout)
+	if err != nil {
+		return nil, err
+	}
+	if tsa == nil {
+		log.Println("WARNING: no time-stamping authority configured, evidence will not be timestamped")
+	} else if tsaRoots == nil {
+		log.Println("WARNING: no TSA certificate configured, timestamp signers are not checked against a trusted root")
+	}
+
+	// Load the roots content credential signers are trusted under
+	credentialRoots, err := c2pa.LoadTrustAnchors(cfg.C2PATrustAnchors)
+	if err != nil {
+		return nil, err
+	}
+	if credentialRoots == nil {
+		log.Println("WARNING: no C2PA trust anchors configured, content credentials will be reported unverified")
+	}
+
+	// Set up the channels witnesses are contacted on
+	notifiers, err := notify.Load(cfg.NotifyConfig())
+	if err != nil {
+		return nil, err
+	}
+	if len(notifiers) == 0 {
+		log.Println("WARNING: no notification channels configured, witnesses cannot be contacted")
+	}
+
+	// Changes are published through the database to every instance's
+	// realtime feed
+	feed := realtime.NewHub(db)
+	if err := feed.Listen(cfg.DatabaseURL); err != nil {
+		return nil, err
+	}
+
+	// Open the media storage backends
+	store, err := storage.Load(cfg.StorageBackend, cfg.MediaDir, cfg.S3Config())
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
 	eventRepo := repository.NewEventRepository(db)
 	mediaRepo := repository.NewMediaRepository(db)
 	subscriptionRepo := repository.NewSubscriptionRepository(db)
+	uploadRepo := repository.NewUploadRepository(db)
+	shareRepo := repository.NewShareRepository(db)
+	jobRepo := repository.NewJobRepository(db)
+	contactRepo := repository.NewContactRepository(db)
+	statementRepo := repository.NewStatementRepository(db)
 
 	// Initialize auth service
 	authService := auth.NewService(cfg.JWTSecret)
 
 	// Initialize services
 	authSvc := services.NewAuthService(userRepo, authService)
-	eventSvc := services.NewEventService(eventRepo, subscriptionRepo)
-	mediaSvc := services.NewMediaService(mediaRepo, eventRepo, cfg.MediaDir)
-	witnessSvc := services.NewWitnessService(subscriptionRepo, eventRepo)
+	queue := jobs.NewQueue(jobRepo)
+	timestampSvc := services.NewTimestampService(mediaRepo, eventRepo, tsa, tsaRoots)
+	timestampSvc.RegisterJobs(queue)
+	eventSvc := services.NewEventService(eventRepo, subscriptionRepo, timestampSvc, feed)
+	mediaSvc := services.NewMediaService(mediaRepo, eventRepo, store, keyring, scan, cfg.MediaDeleteGrace,
+		services.ProvenanceSettings{RecordIP: cfg.RecordUploadIP, RecordDevice: cfg.RecordDeviceFingerprint},
+		cfg.UploadLimits(), timestampSvc, credentialRoots, feed)
+	mediaSvc.RegisterJobs(queue)
+	witnessSvc := services.NewWitnessService(subscriptionRepo, eventRepo, contactRepo, statementRepo, notifiers, feed)
+	uploadSvc := services.NewUploadService(uploadRepo, eventRepo, mediaSvc, keyring, cfg.UploadDir, cfg.UploadSessionTTL)
+	exportSvc := services.NewExportService(eventRepo, subscriptionRepo, statementRepo, mediaRepo, mediaSvc, timestampSvc, signer)
+	shareSecret := cfg.ShareLinkSecret
+	if shareSecret == "" {
+		shareSecret = cfg.JWTSecret
+	}
+	shareSvc := services.NewShareService(shareRepo, eventRepo, mediaSvc, exportSvc, authService, shareSecret)
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authSvc)
 	eventHandler := handlers.NewEventHandler(eventSvc)
 	mediaHandler := handlers.NewMediaHandler(mediaSvc)
 	witnessHandler := handlers.NewWitnessHandler(witnessSvc)
+	uploadHandler := handlers.NewUploadHandler(uploadSvc)
+	exportHandler := handlers.NewExportHandler(exportSvc)
+	shareHandler := handlers.NewShareHandler(shareSvc)
+	jobHandler := handlers.NewJobHandler(queue)
+	feedHandler := handlers.NewFeedHandler(feed)
+
+	// Background jobs
+	go uploadSvc.RunReaper(10 * time.Minute)
+	go queue.Run(cfg.JobWorkers)
+	go mediaSvc.QueueBacklog()
+	go timestampSvc.QueueBacklog()
+	go mediaSvc.RunPurger(time.Hour)
+	go witnessSvc.RunDispatcher(cfg.NotifyWorkers)
 
 	// Create server
 	server := &Server{
@@ -48,9 +159,9 @@ func NewServer(db *sql.DB, cfg *config.Config) *Server {
 	}
 
 	// Setup routes
-	server.setupRoutes(authHandler, eventHandler, mediaHandler, witnessHandler, cfg.JWTSecret)
+	server.setupRoutes(authHandler, eventHandler, mediaHandler, witnessHandler, uploadHandler, exportHandler, shareHandler, jobHandler, feedHandler, cfg.JWTSecret)
 
-	return server
+	return server, nil
 }
 
 func (s *Server) setupRoutes(
@@ -58,6 +169,11 @@ func (s *Server) setupRoutes(
 	eventHandler *handlers.EventHandler,
 	mediaHandler *handlers.MediaHandler,
 	witnessHandler *handlers.WitnessHandler,
+	uploadHandler *handlers.UploadHandler,
+	exportHandler *handlers.ExportHandler,
+	shareHandler *handlers.ShareHandler,
+	jobHandler *handlers.JobHandler,
+	feedHandler *handlers.FeedHandler,
 	jwtSecret string,
 ) {
 	// Apply CORS middleware
@@ -67,11 +183,24 @@ func (s *Server) setupRoutes(
 	s.router.HandleFunc("/api/login", authHandler.Login).Methods("POST", "OPTIONS")
 	s.router.HandleFunc("/api/register", authHandler.Register).Methods("POST", "OPTIONS")
 
+	// Public key for verifying evidence exports offline
+	s.router.HandleFunc("/api/export-signing-key", exportHandler.GetSigningKey).Methods("GET")
+
+	// Share links; the signed token in the URL is the credential
+	s.router.HandleFunc("/api/share/{token}", shareHandler.GetShared).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/share/{token}/content", shareHandler.GetSharedContent).Methods("GET", "OPTIONS")
+
 	// Health check
 	s.router.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		handlers.RespondJSON(w, map[string]string{"status": "ok"})
 	}).Methods("GET")
 
+	// Realtime feed. EventSource cannot send headers, so the token may
+	// also be given in the query.
+	feed := s.router.PathPrefix("/api/feed").Subrouter()
+	feed.Use(middleware.QueryTokenMiddleware, middleware.AuthMiddleware(jwtSecret))
+	feed.HandleFunc("", feedHandler.Stream).Methods("GET")
+
 	// Protected routes
 	api := s.router.PathPrefix("/api").Subrouter()
 	api.Use(middleware.AuthMiddleware(jwtSecret))
@@ -83,9 +212,22 @@ func (s *Server) setupRoutes(
 	api.HandleFunc("/events/{id}", eventHandler.UpdateEvent).Methods("PUT", "OPTIONS")
 	api.HandleFunc("/events/{id}/subscribe", eventHandler.SubscribeEvent).Methods("POST", "OPTIONS")
 	api.HandleFunc("/events/{id}/subscribe", eventHandler.UnsubscribeEvent).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/events/{id}/statements", witnessHandler.SubmitStatement).Methods("POST", "OPTIONS")
+
+	// How the current user wants to be notified
+	api.HandleFunc("/me/notifications", authHandler.GetNotificationPreferences).Methods("GET", "OPTIONS")
+	api.HandleFunc("/me/notifications", authHandler.UpdateNotificationPreferences).Methods("PUT", "OPTIONS")
 
 	// Media upload (accessible to spotters)
 	api.HandleFunc("/events/{id}/media", mediaHandler.UploadMedia).Methods("POST", "OPTIONS")
+	api.HandleFunc("/events/{id}/media/batch", mediaHandler.UploadMediaBatch).Methods("POST", "OPTIONS")
+	api.HandleFunc("/quota", mediaHandler.GetQuota).Methods("GET", "OPTIONS")
+
+	// Resumable uploads (tus protocol)
+	api.HandleFunc("/events/{id}/uploads", uploadHandler.CreateUpload).Methods("POST", "OPTIONS")
+	api.HandleFunc("/events/{id}/uploads/{uploadId}", uploadHandler.GetUploadOffset).Methods("HEAD", "OPTIONS")
+	api.HandleFunc("/events/{id}/uploads/{uploadId}", uploadHandler.PatchUpload).Methods("PATCH", "OPTIONS")
+	api.HandleFunc("/events/{id}/uploads/{uploadId}", uploadHandler.DeleteUpload).Methods("DELETE", "OPTIONS")
 
 	// Advocate-only routes
 	advocateRoutes := api.PathPrefix("").Subrouter()
@@ -94,10 +236,47 @@ func (s *Server) setupRoutes(
 	// Media viewing (advocates only)
 	advocateRoutes.HandleFunc("/events/{id}/media", mediaHandler.GetEventMedia).Methods("GET", "OPTIONS")
 	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}", mediaHandler.GetMedia).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}", mediaHandler.DeleteMedia).Methods("DELETE", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/restore", mediaHandler.RestoreMedia).Methods("POST", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/legal-hold", mediaHandler.SetLegalHold).Methods("PUT", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/deleted-media", mediaHandler.GetDeletedMedia).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/quarantined-media", mediaHandler.GetQuarantinedMedia).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/quota", mediaHandler.GetEventQuota).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/scan", mediaHandler.RescanMedia).Methods("POST", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/jobs", mediaHandler.GetMediaJobs).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/content", mediaHandler.GetMediaContent).Methods("GET", "HEAD", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/thumbnail", mediaHandler.GetMediaThumbnail).Methods("GET", "HEAD", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/redactions", mediaHandler.RedactMedia).Methods("POST", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/versions", mediaHandler.GetMediaVersions).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/similar", mediaHandler.GetSimilarMedia).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/similar-media", mediaHandler.GetSimilarAcrossEvents).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/metadata", mediaHandler.GetMediaMetadata).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/content-credentials", mediaHandler.GetContentCredentials).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/custody", mediaHandler.GetCustodyLog).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/media/{mediaId}/verify", mediaHandler.VerifyMedia).Methods("POST", "OPTIONS")
+
+	// Legal hold (advocates only)
+	advocateRoutes.HandleFunc("/events/{id}/legal-hold", eventHandler.SetLegalHold).Methods("PUT", "OPTIONS")
+
+	// Evidence export (advocates only)
+	advocateRoutes.HandleFunc("/events/{id}/export", exportHandler.ExportEvent).Methods("GET", "OPTIONS")
+
+	// Share links (advocates only)
+	advocateRoutes.HandleFunc("/events/{id}/share-links", shareHandler.CreateShareLink).Methods("POST", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/share-links", shareHandler.GetShareLinks).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/share-links/{linkId}", shareHandler.RevokeShareLink).Methods("DELETE", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/share-links/{linkId}/access", shareHandler.GetShareAccessLog).Methods("GET", "OPTIONS")
+
+	// Background jobs that failed for good (advocates only)
+	advocateRoutes.HandleFunc("/jobs/dead", jobHandler.GetDeadJobs).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/jobs/{jobId}/retry", jobHandler.RetryJob).Methods("POST", "OPTIONS")
 
 	// Witness contact (advocates only)
 	advocateRoutes.HandleFunc("/events/{id}/contact-witnesses", witnessHandler.ContactWitnesses).Methods("POST", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/contact-requests", witnessHandler.GetContactRequests).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/contact-requests/{requestId}", witnessHandler.GetContactRequest).Methods("GET", "OPTIONS")
 	advocateRoutes.HandleFunc("/events/{id}/witness-count", witnessHandler.GetWitnessCount).Methods("GET", "OPTIONS")
+	advocateRoutes.HandleFunc("/events/{id}/statements", witnessHandler.GetStatements).Methods("GET", "OPTIONS")
 }
 
 func (s *Server) Start() error {
@@ -0,0 +1,223 @@
+package c2pa
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+)
+
+// errUncheckable marks a hard binding this package cannot evaluate. The
+// manifest may be fine; it just cannot be tied to the content here.
+var errUncheckable = errors.New("content binding cannot be checked")
+
+var hashAlgorithms = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+func newHash(alg string) (hash.Hash, error) {
+	h, ok := hashAlgorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", alg)
+	}
+	return h.New(), nil
+}
+
+// span is a byte range of the file
+type span struct {
+	Start, Length int64
+}
+
+// hashSpan feeds bytes [start, end) of r, less any excluded spans, to h
+func hashSpan(h hash.Hash, r io.ReadSeeker, start, end int64, excluded []span) error {
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].Start < excluded[j].Start })
+	pos := start
+	copyTo := func(to int64) error {
+		if to <= pos {
+			return nil
+		}
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(h, r, to-pos); err != nil {
+			return err
+		}
+		pos = to
+		return nil
+	}
+
+	for _, ex := range excluded {
+		if err := copyTo(min(ex.Start, end)); err != nil {
+			return err
+		}
+		if next := ex.Start + ex.Length; next > pos {
+			pos = min(next, end)
+		}
+	}
+	return copyTo(end)
+}
+
+// checkDataHash verifies a c2pa.hash.data assertion: a hash of the whole
+// file except the byte ranges it excludes, which hold the manifest
+func checkDataHash(assertion map[string]interface{}, claimAlg string, r io.ReadSeeker, size int64) error {
+	expected, _ := assertion["hash"].([]byte)
+	if len(expected) == 0 {
+		return errors.New("data hash assertion has no hash")
+	}
+	h, err := newHash(stringField(assertion, "alg", claimAlg))
+	if err != nil {
+		return err
+	}
+
+	var excluded []span
+	list, _ := assertion["exclusions"].([]interface{})
+	for _, item := range list {
+		ex, _ := item.(map[string]interface{})
+		start, ok1 := intField(ex, "start")
+		length, ok2 := intField(ex, "length")
+		if !ok1 || !ok2 || start < 0 || length < 0 || start+length > size {
+			return errors.New("data hash assertion has an invalid exclusion")
+		}
+		excluded = append(excluded, span{start, length})
+	}
+
+	if err = hashSpan(h, r, 0, size, excluded); err != nil {
+		return fmt.Errorf("failed to hash content: %v", err)
+	}
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return errors.New("content has been modified since it was signed")
+	}
+	return nil
+}
+
+// checkBMFFHash verifies a c2pa.hash.bmff assertion. Top-level boxes
+// matching an exclusion, or the parts of them it names, are left out; from
+// version 2 each hashed box is preceded by its 8-byte file offset, so boxes
+// cannot be reordered. Merkle trees over fragmented media and exclusions
+// of nested boxes are not supported.
+func checkBMFFHash(assertion map[string]interface{}, version int, claimAlg string, r io.ReadSeeker, boxes []bmffBox) error {
+	expected, _ := assertion["hash"].([]byte)
+	if len(expected) == 0 || assertion["merkle"] != nil {
+		return errUncheckable
+	}
+	h, err := newHash(stringField(assertion, "alg", claimAlg))
+	if err != nil {
+		return err
+	}
+
+	list, _ := assertion["exclusions"].([]interface{})
+	var rules []map[string]interface{}
+	for _, item := range list {
+		rule, _ := item.(map[string]interface{})
+		xpath, _ := rule["xpath"].(string)
+		if !strings.HasPrefix(xpath, "/") || strings.ContainsAny(xpath[1:], "/[") {
+			return errUncheckable
+		}
+		rules = append(rules, rule)
+	}
+
+	for _, b := range boxes {
+		var excluded []span
+		whole := false
+		for _, rule := range rules {
+			matched, err := matchesExclusion(r, b, rule)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+			subsets, _ := rule["subset"].([]interface{})
+			if len(subsets) == 0 {
+				whole = true
+				break
+			}
+			for _, item := range subsets {
+				sub, _ := item.(map[string]interface{})
+				offset, _ := intField(sub, "offset")
+				length, _ := intField(sub, "length")
+				if length == 0 || offset+length > b.Size {
+					length = b.Size - offset // zero means to the end of the box
+				}
+				if offset < 0 || length < 0 {
+					return errors.New("BMFF hash assertion has an invalid exclusion")
+				}
+				excluded = append(excluded, span{b.Offset + offset, length})
+			}
+		}
+		if whole {
+			continue
+		}
+
+		if version >= 2 {
+			var offset [8]byte
+			binary.BigEndian.PutUint64(offset[:], uint64(b.Offset))
+			h.Write(offset[:])
+		}
+		if err = hashSpan(h, r, b.Offset, b.Offset+b.Size, excluded); err != nil {
+			return fmt.Errorf("failed to hash content: %v", err)
+		}
+	}
+
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return errors.New("content has been modified since it was signed")
+	}
+	return nil
+}
+
+// matchesExclusion reports whether a top-level box meets an exclusion's
+// type, length, version, flags and data constraints
+func matchesExclusion(r io.ReadSeeker, b bmffBox, rule map[string]interface{}) (bool, error) {
+	xpath, _ := rule["xpath"].(string)
+	if xpath[1:] != b.Type {
+		return false, nil
+	}
+	if length, ok := intField(rule, "length"); ok && length != b.Size {
+		return false, nil
+	}
+
+	readAt := func(offset int64, n int) ([]byte, error) {
+		if offset < 0 || offset+int64(n) > b.Size {
+			return nil, nil
+		}
+		if _, err := r.Seek(b.Offset+offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+
+	// Full box version and flags follow the header
+	if version, ok := intField(rule, "version"); ok {
+		got, err := readAt(b.Header, 1)
+		if err != nil || got == nil || int64(got[0]) != version {
+			return false, err
+		}
+	}
+	if flags, ok := rule["flags"].([]byte); ok {
+		got, err := readAt(b.Header+1, len(flags))
+		if err != nil || !bytes.Equal(got, flags) {
+			return false, err
+		}
+	}
+
+	constraints, _ := rule["data"].([]interface{})
+	for _, item := range constraints {
+		constraint, _ := item.(map[string]interface{})
+		offset, _ := intField(constraint, "offset")
+		value, _ := constraint["value"].([]byte)
+		got, err := readAt(offset, len(value))
+		if err != nil || got == nil || !bytes.Equal(got, value) {
+			return false, err
+		}
+	}
+	return true, nil
+}
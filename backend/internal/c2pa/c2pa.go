@@ -0,0 +1,284 @@
+// Package c2pa reads and validates C2PA content credentials: the signed
+// manifests cameras and editing tools embed in JPEG and ISO base media
+// (MP4, MOV) files to record who made the file, with what, and how it has
+// been edited since.
+package c2pa
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrNoManifest is returned for files without content credentials, or in
+// a format this package cannot read them from
+var ErrNoManifest = errors.New("no C2PA manifest")
+
+// Validation outcomes
+const (
+	// Signature, assertions and content all check out, and the signer
+	// chains to a trust anchor
+	StatusValid = "valid"
+	// Nothing failed, but the signer is not known to be trustworthy or
+	// part of the manifest could not be checked
+	StatusUnverified = "unverified"
+	// The manifest is malformed, its signature is bad, or the content or
+	// an assertion was changed after signing
+	StatusInvalid = "invalid"
+)
+
+// Report describes the content credentials of a file
+type Report struct {
+	Status string
+	// Why the status is invalid, or unverified
+	Problems []string
+	Warnings []string
+
+	// Subject and issuer of the certificate that signed the active
+	// manifest
+	Signer string
+	Issuer string
+
+	// The software that made the active manifest, the capture device it
+	// claims, and the title it gives the file
+	ClaimGenerator string
+	Device         string
+	Title          string
+
+	// What was done to the file, from the oldest manifest in the store to
+	// the active one, and the files it was made from
+	Actions     []Action
+	Ingredients []Ingredient
+	Manifests   int
+}
+
+// Action is an entry in a manifest's edit history, such as c2pa.created or
+// c2pa.cropped
+type Action struct {
+	Action        string
+	When          string
+	SoftwareAgent string
+	Description   string
+}
+
+// Ingredient is a file the content was made from
+type Ingredient struct {
+	Title        string
+	Format       string
+	Relationship string
+}
+
+// LoadTrustAnchors reads the certificates content credential signers are
+// trusted under from the PEM file path. It returns nil when path is empty.
+func LoadTrustAnchors(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read C2PA trust anchors: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return roots, nil
+}
+
+// Inspect reads the manifest store embedded in r and validates the active
+// manifest: its claim signature, the hashes of its assertions and the hash
+// binding it to the content. Older manifests are checked too, except for
+// the binding, which only holds for the file as it is now. Signers are
+// trusted if they chain to roots; with no roots, none are.
+func Inspect(r io.ReadSeeker, roots *x509.CertPool) (*Report, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	format, err := sniff(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var boxes []bmffBox
+	switch format {
+	case formatJPEG:
+		data, err = jpegStore(r)
+	case formatBMFF:
+		if boxes, err = bmffBoxes(r); err == nil {
+			data, err = bmffStore(r, boxes)
+		}
+	default:
+		return nil, ErrNoManifest
+	}
+	if err == errTooLarge {
+		return invalid(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrNoManifest
+	}
+
+	manifests, err := parseStore(data)
+	if err != nil {
+		return invalid(fmt.Sprintf("manifest store is malformed: %v", err)), nil
+	}
+
+	report := &Report{Manifests: len(manifests)}
+	active := manifests[len(manifests)-1]
+	for _, m := range manifests {
+		prefix := ""
+		if m != active {
+			prefix = fmt.Sprintf("ingredient manifest %s: ", m.Label)
+		}
+		for _, problem := range m.Problems {
+			report.Problems = append(report.Problems, prefix+problem)
+		}
+		if m.Claim == nil {
+			continue
+		}
+
+		sig, err := verifySignature(m.Signature, m.Claim)
+		if err != nil {
+			report.Problems = append(report.Problems, prefix+err.Error())
+		}
+		report.Actions = append(report.Actions, actions(m)...)
+		if m != active {
+			continue
+		}
+
+		report.ClaimGenerator, report.Title = m.Generator, m.Title
+		report.Device = device(m)
+		report.Ingredients = ingredients(m)
+		if sig != nil {
+			leaf := sig.Chain[0]
+			report.Signer = signerName(leaf.Subject.CommonName, leaf.Subject.Organization)
+			report.Issuer = signerName(leaf.Issuer.CommonName, leaf.Issuer.Organization)
+			if roots == nil {
+				report.Warnings = append(report.Warnings, "no trust anchors are configured, so the signer is not verified")
+			} else if err = sig.checkTrust(roots); err != nil {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("signer is not trusted: %v", err))
+			}
+		}
+		if err = checkBinding(m, r, boxes); err == errUncheckable {
+			report.Warnings = append(report.Warnings, err.Error())
+		} else if err != nil {
+			report.Problems = append(report.Problems, err.Error())
+		}
+	}
+
+	switch {
+	case len(report.Problems) > 0:
+		report.Status = StatusInvalid
+	case len(report.Warnings) > 0:
+		report.Status = StatusUnverified
+	default:
+		report.Status = StatusValid
+	}
+	return report, nil
+}
+
+func invalid(problem string) *Report {
+	return &Report{Status: StatusInvalid, Problems: []string{problem}}
+}
+
+// checkBinding verifies the hard binding assertion that ties the active
+// manifest to the file's content
+func checkBinding(m *manifest, r io.ReadSeeker, boxes []bmffBox) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	for _, a := range m.Assertions {
+		switch {
+		case a.Base == "c2pa.hash.data":
+			return checkDataHash(a.Value, m.Alg, r, size)
+		case a.Base == "c2pa.hash.bmff":
+			if boxes == nil {
+				return errors.New("manifest binds to a media file, not this one")
+			}
+			version := 1
+			if strings.HasPrefix(a.Label, "c2pa.hash.bmff.v") {
+				fmt.Sscanf(a.Label, "c2pa.hash.bmff.v%d", &version)
+			}
+			return checkBMFFHash(a.Value, version, m.Alg, r, boxes)
+		case strings.HasPrefix(a.Base, "c2pa.hash."):
+			return errUncheckable
+		}
+	}
+	return errUncheckable
+}
+
+// actions lists the edit history recorded in a manifest
+func actions(m *manifest) []Action {
+	var list []Action
+	for _, a := range m.Assertions {
+		if a.Base != "c2pa.actions" {
+			continue
+		}
+		entries, _ := a.Value["actions"].([]interface{})
+		for _, item := range entries {
+			entry, _ := item.(map[string]interface{})
+			action := Action{
+				Action:      stringField(entry, "action", ""),
+				When:        stringField(entry, "when", ""),
+				Description: stringField(entry, "description", ""),
+			}
+			// A plain string before version 2 of the assertion
+			switch agent := entry["softwareAgent"].(type) {
+			case string:
+				action.SoftwareAgent = agent
+			case map[string]interface{}:
+				action.SoftwareAgent = generatorName(map[string]interface{}{"claim_generator_info": agent})
+			}
+			if action.Action != "" {
+				list = append(list, action)
+			}
+		}
+	}
+	return list
+}
+
+// ingredients lists the files a manifest says the content was made from
+func ingredients(m *manifest) []Ingredient {
+	var list []Ingredient
+	for _, a := range m.Assertions {
+		if a.Base != "c2pa.ingredient" {
+			continue
+		}
+		list = append(list, Ingredient{
+			Title:        stringField(a.Value, "dc:title", ""),
+			Format:       stringField(a.Value, "dc:format", ""),
+			Relationship: stringField(a.Value, "relationship", ""),
+		})
+	}
+	return list
+}
+
+// device names the camera a manifest claims took the picture: from its
+// EXIF assertion or, failing that, the agent of its c2pa.created action
+func device(m *manifest) string {
+	for _, a := range m.Assertions {
+		if a.Base != "stds.exif" {
+			continue
+		}
+		maker, model := stringField(a.Value, "exif:Make", ""), stringField(a.Value, "exif:Model", "")
+		if strings.HasPrefix(model, maker) {
+			maker = "" // models often repeat the maker
+		}
+		if name := strings.TrimSpace(maker + " " + model); name != "" {
+			return name
+		}
+	}
+	for _, action := range actions(m) {
+		if action.Action == "c2pa.created" && action.SoftwareAgent != "" {
+			return action.SoftwareAgent
+		}
+	}
+	return ""
+}
@@ -0,0 +1,254 @@
+package c2pa
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigner is a self-signed content credential signer
+type testSigner struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test Camera", Organization: []string{"Example Cameras"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testSigner{cert: cert, key: key}
+}
+
+func (s *testSigner) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.cert)
+	return pool
+}
+
+// sign returns a COSE_Sign1 signature over claim, with r and s as
+// fixed-size integers
+func (s *testSigner) sign(t *testing.T, claim []byte) []byte {
+	t.Helper()
+	protected := encodeCBOR(map[int]interface{}{1: -7, 33: s.cert.Raw})
+
+	var message []byte
+	message = append(message, 0x84)
+	message = append(message, cborText("Signature1")...)
+	message = append(message, cborBytes(protected)...)
+	message = append(message, cborBytes(nil)...)
+	message = append(message, cborBytes(claim)...)
+	digest := sha256.Sum256(message)
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := append(r.FillBytes(make([]byte, 32)), sig.FillBytes(make([]byte, 32))...)
+
+	return encodeCBOR([]interface{}{protected, map[string]interface{}{}, nil, raw})
+}
+
+// jpegOptions change how a test JPEG's credentials are built
+type jpegOptions struct {
+	// The action signed for, and the one actually stored
+	action, storedAction string
+	// generator is signed for; storedGenerator replaces it after signing
+	generator, storedGenerator string
+}
+
+// scanData stands in for the compressed image
+var scanData = []byte{0xFF, 0xDA, 0x00, 0x04, 0x01, 0x02, 0x11, 0x22, 0x33, 0x44, 0xFF, 0xD9}
+
+// testJPEG builds a JPEG carrying a one-manifest store in an APP11
+// segment, bound to the rest of the file by a data hash
+func (s *testSigner) testJPEG(t *testing.T, opts jpegOptions) []byte {
+	t.Helper()
+	if opts.action == "" {
+		opts.action = "c2pa.created"
+	}
+	if opts.storedAction == "" {
+		opts.storedAction = opts.action
+	}
+	if opts.generator == "" {
+		opts.generator = "Test Camera 1.0"
+	}
+	if opts.storedGenerator == "" {
+		opts.storedGenerator = opts.generator
+	}
+
+	build := func(segmentLength int, contentHash []byte) []byte {
+		dataHash := superbox("c2pa.hash.data", jumbfBox("cbor", encodeCBOR(map[string]interface{}{
+			"alg":        "sha256",
+			"hash":       contentHash,
+			"exclusions": []interface{}{map[string]interface{}{"start": 2, "length": segmentLength}},
+		})))
+		actionsFor := func(action string) []byte {
+			return superbox("c2pa.actions", jumbfBox("cbor", encodeCBOR(map[string]interface{}{
+				"actions": []interface{}{map[string]interface{}{"action": action, "softwareAgent": "Test Camera"}},
+			})))
+		}
+		signedActions := actionsFor(opts.action)
+		payloadHash := func(b []byte) []byte {
+			boxes, err := parseBoxes(b, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(boxes[0].Payload)
+			return sum[:]
+		}
+
+		claimFor := func(generator string) []byte {
+			return encodeCBOR(map[string]interface{}{
+				"alg":             "sha256",
+				"claim_generator": generator,
+				"dc:title":        "arrest.jpg",
+				"assertions": []interface{}{
+					map[string]interface{}{"url": "self#jumbf=c2pa.assertions/c2pa.hash.data", "hash": payloadHash(dataHash)},
+					map[string]interface{}{"url": "self#jumbf=/c2pa/urn:uuid:1/c2pa.assertions/c2pa.actions", "hash": payloadHash(signedActions)},
+				},
+			})
+		}
+		signature := s.sign(t, claimFor(opts.generator))
+
+		store := superbox("c2pa",
+			superbox("urn:uuid:1",
+				superbox("c2pa.assertions", dataHash, actionsFor(opts.storedAction)),
+				superbox("c2pa.claim", jumbfBox("cbor", claimFor(opts.storedGenerator))),
+				superbox("c2pa.signature", jumbfBox("cbor", signature)),
+			),
+		)
+
+		segment := []byte("JP\x00\x01\x00\x00\x00\x01")
+		segment = append(segment, store...)
+		file := []byte{0xFF, 0xD8, 0xFF, 0xEB}
+		file = binary.BigEndian.AppendUint16(file, uint16(len(segment)+2))
+		file = append(file, segment...)
+		return append(file, scanData...)
+	}
+
+	// The segment's length is stable once the exclusion describes it, and
+	// the content hash skips the segment, so it can be filled in last
+	placeholder := make([]byte, sha256.Size)
+	length := 0
+	for i := 0; i < 3; i++ {
+		length = len(build(length, placeholder)) - 2 - len(scanData)
+	}
+	file := build(length, placeholder)
+	sum := sha256.Sum256(append([]byte{0xFF, 0xD8}, scanData...))
+	signed := build(length, sum[:])
+	if len(signed) != len(file) {
+		t.Fatal("segment length changed with the content hash")
+	}
+	return signed
+}
+
+func TestInspect(t *testing.T) {
+	signer := newTestSigner(t)
+	valid := signer.testJPEG(t, jpegOptions{})
+	editedScan := bytes.Clone(valid)
+	editedScan[len(editedScan)-4] ^= 0xFF
+
+	tests := []struct {
+		name     string
+		file     []byte
+		roots    *x509.CertPool
+		status   string
+		mentions string
+	}{
+		{"valid", valid, signer.roots(), StatusValid, ""},
+		{"no trust anchors", valid, nil, StatusUnverified, "no trust anchors"},
+		{"untrusted signer", valid, newTestSigner(t).roots(), StatusUnverified, "signer is not trusted"},
+		{"content edited", editedScan, signer.roots(), StatusInvalid, "content has been modified"},
+		{
+			"assertion replaced",
+			signer.testJPEG(t, jpegOptions{action: "c2pa.created", storedAction: "c2pa.cropped"}),
+			signer.roots(), StatusInvalid, "does not match its hash",
+		},
+		{
+			"claim edited after signing",
+			signer.testJPEG(t, jpegOptions{generator: "Test Camera 1.0", storedGenerator: "Test Camera 2.0"}),
+			signer.roots(), StatusInvalid, "claim signature is invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Inspect(bytes.NewReader(tt.file), tt.roots)
+			if err != nil {
+				t.Fatalf("Inspect: %v", err)
+			}
+			if report.Status != tt.status {
+				t.Fatalf("status = %q, want %q; problems %v, warnings %v", report.Status, tt.status, report.Problems, report.Warnings)
+			}
+			notes := strings.Join(append(report.Problems, report.Warnings...), "; ")
+			if tt.mentions != "" && !strings.Contains(notes, tt.mentions) {
+				t.Errorf("report says %q, want a mention of %q", notes, tt.mentions)
+			}
+			if report.Signer != "" && report.Signer != "Example Cameras (Test Camera)" {
+				t.Errorf("signer = %q", report.Signer)
+			}
+		})
+	}
+}
+
+func TestInspectReport(t *testing.T) {
+	signer := newTestSigner(t)
+	report, err := Inspect(bytes.NewReader(signer.testJPEG(t, jpegOptions{})), signer.roots())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Manifests != 1 || report.ClaimGenerator != "Test Camera 1.0" || report.Title != "arrest.jpg" {
+		t.Errorf("report = %+v", report)
+	}
+	want := Action{Action: "c2pa.created", SoftwareAgent: "Test Camera"}
+	if len(report.Actions) != 1 || report.Actions[0] != want {
+		t.Errorf("actions = %+v, want [%+v]", report.Actions, want)
+	}
+	if report.Device != "Test Camera" {
+		t.Errorf("device = %q, want the agent of c2pa.created", report.Device)
+	}
+}
+
+func TestInspectWithoutManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"plain JPEG", append([]byte{0xFF, 0xD8}, scanData...)},
+		{"JPEG with other APP11 data", append([]byte{0xFF, 0xD8, 0xFF, 0xEB, 0x00, 0x04, 'x', 'y'}, scanData...)},
+		{"empty MP4", append([]byte{0, 0, 0, 16}, "ftypisom\x00\x00\x00\x00"...)},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n")},
+		{"empty file", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if report, err := Inspect(bytes.NewReader(tt.file), nil); err != ErrNoManifest {
+				t.Errorf("Inspect = %+v, %v; want ErrNoManifest", report, err)
+			}
+		})
+	}
+}
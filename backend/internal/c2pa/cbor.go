@@ -0,0 +1,216 @@
+package c2pa
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth bounds nesting so hostile manifests cannot exhaust the stack
+const maxCBORDepth = 64
+
+var errCBOR = errors.New("malformed CBOR")
+
+// decodeCBOR decodes one CBOR (RFC 8949) item into Go values: int64 or
+// uint64, float64, bool, nil, []byte, string, []interface{} and
+// map[string]interface{}. Integer map keys, as used by COSE headers, become
+// their decimal string. Tags are dropped in favour of the tagged value.
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	return d.value(0)
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBOR
+	}
+	out := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return out, nil
+}
+
+// head reads an item's major type and argument. An indefinite length has
+// info 31.
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, err error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1F
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		raw, err := d.take(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range raw {
+			arg = arg<<8 | uint64(c)
+		}
+	case info == 31:
+		// indefinite length
+	default:
+		return 0, 0, 0, errCBOR
+	}
+	return major, info, arg, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errCBOR
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == 31
+	if indefinite && (major < 2 || major > 5) {
+		return nil, errCBOR
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		var out []byte
+		if indefinite {
+			for {
+				if d.pos < len(d.data) && d.data[d.pos] == 0xFF {
+					d.pos++
+					break
+				}
+				chunkMajor, chunkInfo, n, err := d.head()
+				if err != nil || chunkMajor != major || chunkInfo == 31 {
+					return nil, errCBOR
+				}
+				chunk, err := d.take(n)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, chunk...)
+			}
+		} else if out, err = d.take(arg); err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(out), nil
+		}
+		return out, nil
+	case 4:
+		var list []interface{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && d.pos < len(d.data) && d.data[d.pos] == 0xFF {
+				d.pos++
+				break
+			}
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case 5:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && d.pos < len(d.data) && d.data[d.pos] == 0xFF {
+				d.pos++
+				break
+			}
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case string, int64, uint64:
+				m[fmt.Sprint(key)] = item
+			default:
+				return nil, errCBOR
+			}
+		}
+		return m, nil
+	case 6:
+		return d.value(depth + 1)
+	}
+
+	// Major type 7: simple values and floats
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfFloat(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, errCBOR
+}
+
+func halfFloat(bits uint16) float64 {
+	exp := int(bits>>10) & 0x1F
+	mant := float64(bits & 0x3FF)
+	var value float64
+	switch exp {
+	case 0:
+		value = math.Ldexp(mant, -24)
+	case 31:
+		value = math.Inf(1)
+		if mant != 0 {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mant+1024, exp-25)
+	}
+	if bits&0x8000 != 0 {
+		value = -value
+	}
+	return value
+}
+
+// cborBytes encodes a definite-length byte string
+func cborBytes(data []byte) []byte {
+	return append(cborHead(2, uint64(len(data))), data...)
+}
+
+// cborText encodes a text string
+func cborText(text string) []byte {
+	return append(cborHead(3, uint64(len(text))), text...)
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= math.MaxUint8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
@@ -0,0 +1,153 @@
+package c2pa
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// encodeCBOR encodes the values decodeCBOR produces, plus int and maps
+// with integer keys for COSE headers. Map keys are sorted so encodings are
+// stable.
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return []byte{0xF6}
+	case bool:
+		if v {
+			return []byte{0xF5}
+		}
+		return []byte{0xF4}
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case string:
+		return cborText(v)
+	case []byte:
+		return cborBytes(v)
+	case []interface{}:
+		out := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		out := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			out = append(out, cborText(key)...)
+			out = append(out, encodeCBOR(v[key])...)
+		}
+		return out
+	case map[int]interface{}:
+		keys := make([]int, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Ints(keys)
+		out := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			out = append(out, encodeCBOR(key)...)
+			out = append(out, encodeCBOR(v[key])...)
+		}
+		return out
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"small int", []byte{0x17}, int64(23)},
+		{"one-byte int", []byte{0x18, 0xFF}, int64(255)},
+		{"eight-byte int", []byte{0x1B, 0, 0, 0, 1, 0, 0, 0, 0}, int64(1 << 32)},
+		{"uint64 beyond int64", []byte{0x1B, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, uint64(math.MaxUint64)},
+		{"negative int", []byte{0x26}, int64(-7)},
+		{"byte string", []byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{"text", []byte{0x62, 'h', 'i'}, "hi"},
+		{"indefinite text", []byte{0x7F, 0x61, 'a', 0x62, 'b', 'c', 0xFF}, "abc"},
+		{"array", []byte{0x82, 0x01, 0x61, 'x'}, []interface{}{int64(1), "x"}},
+		{"indefinite array", []byte{0x9F, 0x01, 0x02, 0xFF}, []interface{}{int64(1), int64(2)}},
+		{"map", []byte{0xA1, 0x61, 'k', 0xF5}, map[string]interface{}{"k": true}},
+		{"integer map keys", []byte{0xA2, 0x01, 0x26, 0x20, 0xF6}, map[string]interface{}{"1": int64(-7), "-1": nil}},
+		{"tag is dropped", []byte{0xC0, 0x61, 'x'}, "x"},
+		{"half float", []byte{0xF9, 0x3E, 0x00}, 1.5},
+		{"negative half float", []byte{0xF9, 0xC4, 0x00}, -4.0},
+		{"single float", []byte{0xFA, 0x3F, 0xC0, 0, 0}, 1.5},
+		{"double float", []byte{0xFB, 0x3F, 0xF8, 0, 0, 0, 0, 0, 0}, 1.5},
+		{"false", []byte{0xF4}, false},
+		{"undefined", []byte{0xF7}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCBOR(tt.data)
+			if err != nil {
+				t.Fatalf("decodeCBOR: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCBOR = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejectsMalformed(t *testing.T) {
+	nested := make([]byte, maxCBORDepth+2)
+	for i := range nested {
+		nested[i] = 0x81 // an array of one item, all the way down
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated argument", []byte{0x19, 0x01}},
+		{"string longer than the data", []byte{0x45, 1, 2}},
+		{"array missing items", []byte{0x83, 0x01}},
+		{"reserved additional info", []byte{0x1C}},
+		{"indefinite int", []byte{0x1F}},
+		{"indefinite text with a byte chunk", []byte{0x7F, 0x41, 'a', 0xFF}},
+		{"unterminated indefinite array", []byte{0x9F, 0x01}},
+		{"negative int beyond int64", []byte{0x3B, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"array map key", []byte{0xA1, 0x80, 0x01}},
+		{"unassigned simple value", []byte{0xE0}},
+		{"nested too deeply", nested},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := decodeCBOR(tt.data); err == nil {
+				t.Errorf("decodeCBOR = %#v, want an error", got)
+			}
+		})
+	}
+}
+
+// Lengths at each size of argument survive a round trip
+func TestCBORHeadRoundTrip(t *testing.T) {
+	for _, n := range []int64{0, 23, 24, 255, 256, math.MaxUint16, math.MaxUint16 + 1, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64} {
+		t.Run(strconv.FormatInt(n, 10), func(t *testing.T) {
+			for _, v := range []int64{n, -1 - n} {
+				got, err := decodeCBOR(encodeCBOR(v))
+				if err != nil || got != v {
+					t.Errorf("decodeCBOR(encodeCBOR(%d)) = %v, %v", v, got, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package c2pa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	// Register the digests a claim may be signed with
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// COSE algorithm identifiers (RFC 9053) allowed for C2PA claim signatures
+var coseAlgorithms = map[int64]struct {
+	hash crypto.Hash
+	kind string
+}{
+	-7:  {crypto.SHA256, "ecdsa"},
+	-35: {crypto.SHA384, "ecdsa"},
+	-36: {crypto.SHA512, "ecdsa"},
+	-37: {crypto.SHA256, "pss"},
+	-38: {crypto.SHA384, "pss"},
+	-39: {crypto.SHA512, "pss"},
+	-8:  {0, "eddsa"},
+}
+
+// COSE header labels
+const (
+	headerAlg     = "1"
+	headerX5Chain = "33"
+)
+
+// signature is a verified claim signature
+type signature struct {
+	Chain []*x509.Certificate
+}
+
+// verifySignature checks a COSE_Sign1 claim signature (RFC 9052). The
+// claim is the detached payload; the signing certificate, followed by any
+// intermediates, is in the x5chain header.
+func verifySignature(sign1, claim []byte) (*signature, error) {
+	decoded, err := decodeCBOR(sign1)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+	parts, ok := decoded.([]interface{})
+	if !ok || len(parts) != 4 {
+		return nil, errors.New("malformed signature: not COSE_Sign1")
+	}
+	protected, ok1 := parts[0].([]byte)
+	unprotected, ok2 := parts[1].(map[string]interface{})
+	sig, ok3 := parts[3].([]byte)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("malformed signature: not COSE_Sign1")
+	}
+
+	headers := map[string]interface{}{}
+	if len(protected) > 0 {
+		value, err := decodeCBOR(protected)
+		if err != nil {
+			return nil, fmt.Errorf("malformed signature header: %v", err)
+		}
+		if headers, ok = value.(map[string]interface{}); !ok {
+			return nil, errors.New("malformed signature header")
+		}
+	}
+
+	// The algorithm must be protected; the chain may be in either bucket
+	algID, ok := headers[headerAlg].(int64)
+	alg, known := coseAlgorithms[algID]
+	if !ok || !known {
+		return nil, fmt.Errorf("unsupported signature algorithm %v", headers[headerAlg])
+	}
+	chainValue := headers[headerX5Chain]
+	if chainValue == nil {
+		chainValue = unprotected[headerX5Chain]
+	}
+	if chainValue == nil {
+		chainValue = unprotected["x5chain"]
+	}
+	chain, err := parseChain(chainValue)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sig_structure: ["Signature1", protected, external_aad, payload]
+	var message []byte
+	message = append(message, 0x84)
+	message = append(message, cborText("Signature1")...)
+	message = append(message, cborBytes(protected)...)
+	message = append(message, cborBytes(nil)...)
+	message = append(message, cborBytes(claim)...)
+
+	if err = checkSignature(chain[0], alg.kind, alg.hash, message, sig); err != nil {
+		return nil, err
+	}
+	return &signature{Chain: chain}, nil
+}
+
+// parseChain reads an x5chain header: one certificate, or an array of them
+// starting with the signer's
+func parseChain(value interface{}) ([]*x509.Certificate, error) {
+	var raw [][]byte
+	switch v := value.(type) {
+	case []byte:
+		raw = [][]byte{v}
+	case []interface{}:
+		for _, item := range v {
+			der, ok := item.([]byte)
+			if !ok {
+				return nil, errors.New("malformed certificate chain")
+			}
+			raw = append(raw, der)
+		}
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("signature does not include the signing certificate")
+	}
+
+	chain := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate: %v", err)
+		}
+		chain[i] = cert
+	}
+	return chain, nil
+}
+
+func checkSignature(cert *x509.Certificate, kind string, hash crypto.Hash, message, sig []byte) error {
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(message)
+		digest = h.Sum(nil)
+	}
+
+	ok := false
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		// COSE carries r and s as fixed-size big-endian integers
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if kind == "ecdsa" && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			ok = ecdsa.Verify(key, digest, r, s)
+		}
+	case *rsa.PublicKey:
+		if kind == "pss" {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			ok = rsa.VerifyPSS(key, hash, digest, sig, opts) == nil
+		}
+	case ed25519.PublicKey:
+		ok = kind == "eddsa" && ed25519.Verify(key, message, sig)
+	default:
+		return fmt.Errorf("unsupported signing key type %T", cert.PublicKey)
+	}
+	if !ok {
+		return errors.New("claim signature is invalid")
+	}
+	return nil
+}
+
+// checkTrust verifies that the signing certificate chains to one of roots
+// and is valid now
+func (s *signature) checkTrust(roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range s.Chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := s.Chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// signerName describes a certificate's subject by organisation, falling
+// back to its common name
+func signerName(name string, organization []string) string {
+	if len(organization) > 0 && organization[0] != "" {
+		if name != "" && !strings.EqualFold(name, organization[0]) {
+			return organization[0] + " (" + name + ")"
+		}
+		return organization[0]
+	}
+	return name
+}
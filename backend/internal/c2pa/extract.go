@@ -0,0 +1,232 @@
+package c2pa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// maxStoreSize bounds the manifest store read into memory
+const maxStoreSize = 32 << 20
+
+// bmffUUID identifies the top-level 'uuid' box holding a C2PA manifest
+// store in ISO base media files
+var bmffUUID = []byte{0xd8, 0xfe, 0xc3, 0xd6, 0x1b, 0x0e, 0x48, 0x3c, 0x92, 0x97, 0x58, 0x28, 0x87, 0x7e, 0xc4, 0x81}
+
+var errTooLarge = errors.New("manifest store is too large")
+
+// Container formats a manifest can be read from
+const (
+	formatJPEG = "jpeg"
+	formatBMFF = "bmff"
+)
+
+// sniff identifies the container format from the first bytes of r
+func sniff(r io.ReadSeeker) (string, error) {
+	head := make([]byte, 12)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8}):
+		return formatJPEG, nil
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return formatBMFF, nil
+	}
+	return "", nil
+}
+
+// jpegStore reassembles the JUMBF manifest store carried in a JPEG's APP11
+// segments. Each segment holds the common identifier "JP", a box instance
+// number and a sequence number; segments after the first repeat the box
+// header, which is dropped when they are joined.
+func jpegStore(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(2, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+
+	type packet struct {
+		seq  uint32
+		data []byte
+	}
+	instances := make(map[uint16][]packet)
+	var order []uint16
+	total := 0
+
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			break // use what was found before the damage
+		}
+		// Segments without a length
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			continue
+		}
+		if marker == 0xD9 || marker == 0xDA {
+			break // end of image or start of scan: no more metadata
+		}
+
+		var length [2]byte
+		if _, err = io.ReadFull(br, length[:]); err != nil {
+			break
+		}
+		size := int(binary.BigEndian.Uint16(length[:])) - 2
+		if size < 0 {
+			break
+		}
+		if marker != 0xEB {
+			if _, err = br.Discard(size); err != nil {
+				break
+			}
+			continue
+		}
+
+		segment := make([]byte, size)
+		if _, err = io.ReadFull(br, segment); err != nil {
+			break
+		}
+		if size < 16 || string(segment[:2]) != "JP" {
+			continue
+		}
+		if total += size; total > maxStoreSize {
+			return nil, errTooLarge
+		}
+		instance := binary.BigEndian.Uint16(segment[2:])
+		if _, seen := instances[instance]; !seen {
+			order = append(order, instance)
+		}
+		instances[instance] = append(instances[instance], packet{
+			seq:  binary.BigEndian.Uint32(segment[4:]),
+			data: segment[8:],
+		})
+	}
+
+	for _, instance := range order {
+		packets := instances[instance]
+		sort.SliceStable(packets, func(i, j int) bool { return packets[i].seq < packets[j].seq })
+
+		store := append([]byte(nil), packets[0].data...)
+		for _, p := range packets[1:] {
+			if len(p.data) < 8 {
+				continue
+			}
+			store = append(store, p.data[8:]...)
+		}
+		if isStore(store) {
+			return store, nil
+		}
+	}
+	return nil, nil
+}
+
+// readMarker skips to the next JPEG marker and returns its code
+func readMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errors.New("expected JPEG marker")
+	}
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// isStore reports whether data starts with a JUMBF superbox labelled as a
+// C2PA manifest store
+func isStore(data []byte) bool {
+	boxes, err := parseBoxes(data, 0)
+	return err == nil && len(boxes) > 0 && boxes[0].Type == "jumb" && boxes[0].Label == "c2pa"
+}
+
+// bmffBox locates a top-level box of an ISO base media file
+type bmffBox struct {
+	Type   string
+	Offset int64
+	Size   int64
+	Header int64
+}
+
+// bmffBoxes lists the top-level boxes of r
+func bmffBoxes(r io.ReadSeeker) ([]bmffBox, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var boxes []bmffBox
+	header := make([]byte, 16)
+	for offset := int64(0); offset < end; {
+		if _, err = r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if end-offset < 8 {
+			return nil, errors.New("truncated box header")
+		}
+		if _, err = io.ReadFull(r, header[:8]); err != nil {
+			return nil, err
+		}
+		b := bmffBox{Type: string(header[4:8]), Offset: offset, Size: int64(binary.BigEndian.Uint32(header)), Header: 8}
+		switch b.Size {
+		case 0:
+			b.Size = end - offset
+		case 1:
+			if _, err = io.ReadFull(r, header[8:16]); err != nil {
+				return nil, err
+			}
+			b.Size, b.Header = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if b.Size < b.Header || b.Size > end-offset {
+			return nil, errors.New("malformed box size")
+		}
+		boxes = append(boxes, b)
+		offset += b.Size
+	}
+	return boxes, nil
+}
+
+// bmffStore reads the manifest store from the C2PA 'uuid' box: the UUID,
+// a version and flags, the purpose "manifest", the offset of an optional
+// auxiliary box, then the store itself
+func bmffStore(r io.ReadSeeker, boxes []bmffBox) ([]byte, error) {
+	for _, b := range boxes {
+		if b.Type != "uuid" || b.Size-b.Header < 20 {
+			continue
+		}
+		if _, err := r.Seek(b.Offset+b.Header, io.SeekStart); err != nil {
+			return nil, err
+		}
+		usertype := make([]byte, 16)
+		if _, err := io.ReadFull(r, usertype); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(usertype, bmffUUID) {
+			continue
+		}
+
+		if b.Size-b.Header > maxStoreSize {
+			return nil, errTooLarge
+		}
+		body := make([]byte, b.Size-b.Header-16)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		rest := body[4:]
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 || string(rest[:end]) != "manifest" || len(rest) < end+9 {
+			continue
+		}
+		return rest[end+9:], nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,124 @@
+package c2pa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// maxBoxDepth bounds how deeply JUMBF superboxes may nest
+const maxBoxDepth = 16
+
+var errJUMBF = errors.New("malformed JUMBF")
+
+// box is a JUMBF box (ISO/IEC 19566-5). Superboxes ("jumb") are labelled
+// by their description box and hold child boxes; other boxes hold data.
+type box struct {
+	Type string
+	// Payload is everything after the box header. Assertions are hashed
+	// over the payload of their superbox.
+	Payload []byte
+
+	// Superboxes only
+	Label    string
+	Children []*box
+}
+
+// parseBoxes splits data into consecutive boxes, parsing superboxes
+// recursively
+func parseBoxes(data []byte, depth int) ([]*box, error) {
+	if depth > maxBoxDepth {
+		return nil, errJUMBF
+	}
+	var boxes []*box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errJUMBF
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errJUMBF
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, errJUMBF
+		}
+
+		b := &box{Type: string(data[4:8]), Payload: data[header:size]}
+		if b.Type == "jumb" {
+			if err := b.parseSuperbox(depth); err != nil {
+				return nil, err
+			}
+		}
+		boxes = append(boxes, b)
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// parseSuperbox reads the label from the leading description box and
+// parses the children that follow it
+func (b *box) parseSuperbox(depth int) error {
+	children, err := parseBoxes(b.Payload, depth+1)
+	if err != nil {
+		return err
+	}
+	if len(children) == 0 || children[0].Type != "jumd" {
+		return errJUMBF
+	}
+
+	// Description: a 16-byte content type UUID, toggles, then an optional
+	// null-terminated label
+	desc := children[0].Payload
+	if len(desc) < 17 {
+		return errJUMBF
+	}
+	if desc[16]&0x02 != 0 {
+		label := desc[17:]
+		end := bytes.IndexByte(label, 0)
+		if end < 0 {
+			return errJUMBF
+		}
+		b.Label = string(label[:end])
+	}
+	b.Children = children[1:]
+	return nil
+}
+
+// child returns the first superbox child labelled label
+func (b *box) child(label string) *box {
+	for _, c := range b.Children {
+		if c.Type == "jumb" && c.Label == label {
+			return c
+		}
+	}
+	return nil
+}
+
+// childWithPrefix returns the first superbox child whose label is prefix
+// or a version of it, such as "c2pa.claim.v2" for "c2pa.claim"
+func (b *box) childWithPrefix(prefix string) *box {
+	for _, c := range b.Children {
+		if c.Type == "jumb" && (c.Label == prefix || strings.HasPrefix(c.Label, prefix+".v")) {
+			return c
+		}
+	}
+	return nil
+}
+
+// content returns the first data box of a superbox of type boxType
+func (b *box) content(boxType string) []byte {
+	for _, c := range b.Children {
+		if c.Type == boxType {
+			return c.Payload
+		}
+	}
+	return nil
+}
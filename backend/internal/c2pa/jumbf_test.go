@@ -0,0 +1,123 @@
+package c2pa
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// jumbfBox encodes a box with a 32-bit size
+func jumbfBox(boxType string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	out := binary.BigEndian.AppendUint32(nil, uint32(size))
+	out = append(out, boxType...)
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// superbox encodes a labelled "jumb" box around children
+func superbox(label string, children ...[]byte) []byte {
+	desc := make([]byte, 16, 17+len(label)+1) // content type UUID, unchecked
+	desc = append(desc, 0x03)
+	desc = append(desc, label...)
+	desc = append(desc, 0)
+	return jumbfBox("jumb", append([][]byte{jumbfBox("jumd", desc)}, children...)...)
+}
+
+func TestParseBoxes(t *testing.T) {
+	store := superbox("c2pa",
+		superbox("urn:uuid:1",
+			superbox("c2pa.claim.v2", jumbfBox("cbor", []byte{0xA0})),
+			superbox("c2pa.signature", jumbfBox("cbor", []byte{0x80})),
+		),
+	)
+	boxes, err := parseBoxes(store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boxes) != 1 || boxes[0].Label != "c2pa" || len(boxes[0].Children) != 1 {
+		t.Fatalf("parseBoxes = %+v", boxes)
+	}
+
+	m := boxes[0].Children[0]
+	if m.Label != "urn:uuid:1" {
+		t.Errorf("manifest label = %q", m.Label)
+	}
+	if claim := m.childWithPrefix("c2pa.claim"); claim == nil || string(claim.content("cbor")) != "\xA0" {
+		t.Errorf("claim = %+v", claim)
+	}
+	if m.child("c2pa.claim") != nil {
+		t.Error("child matched a versioned label")
+	}
+	if sig := m.child("c2pa.signature"); sig == nil || string(sig.content("cbor")) != "\x80" {
+		t.Errorf("signature = %+v", sig)
+	}
+}
+
+func TestParseBoxesSizes(t *testing.T) {
+	large := append(binary.BigEndian.AppendUint32(nil, 1), "free"...)
+	large = binary.BigEndian.AppendUint64(large, 20)
+	large = append(large, "abcd"...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		payload string
+		wantErr bool
+	}{
+		{"32-bit size", jumbfBox("free", []byte("abcd")), "abcd", false},
+		{"64-bit size", large, "abcd", false},
+		{"size zero runs to the end", append([]byte{0, 0, 0, 0}, "freeabcd"...), "abcd", false},
+		{"short header", []byte{0, 0, 0, 8, 'f'}, "", true},
+		{"size past the end", append([]byte{0, 0, 0, 16}, "freeabcd"...), "", true},
+		{"size inside the header", append([]byte{0, 0, 0, 4}, "free"...), "", true},
+		{"superbox without description", jumbfBox("jumb", jumbfBox("cbor")), "", true},
+		{"description too short", jumbfBox("jumb", jumbfBox("jumd", make([]byte, 16))), "", true},
+		{"unterminated label", jumbfBox("jumb", jumbfBox("jumd", append(make([]byte, 16), 0x03, 'c'))), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boxes, err := parseBoxes(tt.data, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (len(boxes) != 1 || string(boxes[0].Payload) != tt.payload) {
+				t.Errorf("parseBoxes = %+v, want one box holding %q", boxes, tt.payload)
+			}
+		})
+	}
+}
+
+func TestParseBoxesDepth(t *testing.T) {
+	data := jumbfBox("cbor")
+	for i := 0; i <= maxBoxDepth; i++ {
+		data = superbox("nested", data)
+	}
+	if _, err := parseBoxes(data, 0); err == nil {
+		t.Error("parseBoxes accepted superboxes nested too deeply")
+	}
+}
+
+func TestBaseLabel(t *testing.T) {
+	tests := []struct {
+		label, want string
+	}{
+		{"c2pa.actions", "c2pa.actions"},
+		{"c2pa.actions.v2", "c2pa.actions"},
+		{"c2pa.ingredient__1", "c2pa.ingredient"},
+		{"c2pa.ingredient.v3__12", "c2pa.ingredient"},
+		{"c2pa.hash.bmff.v2", "c2pa.hash.bmff"},
+		{"stds.exif", "stds.exif"},
+		{"com.example.vendor", "com.example.vendor"},
+	}
+	for _, tt := range tests {
+		if got := baseLabel(tt.label); got != tt.want {
+			t.Errorf("baseLabel(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+}
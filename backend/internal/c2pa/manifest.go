@@ -0,0 +1,216 @@
+package c2pa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// manifest is one manifest of a store: a signed claim listing the hashes
+// of the assertions it makes
+type manifest struct {
+	Label     string
+	Generator string
+	Title     string
+	Alg       string
+
+	// The claim exactly as signed, and its COSE_Sign1 signature
+	Claim     []byte
+	Signature []byte
+
+	// Assertions referenced by the claim whose hash matched, by label
+	Assertions []assertion
+	Problems   []string
+}
+
+type assertion struct {
+	Label string
+	// Label less any version and instance suffix, as in c2pa.actions for
+	// c2pa.actions.v2__1
+	Base  string
+	Value map[string]interface{}
+}
+
+// parseStore reads the manifests of a C2PA manifest store, oldest first.
+// The last one is the active manifest, describing the file as it is.
+func parseStore(data []byte) ([]*manifest, error) {
+	boxes, err := parseBoxes(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) == 0 || boxes[0].Label != "c2pa" {
+		return nil, errors.New("not a C2PA manifest store")
+	}
+	store := boxes[0]
+
+	var manifests []*manifest
+	for _, b := range store.Children {
+		if b.Type != "jumb" {
+			continue
+		}
+		manifests = append(manifests, parseManifest(store, b))
+	}
+	if len(manifests) == 0 {
+		return nil, errors.New("manifest store is empty")
+	}
+	return manifests, nil
+}
+
+// parseManifest reads a manifest's claim and checks the assertions it
+// references. Problems are recorded on the manifest rather than returned,
+// so one bad manifest does not hide the others.
+func parseManifest(store, b *box) *manifest {
+	m := &manifest{Label: b.Label, Alg: "sha256"}
+
+	claimBox := b.childWithPrefix("c2pa.claim")
+	signatureBox := b.child("c2pa.signature")
+	if claimBox == nil || signatureBox == nil {
+		m.Problems = append(m.Problems, "manifest is missing its claim or signature")
+		return m
+	}
+	m.Claim = claimBox.content("cbor")
+	m.Signature = signatureBox.content("cbor")
+
+	decoded, err := decodeCBOR(m.Claim)
+	claim, ok := decoded.(map[string]interface{})
+	if err != nil || !ok {
+		m.Problems = append(m.Problems, "claim is malformed")
+		return m
+	}
+	m.Alg = stringField(claim, "alg", m.Alg)
+	m.Title = stringField(claim, "dc:title", "")
+	m.Generator = generatorName(claim)
+
+	var refs []interface{}
+	for _, key := range []string{"assertions", "created_assertions", "gathered_assertions"} {
+		list, _ := claim[key].([]interface{})
+		refs = append(refs, list...)
+	}
+	for _, item := range refs {
+		ref, _ := item.(map[string]interface{})
+		url := stringField(ref, "url", "")
+		target := resolve(store, b, url)
+		if target == nil {
+			m.Problems = append(m.Problems, fmt.Sprintf("assertion %s is missing", url))
+			continue
+		}
+
+		expected, _ := ref["hash"].([]byte)
+		h, err := newHash(stringField(ref, "alg", m.Alg))
+		if err != nil {
+			m.Problems = append(m.Problems, err.Error())
+			continue
+		}
+		h.Write(target.Payload)
+		if !bytes.Equal(h.Sum(nil), expected) {
+			m.Problems = append(m.Problems, fmt.Sprintf("assertion %s does not match its hash in the claim", target.Label))
+			continue
+		}
+
+		value, err := assertionValue(target)
+		if err != nil {
+			m.Problems = append(m.Problems, fmt.Sprintf("assertion %s is malformed", target.Label))
+			continue
+		}
+		m.Assertions = append(m.Assertions, assertion{Label: target.Label, Base: baseLabel(target.Label), Value: value})
+	}
+	return m
+}
+
+// resolve finds the box a JUMBF URI names: "self#jumbf=" followed by a path
+// of labels, absolute from the store or relative to the manifest
+func resolve(store, manifest *box, uri string) *box {
+	path, ok := strings.CutPrefix(uri, "self#jumbf=")
+	if !ok {
+		return nil
+	}
+
+	current := manifest
+	if strings.HasPrefix(path, "/") {
+		labels := strings.Split(strings.TrimPrefix(path, "/"), "/")
+		if labels[0] != store.Label {
+			return nil
+		}
+		current, path = store, strings.Join(labels[1:], "/")
+	}
+	for _, label := range strings.Split(path, "/") {
+		if current = current.child(label); current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+// assertionValue decodes an assertion's CBOR or JSON content. Assertions
+// holding anything else, such as thumbnails, decode to an empty map.
+func assertionValue(b *box) (map[string]interface{}, error) {
+	if data := b.content("cbor"); data != nil {
+		decoded, err := decodeCBOR(data)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := decoded.(map[string]interface{})
+		if !ok {
+			return nil, errCBOR
+		}
+		return value, nil
+	}
+	if data := b.content("json"); data != nil {
+		var value map[string]interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+// baseLabel strips an assertion label's instance ("__2") and version
+// (".v2") suffixes
+func baseLabel(label string) string {
+	if i := strings.LastIndex(label, "__"); i > 0 {
+		label = label[:i]
+	}
+	if i := strings.LastIndex(label, ".v"); i > 0 && strings.Trim(label[i+2:], "0123456789") == "" {
+		label = label[:i]
+	}
+	return label
+}
+
+// generatorName describes the software that made a claim
+func generatorName(claim map[string]interface{}) string {
+	if name := stringField(claim, "claim_generator", ""); name != "" {
+		return name
+	}
+	info, _ := claim["claim_generator_info"].(map[string]interface{})
+	if list, ok := claim["claim_generator_info"].([]interface{}); ok && len(list) > 0 {
+		info, _ = list[0].(map[string]interface{})
+	}
+	name := stringField(info, "name", "")
+	if version := stringField(info, "version", ""); name != "" && version != "" {
+		name += " " + version
+	}
+	return name
+}
+
+func stringField(m map[string]interface{}, key, fallback string) string {
+	if s, ok := m[key].(string); ok && s != "" {
+		return s
+	}
+	return fallback
+}
+
+// intField reads an integer from CBOR or JSON
+func intField(m map[string]interface{}, key string) (int64, bool) {
+	switch v := m[key].(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), v <= 1<<62
+	case float64:
+		return int64(v), v == float64(int64(v))
+	}
+	return 0, false
+}
@@ -2,6 +2,13 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/protest-tracker/internal/notify"
+	"github.com/protest-tracker/internal/services"
+	"github.com/protest-tracker/internal/storage"
 )
 
 type Config struct {
@@ -9,6 +16,93 @@ type Config struct {
 	DatabaseURL string
 	JWTSecret   string
 	MediaDir    string
+
+	// Media encryption. MediaMasterKey (or the contents of MediaMasterKeyFile)
+	// is a base64-encoded 32-byte key; MediaPreviousKeys lists retired keys
+	// that are still needed to read files until they are re-wrapped.
+	MediaMasterKey     string
+	MediaMasterKeyFile string
+	MediaPreviousKeys  string
+
+	// Media storage backend: "local" (MediaDir) or "s3"
+	StorageBackend string
+	S3Endpoint     string
+	S3Region       string
+	S3Bucket       string
+	S3AccessKey    string
+	S3SecretKey    string
+
+	// Resumable uploads are staged in UploadDir until complete; sessions
+	// idle for longer than UploadSessionTTL are purged
+	UploadDir        string
+	UploadSessionTTL time.Duration
+
+	// Deleted media can be restored for MediaDeleteGrace before the files
+	// are purged
+	MediaDeleteGrace time.Duration
+
+	// Evidence exports are signed with an Ed25519 key; ExportSigningKey (or
+	// the contents of ExportSigningKeyFile) is its base64-encoded 32-byte seed
+	ExportSigningKey     string
+	ExportSigningKeyFile string
+
+	// Share link tokens are signed with ShareLinkSecret, falling back to
+	// JWTSecret when it is not set
+	ShareLinkSecret string
+
+	// Malware scanning: "none" or "clamd", the clamd socket ("localhost:3310"
+	// or a Unix socket path) and how long a single scan may take
+	MalwareScanner     string
+	ClamdAddress       string
+	MalwareScanTimeout time.Duration
+
+	// Upload provenance: whether to keep the uploader's IP address and a
+	// hashed device identifier with each media item. Both are off by default
+	// because they can identify the person who filmed.
+	RecordUploadIP          bool
+	RecordDeviceFingerprint bool
+
+	// Upload limits in bytes: the largest file of each media type, the
+	// total a user may upload and an event may hold (0 for no quota), and
+	// the free space kept on the media volume
+	MaxPhotoSize    int64
+	MaxVideoSize    int64
+	MaxAudioSize    int64
+	MaxDocumentSize int64
+	UserQuota       int64
+	EventQuota      int64
+	MinFreeSpace    int64
+
+	// RFC 3161 time-stamping authority: its URL (empty disables
+	// timestamping), a PEM file of the certificates its tokens must chain
+	// to, and how long a request may take
+	TSAURL     string
+	TSACAFile  string
+	TSATimeout time.Duration
+
+	// PEM file of the certificates C2PA content credential signers must
+	// chain to for their manifests to be reported valid
+	C2PATrustAnchors string
+
+	// Notification channels for contacting witnesses. Each is enabled by
+	// setting its host or URL.
+	SMTPHost        string
+	SMTPPort        int
+	SMTPUsername    string
+	SMTPPassword    string
+	SMTPFrom        string
+	SMTPSecurity    string
+	SMSGatewayURL   string
+	SMSGatewayToken string
+	SMSFrom         string
+	WebhookURL      string
+	WebhookSecret   string
+	NotifyTimeout   time.Duration
+	NotifyWorkers   int
+
+	// Number of workers running background jobs such as malware scans and
+	// thumbnail generation
+	JobWorkers int
 }
 
 func Load() *Config {
@@ -17,6 +111,114 @@ func Load() *Config {
 		DatabaseURL: getEnv("DATABASE_URL", "host=localhost port=5432 user=postgres password=postgres dbname=protest_tracker sslmode=disable"),
 		JWTSecret:   getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
 		MediaDir:    getEnv("MEDIA_DIR", "./media"),
+
+		MediaMasterKey:     getEnv("MEDIA_MASTER_KEY", ""),
+		MediaMasterKeyFile: getEnv("MEDIA_MASTER_KEY_FILE", ""),
+		MediaPreviousKeys:  getEnv("MEDIA_PREVIOUS_KEYS", ""),
+
+		StorageBackend: getEnv("STORAGE_BACKEND", "local"),
+		S3Endpoint:     getEnv("S3_ENDPOINT", ""),
+		S3Region:       getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:       getEnv("S3_BUCKET", ""),
+		S3AccessKey:    getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:    getEnv("S3_SECRET_KEY", ""),
+
+		UploadDir:        getEnv("UPLOAD_DIR", "./uploads"),
+		UploadSessionTTL: getEnvDuration("UPLOAD_SESSION_TTL", 24*time.Hour),
+
+		MediaDeleteGrace: getEnvDuration("MEDIA_DELETE_GRACE_PERIOD", 30*24*time.Hour),
+
+		ExportSigningKey:     getEnv("EXPORT_SIGNING_KEY", ""),
+		ExportSigningKeyFile: getEnv("EXPORT_SIGNING_KEY_FILE", ""),
+
+		ShareLinkSecret: getEnv("SHARE_LINK_SECRET", ""),
+
+		MalwareScanner:     getEnv("MALWARE_SCANNER", "none"),
+		ClamdAddress:       getEnv("CLAMD_ADDRESS", "localhost:3310"),
+		MalwareScanTimeout: getEnvDuration("MALWARE_SCAN_TIMEOUT", 5*time.Minute),
+
+		RecordUploadIP:          getEnvBool("RECORD_UPLOAD_IP", false),
+		RecordDeviceFingerprint: getEnvBool("RECORD_DEVICE_FINGERPRINT", false),
+
+		MaxPhotoSize:    getEnvSize("MAX_PHOTO_SIZE", 50<<20),
+		MaxVideoSize:    getEnvSize("MAX_VIDEO_SIZE", 4<<30),
+		MaxAudioSize:    getEnvSize("MAX_AUDIO_SIZE", 1<<30),
+		MaxDocumentSize: getEnvSize("MAX_DOCUMENT_SIZE", 100<<20),
+		UserQuota:       getEnvSize("USER_STORAGE_QUOTA", 20<<30),
+		EventQuota:      getEnvSize("EVENT_STORAGE_QUOTA", 0),
+		MinFreeSpace:    getEnvSize("MIN_FREE_DISK_SPACE", 1<<30),
+
+		TSAURL:     getEnv("TSA_URL", ""),
+		TSACAFile:  getEnv("TSA_CA_FILE", ""),
+		TSATimeout: getEnvDuration("TSA_TIMEOUT", 30*time.Second),
+
+		C2PATrustAnchors: getEnv("C2PA_TRUST_ANCHORS", ""),
+
+		SMTPHost:        getEnv("SMTP_HOST", ""),
+		SMTPPort:        getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:    getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:    getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:        getEnv("SMTP_FROM", ""),
+		SMTPSecurity:    getEnv("SMTP_SECURITY", "starttls"),
+		SMSGatewayURL:   getEnv("SMS_GATEWAY_URL", ""),
+		SMSGatewayToken: getEnv("SMS_GATEWAY_TOKEN", ""),
+		SMSFrom:         getEnv("SMS_FROM", ""),
+		WebhookURL:      getEnv("NOTIFY_WEBHOOK_URL", ""),
+		WebhookSecret:   getEnv("NOTIFY_WEBHOOK_SECRET", ""),
+		NotifyTimeout:   getEnvDuration("NOTIFY_TIMEOUT", 10*time.Second),
+		NotifyWorkers:   getEnvInt("NOTIFY_WORKERS", 2),
+
+		JobWorkers: getEnvInt("JOB_WORKERS", 4),
+	}
+}
+
+// UploadLimits returns the size limits and quotas for new media
+func (c *Config) UploadLimits() services.UploadLimits {
+	return services.UploadLimits{
+		MaxSizes: map[string]int64{
+			"photo":    c.MaxPhotoSize,
+			"video":    c.MaxVideoSize,
+			"audio":    c.MaxAudioSize,
+			"document": c.MaxDocumentSize,
+		},
+		UserQuota:    c.UserQuota,
+		EventQuota:   c.EventQuota,
+		MinFreeSpace: c.MinFreeSpace,
+	}
+}
+
+// S3Config returns the settings for the S3-compatible storage backend
+func (c *Config) S3Config() storage.S3Config {
+	return storage.S3Config{
+		Endpoint:  c.S3Endpoint,
+		Region:    c.S3Region,
+		Bucket:    c.S3Bucket,
+		AccessKey: c.S3AccessKey,
+		SecretKey: c.S3SecretKey,
+	}
+}
+
+// NotifyConfig returns the settings for the notification channels
+func (c *Config) NotifyConfig() notify.Config {
+	return notify.Config{
+		SMTP: notify.SMTPConfig{
+			Host:     c.SMTPHost,
+			Port:     c.SMTPPort,
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+			From:     c.SMTPFrom,
+			Security: c.SMTPSecurity,
+		},
+		SMS: notify.SMSConfig{
+			URL:   c.SMSGatewayURL,
+			Token: c.SMSGatewayToken,
+			From:  c.SMSFrom,
+		},
+		Webhook: notify.WebhookConfig{
+			URL:    c.WebhookURL,
+			Secret: c.WebhookSecret,
+		},
+		Timeout: c.NotifyTimeout,
 	}
 }
 
@@ -25,4 +227,50 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvSize reads a byte count, optionally with a K, M, G or T suffix
+// (powers of 1024), such as "500M"
+func getEnvSize(key string, defaultValue int64) int64 {
+	value := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(os.Getenv(key))), "B")
+	if value == "" {
+		return defaultValue
+	}
+	multiplier := int64(1)
+	if i := strings.IndexAny(value, "KMGT"); i == len(value)-1 {
+		multiplier = 1 << (10 * (strings.IndexByte("KMGT", value[i]) + 1))
+		value = value[:i]
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		return defaultValue
+	}
+	return parsed * multiplier
+}
@@ -53,6 +53,213 @@ func createTables(db *sql.DB) error {
 			type VARCHAR(50) NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS sha256 VARCHAR(64);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS key_id VARCHAR(16);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS wrapped_key TEXT;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS mime_type VARCHAR(100);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS size_bytes BIGINT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS extension VARCHAR(16);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES media(id) ON DELETE SET NULL;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS redaction TEXT;`,
+		`ALTER TABLE arrest_events ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS delete_reason TEXT;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS purge_after TIMESTAMP;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS phash BIGINT;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS scan_status VARCHAR(20) NOT NULL DEFAULT 'pending';`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS scan_result TEXT;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS uploaded_by INTEGER REFERENCES users(id);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS captured_at TIMESTAMP;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS capture_source VARCHAR(16);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS width INTEGER;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS height INTEGER;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS duration_seconds DOUBLE PRECISION;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS upload_ip VARCHAR(64);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS device_fingerprint VARCHAR(64);`,
+		`CREATE INDEX IF NOT EXISTS idx_media_event_captured ON media(event_id, captured_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_media_uploaded_by ON media(uploaded_by);`,
+		`CREATE TABLE IF NOT EXISTS custody_log (
+			id SERIAL PRIMARY KEY,
+			media_id INTEGER NOT NULL,
+			event_id INTEGER NOT NULL,
+			user_id INTEGER,
+			action VARCHAR(50) NOT NULL,
+			details TEXT,
+			prev_hash VARCHAR(64) NOT NULL,
+			entry_hash VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_custody_log_media ON custody_log(media_id);`,
+		`CREATE OR REPLACE FUNCTION custody_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'custody_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql;`,
+		`DROP TRIGGER IF EXISTS custody_log_no_modify ON custody_log;`,
+		`CREATE TRIGGER custody_log_no_modify BEFORE UPDATE OR DELETE ON custody_log
+			FOR EACH ROW EXECUTE FUNCTION custody_log_append_only();`,
+		`CREATE TABLE IF NOT EXISTS media_metadata (
+			media_id INTEGER PRIMARY KEY REFERENCES media(id) ON DELETE CASCADE,
+			captured_at TIMESTAMP,
+			latitude FLOAT,
+			longitude FLOAT
+		);`,
+		`CREATE TABLE IF NOT EXISTS blobs (
+			sha256 VARCHAR(64) PRIMARY KEY,
+			file_path VARCHAR(500) NOT NULL,
+			size_bytes BIGINT NOT NULL,
+			key_id VARCHAR(16),
+			wrapped_key TEXT,
+			ref_count INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_media_event_sha256 ON media(event_id, sha256);`,
+		// One live upload of a file per event, so concurrent uploads of the
+		// same file cannot both be stored. Databases holding duplicates from
+		// before uploads were deduplicated start without it.
+		`DO $$
+		BEGIN
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_media_event_sha256_live ON media(event_id, sha256)
+				WHERE deleted_at IS NULL AND parent_id IS NULL;
+		EXCEPTION WHEN unique_violation THEN
+			RAISE WARNING 'duplicate live media; idx_media_event_sha256_live not created';
+		END
+		$$;`,
+		`CREATE TABLE IF NOT EXISTS media_thumbnails (
+			media_id INTEGER NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+			size VARCHAR(16) NOT NULL,
+			file_path VARCHAR(500) NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			size_bytes BIGINT NOT NULL,
+			key_id VARCHAR(16),
+			wrapped_key TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (media_id, size)
+		);`,
+		`CREATE TABLE IF NOT EXISTS upload_sessions (
+			id VARCHAR(64) PRIMARY KEY,
+			event_id INTEGER REFERENCES arrest_events(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id),
+			type VARCHAR(50) NOT NULL,
+			length BIGINT NOT NULL,
+			upload_offset BIGINT NOT NULL DEFAULT 0,
+			sha256 VARCHAR(64),
+			key_id VARCHAR(16),
+			wrapped_key TEXT,
+			media_id INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		);`,
+		`ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS duplicate BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS captured_at TIMESTAMP;`,
+		`ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS upload_ip VARCHAR(64);`,
+		`ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS device_fingerprint VARCHAR(64);`,
+		// Client filenames can identify the person who filmed; they are
+		// not kept
+		`ALTER TABLE upload_sessions DROP COLUMN IF EXISTS filename;`,
+		// Fill in provenance for media uploaded before it was recorded
+		`UPDATE media m SET uploaded_by = c.user_id
+			FROM custody_log c
+			WHERE m.uploaded_by IS NULL AND c.media_id = m.id AND c.action = 'upload' AND c.user_id IS NOT NULL
+				AND c.details NOT LIKE 'duplicate%';`,
+		`UPDATE media m SET captured_at = mm.captured_at, capture_source = 'exif'
+			FROM media_metadata mm
+			WHERE m.captured_at IS NULL AND mm.media_id = m.id AND mm.captured_at IS NOT NULL;`,
+		`CREATE TABLE IF NOT EXISTS share_links (
+			id SERIAL PRIMARY KEY,
+			event_id INTEGER NOT NULL REFERENCES arrest_events(id) ON DELETE CASCADE,
+			media_id INTEGER REFERENCES media(id) ON DELETE CASCADE,
+			created_by INTEGER REFERENCES users(id),
+			note TEXT,
+			password_hash VARCHAR(100),
+			max_views INTEGER,
+			view_count INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_share_links_event ON share_links(event_id);`,
+		`CREATE TABLE IF NOT EXISTS share_link_access (
+			id SERIAL PRIMARY KEY,
+			link_id INTEGER NOT NULL REFERENCES share_links(id) ON DELETE CASCADE,
+			outcome VARCHAR(20) NOT NULL,
+			ip VARCHAR(64),
+			user_agent TEXT,
+			accessed_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_share_link_access_link ON share_link_access(link_id);`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS processing_status VARCHAR(16) NOT NULL DEFAULT 'done';`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id BIGSERIAL PRIMARY KEY,
+			type VARCHAR(64) NOT NULL,
+			media_id INTEGER REFERENCES media(id) ON DELETE CASCADE,
+			payload TEXT,
+			status VARCHAR(16) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL,
+			run_at TIMESTAMP NOT NULL,
+			locked_at TIMESTAMP,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_ready ON jobs(run_at) WHERE status = 'queued';`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_media ON jobs(media_id);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_pending ON jobs(type, media_id) WHERE status IN ('queued', 'running');`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS timestamp_token BYTEA;`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS timestamped_at TIMESTAMP;`,
+		`ALTER TABLE arrest_events ADD COLUMN IF NOT EXISTS timestamp_record TEXT;`,
+		`ALTER TABLE arrest_events ADD COLUMN IF NOT EXISTS timestamp_token BYTEA;`,
+		`ALTER TABLE arrest_events ADD COLUMN IF NOT EXISTS timestamped_at TIMESTAMP;`,
+		`ALTER TABLE jobs ADD COLUMN IF NOT EXISTS event_id INTEGER REFERENCES arrest_events(id) ON DELETE CASCADE;`,
+		// Event timestamp jobs queued before event_id existed: keep the
+		// oldest pending one of each event, then tie it to the event
+		`DELETE FROM jobs a USING jobs b
+			WHERE a.type = 'timestamp_event' AND b.type = 'timestamp_event'
+				AND a.event_id IS NULL AND b.event_id IS NULL
+				AND a.status IN ('queued', 'running') AND b.status IN ('queued', 'running')
+				AND a.payload::json->>'id' = b.payload::json->>'id' AND a.id > b.id;`,
+		`UPDATE jobs SET event_id = (payload::json->>'id')::int
+			WHERE type = 'timestamp_event' AND event_id IS NULL
+				AND EXISTS (SELECT 1 FROM arrest_events e WHERE e.id = (jobs.payload::json->>'id')::int);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_pending_event ON jobs(type, event_id) WHERE status IN ('queued', 'running');`,
+		`ALTER TABLE media ADD COLUMN IF NOT EXISTS credentials_status VARCHAR(16);`,
+		`CREATE TABLE IF NOT EXISTS media_credentials (
+			media_id INTEGER PRIMARY KEY REFERENCES media(id) ON DELETE CASCADE,
+			status VARCHAR(16) NOT NULL,
+			details TEXT NOT NULL,
+			inspected_at TIMESTAMP NOT NULL
+		);`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS notification_channels VARCHAR(100);`,
+		`CREATE TABLE IF NOT EXISTS contact_requests (
+			id SERIAL PRIMARY KEY,
+			event_id INTEGER NOT NULL REFERENCES arrest_events(id) ON DELETE CASCADE,
+			sent_by INTEGER REFERENCES users(id),
+			subject TEXT NOT NULL,
+			message TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_contact_requests_event ON contact_requests(event_id);`,
+		`CREATE TABLE IF NOT EXISTS notification_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			contact_request_id INTEGER NOT NULL REFERENCES contact_requests(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			channel VARCHAR(16),
+			status VARCHAR(16) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL,
+			locked_at TIMESTAMP,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			sent_at TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_outbox_ready ON notification_outbox(next_attempt_at) WHERE status = 'queued';`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_outbox_request ON notification_outbox(contact_request_id);`,
 		`CREATE TABLE IF NOT EXISTS subscriptions (
 			id SERIAL PRIMARY KEY,
 			event_id INTEGER REFERENCES arrest_events(id),
@@ -60,6 +267,14 @@ func createTables(db *sql.DB) error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			UNIQUE(event_id, user_id)
 		);`,
+		`CREATE TABLE IF NOT EXISTS witness_statements (
+			id SERIAL PRIMARY KEY,
+			event_id INTEGER NOT NULL REFERENCES arrest_events(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			statement TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_witness_statements_event ON witness_statements(event_id);`,
 	}
 
 	for _, query := range queries {
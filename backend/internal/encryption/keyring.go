@@ -0,0 +1,158 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the length in bytes of master and data keys (AES-256)
+const KeySize = 32
+
+// Keyring holds the master keys used to seal per-file data keys. New data
+// keys are always wrapped with the current key; previous keys are kept so
+// that existing files stay readable until they are re-wrapped.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring creates a keyring whose current master key is current
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	for _, key := range append([][]byte{current}, previous...) {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+		}
+		k.keys[KeyID(key)] = key
+	}
+	k.current = KeyID(current)
+	return k, nil
+}
+
+// LoadKeyring builds a keyring from base64-encoded keys. The current key is
+// taken from key or, if empty, from the contents of keyFile. previous is a
+// comma-separated list of retired keys. It returns nil when no current key
+// is configured.
+func LoadKeyring(key, keyFile, previous string) (*Keyring, error) {
+	if key == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %v", err)
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	current, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %v", err)
+	}
+
+	var old [][]byte
+	for _, encoded := range strings.Split(previous, ",") {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous master key: %v", err)
+		}
+		old = append(old, decoded)
+	}
+
+	return NewKeyring(current, old...)
+}
+
+// KeyID returns the short identifier stored alongside wrapped data keys
+func KeyID(masterKey []byte) string {
+	sum := sha256.Sum256(masterKey)
+	return hex.EncodeToString(sum[:4])
+}
+
+// CurrentID returns the identifier of the current master key
+func (k *Keyring) CurrentID() string {
+	return k.current
+}
+
+// ContentName derives an opaque, deterministic name for content with the
+// given hash, so stored object names do not reveal which files are held.
+// Names change when the current master key does.
+func (k *Keyring) ContentName(hash []byte) string {
+	subkey := hmac.New(sha256.New, k.keys[k.current])
+	subkey.Write([]byte("content-name"))
+	mac := hmac.New(sha256.New, subkey.Sum(nil))
+	mac.Write(hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewDataKey generates a random data key and wraps it with the current
+// master key
+func (k *Keyring) NewDataKey() (dataKey []byte, keyID, wrapped string, err error) {
+	dataKey = make([]byte, KeySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, "", "", err
+	}
+
+	keyID, wrapped, err = k.Wrap(dataKey)
+	return dataKey, keyID, wrapped, err
+}
+
+// Wrap seals a data key with the current master key
+func (k *Keyring) Wrap(dataKey []byte) (keyID, wrapped string, err error) {
+	aead, err := newGCM(k.keys[k.current])
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, dataKey, []byte(k.current))
+	return k.current, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unwrap opens a data key sealed with the master key identified by keyID
+func (k *Keyring) Unwrap(keyID, wrapped string) ([]byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(keyID))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,113 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestKeyringWrapUnwrap(t *testing.T) {
+	oldKey, newKey := testKey(t), testKey(t)
+
+	old, err := NewKeyring(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, oldID, wrapped, err := old.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataKey) != KeySize || oldID != KeyID(oldKey) {
+		t.Fatalf("NewDataKey returned a %d byte key wrapped with %q", len(dataKey), oldID)
+	}
+
+	rotated, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.CurrentID() != KeyID(newKey) {
+		t.Errorf("CurrentID() = %q, want the new key", rotated.CurrentID())
+	}
+
+	// Data keys wrapped before a rotation stay readable and can be
+	// re-wrapped with the new key
+	unwrapped, err := rotated.Unwrap(oldID, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("Unwrap after rotation = %x, %v", unwrapped, err)
+	}
+	newID, rewrapped, err := rotated.Wrap(unwrapped)
+	if err != nil || newID != KeyID(newKey) {
+		t.Fatalf("Wrap = %q, %v", newID, err)
+	}
+	if again, err := rotated.Unwrap(newID, rewrapped); err != nil || !bytes.Equal(again, dataKey) {
+		t.Errorf("Unwrap of re-wrapped key = %x, %v", again, err)
+	}
+}
+
+func TestKeyringUnwrapFailures(t *testing.T) {
+	key := testKey(t)
+	keyring, err := NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keyID, wrapped, err := keyring.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(wrapped)
+	sealed[len(sealed)-1] ^= 1
+
+	other, _ := NewKeyring(testKey(t))
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		keyID   string
+		wrapped string
+	}{
+		{"unknown key ID", keyring, "00000000", wrapped},
+		{"key ID of another key", keyring, other.CurrentID(), wrapped},
+		{"retired key not configured", other, keyID, wrapped},
+		{"tampered", keyring, keyID, base64.StdEncoding.EncodeToString(sealed)},
+		{"too short", keyring, keyID, base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"not base64", keyring, keyID, "%%%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.keyring.Unwrap(tt.keyID, tt.wrapped); err == nil {
+				t.Error("Unwrap succeeded")
+			}
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	current := base64.StdEncoding.EncodeToString(testKey(t))
+	previous := base64.StdEncoding.EncodeToString(testKey(t))
+
+	tests := []struct {
+		name     string
+		key      string
+		previous string
+		wantNil  bool
+		wantErr  bool
+	}{
+		{"not configured", "", "", true, false},
+		{"current only", current, "", false, false},
+		{"with previous keys", current, " " + previous + ", ", false, false},
+		{"invalid base64", "not base64!", "", false, true},
+		{"wrong length", base64.StdEncoding.EncodeToString([]byte("short")), "", false, true},
+		{"invalid previous key", current, "not base64!", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := LoadKeyring(tt.key, "", tt.previous)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (keyring == nil) != tt.wantNil {
+				t.Errorf("keyring = %v, want nil %v", keyring, tt.wantNil)
+			}
+		})
+	}
+}
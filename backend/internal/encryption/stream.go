@@ -0,0 +1,240 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted files are a short header followed by independently sealed
+// AES-GCM chunks, so that any byte range can be decrypted without reading
+// the whole file. Each chunk's nonce is the header's random prefix, the
+// chunk index and a flag marking the final chunk, which prevents chunks from
+// being reordered or the file from being truncated unnoticed.
+//
+//	header: "PTENC" | version (1) | chunk size (4) | nonce prefix (7)
+//	chunk:  ciphertext of up to chunk size bytes | GCM tag (16)
+const (
+	magic      = "PTENC"
+	version    = 1
+	headerSize = len(magic) + 1 + 4 + prefixSize
+	prefixSize = 7
+	tagSize    = 16
+	ChunkSize  = 64 << 10
+)
+
+// ErrMalformed is returned when an encrypted file cannot be parsed
+var ErrMalformed = errors.New("malformed encrypted file")
+
+// Writer encrypts everything written to it. Close must be called to flush
+// the final chunk.
+type Writer struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+	counter   uint32
+	buf       []byte
+}
+
+// NewWriter writes the stream header to w and returns a Writer that seals
+// plaintext with dataKey
+func NewWriter(w io.Writer, dataKey []byte) (*Writer, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	binary.BigEndian.PutUint32(header[len(magic)+1:], ChunkSize)
+	if _, err = rand.Read(header[headerSize-prefixSize:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w, aead: aead, header: header, chunkSize: ChunkSize}, nil
+}
+
+// Write buffers p and seals every complete chunk except the last one
+func (w *Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) > w.chunkSize {
+		if err := w.seal(w.buf[:w.chunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[w.chunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close seals the remaining buffered plaintext as the final chunk. It does
+// not close the underlying writer.
+func (w *Writer) Close() error {
+	err := w.seal(w.buf, true)
+	w.buf = nil
+	return err
+}
+
+func (w *Writer) seal(plaintext []byte, last bool) error {
+	nonce := chunkNonce(w.header, w.counter, last)
+	w.counter++
+	_, err := w.w.Write(w.aead.Seal(nil, nonce, plaintext, w.header))
+	return err
+}
+
+// Reader decrypts an encrypted file on demand. It implements io.ReadSeeker
+// and io.ReaderAt over the plaintext.
+type Reader struct {
+	r         io.ReaderAt
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int64
+	chunks    int64
+	size      int64
+	offset    int64
+
+	cached int64
+	plain  []byte
+}
+
+// NewReader parses the header of an encrypted file of encSize bytes
+func NewReader(r io.ReaderAt, encSize int64, dataKey []byte) (*Reader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	if _, err = r.ReadAt(header, 0); err != nil {
+		return nil, ErrMalformed
+	}
+	if string(header[:len(magic)]) != magic || header[len(magic)] != version {
+		return nil, ErrMalformed
+	}
+
+	chunkSize := int64(binary.BigEndian.Uint32(header[len(magic)+1:]))
+	body := encSize - int64(headerSize)
+	if chunkSize == 0 || body < tagSize {
+		return nil, ErrMalformed
+	}
+
+	full := chunkSize + tagSize
+	chunks := (body + full - 1) / full
+	last := body - (chunks-1)*full
+	if last < tagSize {
+		return nil, ErrMalformed
+	}
+
+	reader := &Reader{
+		r:         r,
+		aead:      aead,
+		header:    header,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		size:      (chunks-1)*chunkSize + last - tagSize,
+		cached:    -1,
+	}
+
+	// Authenticating the final chunk up front detects truncation
+	if _, err = reader.chunk(chunks - 1); err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// Size returns the plaintext length
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Read implements io.Reader
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < r.size {
+		index := off / r.chunkSize
+		plain, err := r.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], plain[off-index*r.chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *Reader) chunk(index int64) ([]byte, error) {
+	if index == r.cached {
+		return r.plain, nil
+	}
+
+	full := r.chunkSize + tagSize
+	length := full
+	last := index == r.chunks-1
+	if last {
+		length = r.size - index*r.chunkSize + tagSize
+	}
+
+	sealed := make([]byte, length)
+	if _, err := r.r.ReadAt(sealed, int64(headerSize)+index*full); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	plain, err := r.aead.Open(sealed[:0], chunkNonce(r.header, uint32(index), last), sealed, r.header)
+	if err != nil {
+		return nil, errors.New("encrypted chunk failed authentication")
+	}
+
+	r.cached, r.plain = index, plain
+	return plain, nil
+}
+
+func chunkNonce(header []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, prefixSize+5)
+	copy(nonce, header[headerSize-prefixSize:])
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	if last {
+		nonce[prefixSize+4] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,154 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func seal(t *testing.T, key, plaintext []byte) []byte {
+	var sealed bytes.Buffer
+	w, err := NewWriter(&sealed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uneven writes exercise the chunk buffering
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), 1000)
+		if _, err = w.Write(plaintext[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plaintext = plaintext[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := testKey(t)
+	sizes := []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17}
+
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		sealed := seal(t, key, plaintext)
+		r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+		if err != nil {
+			t.Fatalf("size %d: NewReader: %v", size, err)
+		}
+		if r.Size() != int64(size) {
+			t.Errorf("size %d: Size() = %d", size, r.Size())
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: read: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("size %d: plaintext does not round-trip", size)
+		}
+	}
+}
+
+// Any byte range can be read without decrypting the rest
+func TestStreamReadAt(t *testing.T) {
+	key := testKey(t)
+	plaintext := make([]byte, 2*ChunkSize+500)
+	rand.Read(plaintext)
+	sealed := seal(t, key, plaintext)
+
+	r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		off, length int64
+	}{
+		{"start", 0, 10},
+		{"across a chunk boundary", ChunkSize - 5, 10},
+		{"whole middle chunk", ChunkSize, ChunkSize},
+		{"tail", 2*ChunkSize + 490, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := make([]byte, tt.length)
+			if _, err := r.ReadAt(p, tt.off); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p, plaintext[tt.off:tt.off+tt.length]) {
+				t.Error("wrong bytes")
+			}
+		})
+	}
+
+	if _, err := r.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(tail, plaintext[len(plaintext)-5:]) {
+		t.Errorf("read after Seek = %x, %v", tail, err)
+	}
+}
+
+// Each writer picks its own nonce prefix, so the same plaintext never
+// encrypts to the same ciphertext
+func TestStreamFreshNonce(t *testing.T) {
+	key := testKey(t)
+	plaintext := bytes.Repeat([]byte("evidence"), 100)
+	if bytes.Equal(seal(t, key, plaintext), seal(t, key, plaintext)) {
+		t.Error("identical ciphertexts for two writers")
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	key := testKey(t)
+	plaintext := make([]byte, 2*ChunkSize+100)
+	rand.Read(plaintext)
+	sealed := seal(t, key, plaintext)
+	full := ChunkSize + tagSize
+
+	tests := []struct {
+		name   string
+		key    []byte
+		tamper func([]byte) []byte
+	}{
+		{"wrong key", testKey(t), func(b []byte) []byte { return b }},
+		{"flipped bit", key, func(b []byte) []byte { b[headerSize+10] ^= 1; return b }},
+		{"flipped header", key, func(b []byte) []byte { b[headerSize-1] ^= 1; return b }},
+		{"truncated at a chunk boundary", key, func(b []byte) []byte { return b[:headerSize+2*full] }},
+		{"dropped last byte", key, func(b []byte) []byte { return b[:len(b)-1] }},
+		{"swapped chunks", key, func(b []byte) []byte {
+			first := append([]byte(nil), b[headerSize:headerSize+full]...)
+			copy(b[headerSize:], b[headerSize+full:headerSize+2*full])
+			copy(b[headerSize+full:], first)
+			return b
+		}},
+		{"bad magic", key, func(b []byte) []byte { b[0] = 'X'; return b }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.tamper(append([]byte(nil), sealed...))
+			r, err := NewReader(bytes.NewReader(data), int64(len(data)), tt.key)
+			if err != nil {
+				return
+			}
+			if _, err = io.ReadAll(r); err == nil {
+				t.Error("tampered file decrypted without error")
+			}
+		})
+	}
+}
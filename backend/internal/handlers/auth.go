@@ -83,3 +83,32 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	RespondJSON(w, user)
 }
+
+// GetNotificationPreferences returns how the current user wants to be
+// contacted
+func (h *AuthHandler) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	prefs, err := h.authService.GetNotificationPreferences(GetUserIDFromRequest(r))
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, prefs)
+}
+
+// UpdateNotificationPreferences sets the channels the current user wants to
+// be notified on and their phone number
+func (h *AuthHandler) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	var req models.NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authService.SetNotificationPreferences(GetUserIDFromRequest(r), &req); err != nil {
+		RespondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	RespondJSON(w, req)
+}
@@ -120,13 +120,48 @@ func (h *EventHandler) DeleteEvent(w http.ResponseWriter, r *http.Request) {
 
 	err = h.eventService.DeleteEvent(eventID)
 	if err != nil {
-		RespondError(w, err.Error(), http.StatusBadRequest)
+		status := http.StatusBadRequest
+		if err == services.ErrEventLegalHold || err == services.ErrEventHasMedia {
+			status = http.StatusConflict
+		}
+		RespondError(w, err.Error(), status)
 		return
 	}
 
 	RespondJSON(w, models.MessageResponse{Message: "Event deleted successfully"})
 }
 
+// SetLegalHold places or releases a legal hold on an event
+func (h *EventHandler) SetLegalHold(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		return
+	}
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.LegalHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	event, err := h.eventService.SetLegalHold(eventID, req.Hold)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "event not found" {
+			status = http.StatusNotFound
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	RespondJSON(w, event)
+}
+
 // SubscribeEvent subscribes a user to an event
 func (h *EventHandler) SubscribeEvent(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
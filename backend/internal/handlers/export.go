@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/protest-tracker/internal/services"
+)
+
+type ExportHandler struct {
+	exportService *services.ExportService
+}
+
+func NewExportHandler(exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{
+		exportService: exportService,
+	}
+}
+
+// ExportEvent streams a ZIP evidence package for an event
+func (h *ExportHandler) ExportEvent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	export, err := h.exportService.PrepareExport(eventID, GetUserIDFromRequest(r))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case err == services.ErrExportSigningKey:
+			status = http.StatusServiceUnavailable
+		case err.Error() == "event not found":
+			status = http.StatusNotFound
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename()))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Headers are already sent, so a failure leaves a truncated archive
+	if err = export.Write(w); err != nil {
+		log.Printf("Failed to export event %d: %v", eventID, err)
+	}
+}
+
+// GetSigningKey returns the PEM public key that export manifests are
+// signed with
+func (h *ExportHandler) GetSigningKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.exportService.PublicKeyPEM()
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(key)
+}
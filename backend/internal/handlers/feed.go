@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/protest-tracker/internal/realtime"
+)
+
+const (
+	// feedHeartbeat keeps idle feeds from being closed by proxies
+	feedHeartbeat = 25 * time.Second
+
+	// feedLifetime ends each connection after a while. Clients reconnect
+	// with their token, so the feed stops once it has expired.
+	feedLifetime = 15 * time.Minute
+)
+
+type FeedHandler struct {
+	hub *realtime.Hub
+}
+
+func NewFeedHandler(hub *realtime.Hub) *FeedHandler {
+	return &FeedHandler{
+		hub: hub,
+	}
+}
+
+// Stream sends changes as they happen as Server-Sent Events, filtered by
+// the user's role
+func (h *FeedHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		RespondError(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := h.hub.Subscribe(GetUserRoleFromRequest(r))
+	defer h.hub.Unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	lifetime := time.NewTimer(feedLifetime)
+	defer lifetime.Stop()
+
+	for {
+		select {
+		case msg, ok := <-client.Messages():
+			if !ok {
+				// Fell too far behind; the client reconnects and reloads
+				return
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-lifetime.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/protest-tracker/internal/jobs"
+)
+
+type JobHandler struct {
+	queue *jobs.Queue
+}
+
+func NewJobHandler(queue *jobs.Queue) *JobHandler {
+	return &JobHandler{
+		queue: queue,
+	}
+}
+
+// GetDeadJobs lists background jobs that used up their attempts, newest
+// first
+func (h *JobHandler) GetDeadJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			RespondError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	dead, err := h.queue.GetDead(limit)
+	if err != nil {
+		RespondError(w, "Failed to get jobs", http.StatusInternalServerError)
+		return
+	}
+
+	RespondJSON(w, dead)
+}
+
+// RetryJob queues a dead job again with a fresh set of attempts
+func (h *JobHandler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(mux.Vars(r)["jobId"], 10, 64)
+	if err != nil {
+		RespondError(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.queue.Retry(jobID)
+	switch {
+	case err == jobs.ErrNotDead:
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	case err == jobs.ErrPending:
+		RespondError(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		RespondError(w, "Failed to retry job", http.StatusInternalServerError)
+		return
+	}
+
+	RespondJSON(w, job)
+}
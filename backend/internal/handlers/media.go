@@ -1,14 +1,32 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/protest-tracker/internal/mediatype"
 	"github.com/protest-tracker/internal/models"
 	"github.com/protest-tracker/internal/services"
 )
 
+// maxBatchFiles caps the files in one batch upload
+const maxBatchFiles = 100
+
+// multipartOverhead allows for the form fields and part headers around an
+// uploaded file
+const multipartOverhead = 1 << 20
+
 type MediaHandler struct {
 	mediaService *services.MediaService
 }
@@ -19,7 +37,8 @@ func NewMediaHandler(mediaService *services.MediaService) *MediaHandler {
 	}
 }
 
-// GetEventMedia retrieves all media for an event
+// GetEventMedia retrieves the media for an event. Query parameters filter
+// and sort the list; see parseMediaFilter.
 func (h *MediaHandler) GetEventMedia(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	eventID, err := strconv.Atoi(vars["id"])
@@ -28,7 +47,13 @@ func (h *MediaHandler) GetEventMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	media, err := h.mediaService.GetEventMedia(eventID)
+	filter, err := parseMediaFilter(r)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.GetEventMedia(eventID, filter)
 	if err != nil {
 		RespondError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -46,14 +71,22 @@ func (h *MediaHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse multipart form
-	err = r.ParseMultipartForm(10 << 20) // 10MB limit
+	// Parse multipart form, keeping up to 10MB in memory and spooling the
+	// rest to disk. The body may not exceed the largest accepted file; each
+	// type's own limit is checked as the file is stored.
+	r.Body = http.MaxBytesReader(w, r.Body, h.mediaService.MaxUploadSize()+multipartOverhead)
+	err = r.ParseMultipartForm(10 << 20)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			RespondError(w, mediatype.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		RespondError(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
 
-	file, handler, err := r.FormFile("file")
+	file, _, err := r.FormFile("file")
 	if err != nil {
 		RespondError(w, "Failed to get file", http.StatusBadRequest)
 		return
@@ -61,48 +94,380 @@ func (h *MediaHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	mediaType := r.FormValue("type")
-	if mediaType != "photo" && mediaType != "video" {
+	if !mediatype.Valid(mediaType) {
 		RespondError(w, "Invalid media type", http.StatusBadRequest)
 		return
 	}
 
-	err = h.mediaService.UploadMedia(eventID, file, handler.Filename, mediaType)
+	source, err := uploadSource(r, r.FormValue("capturedAt"))
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := GetUserIDFromRequest(r)
+
+	media, duplicate, err := h.mediaService.UploadMedia(eventID, userID, file, mediaType, source)
+	if err != nil {
+		RespondError(w, err.Error(), uploadStatus(err))
+		return
+	}
+
+	response := models.UploadResponse{Message: "Media uploaded successfully", MediaID: media.ID}
+	if duplicate {
+		response.Message = "This file was already uploaded to this event"
+		response.Duplicate = true
+	}
+	RespondJSON(w, response)
+}
+
+// UploadMediaBatch stores every file of a multipart request, one part at a
+// time as it arrives, and reports a result per file. A "type" or
+// "capturedAt" field applies to the files after it; without a type, each
+// file's is taken from its part's Content-Type. The stored content is
+// still checked against the type.
+func (h *MediaHandler) UploadMediaBatch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+	if err = h.mediaService.CheckEvent(eventID); err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		RespondError(w, "Expected a multipart/form-data request", http.StatusBadRequest)
+		return
+	}
+
+	userID := GetUserIDFromRequest(r)
+	var mediaType, capturedAt string
+	response := models.BatchUploadResponse{Results: []models.BatchUploadResult{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Files before the break are stored; report them all the same
+			response.Results = append(response.Results, models.BatchUploadResult{
+				Status: http.StatusBadRequest,
+				Error:  "request ended before all files were received",
+			})
+			response.Failed++
+			break
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 1024))
+			part.Close()
+			if err != nil {
+				continue
+			}
+			switch part.FormName() {
+			case "type":
+				mediaType = string(value)
+			case "capturedAt":
+				capturedAt = string(value)
+			}
+			continue
+		}
+
+		if len(response.Results) == maxBatchFiles {
+			// The files before it are stored; report them and stop here
+			part.Close()
+			response.Results = append(response.Results, models.BatchUploadResult{
+				Filename: part.FileName(),
+				Status:   http.StatusBadRequest,
+				Error:    fmt.Sprintf("a batch may contain at most %d files", maxBatchFiles),
+			})
+			response.Failed++
+			break
+		}
+
+		result := h.uploadPart(eventID, userID, r, part, mediaType, capturedAt)
+		part.Close()
+		if result.Error == "" {
+			response.Uploaded++
+		} else {
+			response.Failed++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	if len(response.Results) == 0 {
+		RespondError(w, "No files in request", http.StatusBadRequest)
+		return
+	}
+	RespondJSON(w, response)
+}
+
+// uploadPart stores one file of a batch upload
+func (h *MediaHandler) uploadPart(eventID, userID int, r *http.Request, part *multipart.Part, mediaType, capturedAt string) models.BatchUploadResult {
+	result := models.BatchUploadResult{Filename: part.FileName()}
+
+	if mediaType == "" {
+		mediaType = typeFromContentType(part.Header.Get("Content-Type"))
+	}
+	if !mediatype.Valid(mediaType) {
+		result.Status, result.Error = http.StatusBadRequest, "Invalid media type"
+		return result
+	}
+	source, err := uploadSource(r, capturedAt)
+	if err != nil {
+		result.Status, result.Error = http.StatusBadRequest, err.Error()
+		return result
+	}
+
+	media, duplicate, err := h.mediaService.UploadMedia(eventID, userID, part, mediaType, source)
+	if err != nil {
+		result.Status, result.Error = uploadStatus(err), err.Error()
+		return result
+	}
+	result.Status, result.MediaID, result.Duplicate = http.StatusOK, media.ID, duplicate
+	return result
+}
+
+// typeFromContentType guesses the media type of a file from the
+// Content-Type the client sent with it
+func typeFromContentType(contentType string) string {
+	mimeType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "photo"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case mimeType == "application/pdf":
+		return "document"
+	}
+	return ""
+}
+
+// GetQuota reports the storage the current user has used and has left
+func (h *MediaHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.mediaService.GetUserUsage(GetUserIDFromRequest(r))
 	if err != nil {
 		RespondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	RespondJSON(w, models.MessageResponse{Message: "Media uploaded successfully"})
+	RespondJSON(w, usage)
+}
+
+// GetEventQuota reports the storage an event's media use and have left
+func (h *MediaHandler) GetEventQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	usage, err := h.mediaService.GetEventUsage(eventID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "event not found" {
+			status = http.StatusNotFound
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	RespondJSON(w, usage)
 }
 
-// DeleteMedia deletes a media file
+// DeleteMedia marks a media item deleted. The request body gives the
+// reason; the file is purged once the grace period ends.
 func (h *MediaHandler) DeleteMedia(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
 	mediaID, err := strconv.Atoi(vars["mediaId"])
 	if err != nil {
 		RespondError(w, "Invalid media ID", http.StatusBadRequest)
 		return
 	}
 
-	err = h.mediaService.DeleteMedia(mediaID)
+	var req models.DeleteMediaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.DeleteMedia(eventID, mediaID, GetUserIDFromRequest(r), req.Reason)
 	if err != nil {
-		RespondError(w, err.Error(), http.StatusBadRequest)
+		RespondError(w, err.Error(), retentionStatus(err))
+		return
+	}
+
+	RespondJSON(w, media)
+}
+
+// RestoreMedia undoes the deletion of a media item
+func (h *MediaHandler) RestoreMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.RestoreMedia(eventID, mediaID, GetUserIDFromRequest(r))
+	if err != nil {
+		RespondError(w, err.Error(), retentionStatus(err))
+		return
+	}
+
+	RespondJSON(w, media)
+}
+
+// SetLegalHold places or releases a legal hold on a media item
+func (h *MediaHandler) SetLegalHold(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.LegalHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.SetMediaLegalHold(eventID, mediaID, GetUserIDFromRequest(r), req.Hold)
+	if err != nil {
+		RespondError(w, err.Error(), retentionStatus(err))
+		return
+	}
+
+	RespondJSON(w, media)
+}
+
+// GetDeletedMedia lists an event's deleted media that can still be restored
+func (h *MediaHandler) GetDeletedMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.GetDeletedMedia(eventID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, media)
+}
+
+// GetQuarantinedMedia lists an event's media that have not passed a
+// malware scan
+func (h *MediaHandler) GetQuarantinedMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.GetQuarantinedMedia(eventID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, media)
+}
+
+// RescanMedia queues a media item for another malware scan
+func (h *MediaHandler) RescanMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.RescanMedia(eventID, mediaID, GetUserIDFromRequest(r))
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, media)
+}
+
+// GetMediaJobs lists the background processing jobs run for a media item
+func (h *MediaHandler) GetMediaJobs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := h.mediaService.GetMediaJobs(eventID, mediaID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	RespondJSON(w, models.MessageResponse{Message: "Media deleted successfully"})
+	RespondJSON(w, jobs)
 }
 
 // GetMedia retrieves a specific media file
 func (h *MediaHandler) GetMedia(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
 	mediaID, err := strconv.Atoi(vars["mediaId"])
 	if err != nil {
 		RespondError(w, "Invalid media ID", http.StatusBadRequest)
 		return
 	}
 
-	media, err := h.mediaService.GetMediaByID(mediaID)
+	media, err := h.mediaService.GetMedia(eventID, mediaID)
 	if err != nil {
 		RespondError(w, "Media not found", http.StatusNotFound)
 		return
@@ -110,3 +475,542 @@ func (h *MediaHandler) GetMedia(w http.ResponseWriter, r *http.Request) {
 
 	RespondJSON(w, media)
 }
+
+// GetMediaContent streams the stored file for a media item. Range,
+// If-None-Match and If-Modified-Since are handled by http.ServeContent.
+func (h *MediaHandler) GetMediaContent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	media, content, err := h.mediaService.OpenMedia(eventID, mediaID)
+	if err != nil {
+		status := http.StatusNotFound
+		if quarantined(err) {
+			status = http.StatusForbidden
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+	defer content.Close()
+
+	// Files uploaded before type detection fall back to the path extension;
+	// leaving Content-Type unset lets ServeContent sniff the first bytes
+	contentType := media.MimeType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(media.FilePath))
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%x-%x"`, media.ID, content.ModTime.UnixNano(), content.Size))
+	w.Header().Set("Cache-Control", "private, max-age=3600, no-transform")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	setDisposition(w, r, media)
+
+	// The download is logged once ServeContent has decided to send bytes,
+	// so revalidations and unsatisfiable ranges are not downloads
+	userID := GetUserIDFromRequest(r)
+	recorder := &custodyWriter{ResponseWriter: w, method: r.Method, record: func() error {
+		return h.mediaService.RecordDownload(media, userID)
+	}}
+	http.ServeContent(recorder, r, "", content.ModTime, content)
+}
+
+// GetMediaThumbnail serves a JPEG preview of a photo. The size query
+// parameter selects small, medium (default) or large.
+func (h *MediaHandler) GetMediaThumbnail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "medium"
+	}
+
+	thumb, content, err := h.mediaService.OpenThumbnail(eventID, mediaID, GetUserIDFromRequest(r), size)
+	if err != nil {
+		status := http.StatusNotFound
+		switch {
+		case errors.Is(err, services.ErrInvalidThumbnailSize):
+			status = http.StatusBadRequest
+		case quarantined(err):
+			status = http.StatusForbidden
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%s-%x"`, thumb.MediaID, thumb.Size, content.ModTime.UnixNano()))
+	w.Header().Set("Cache-Control", "private, max-age=3600, no-transform")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, "", content.ModTime, content)
+}
+
+// GetMediaMetadata returns the capture time and location kept from a photo's
+// stripped EXIF data
+func (h *MediaHandler) GetMediaMetadata(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := h.mediaService.GetMediaMetadata(eventID, mediaID, GetUserIDFromRequest(r))
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, meta)
+}
+
+// GetContentCredentials returns what a media item's C2PA manifest says
+// about its origin and edits, and whether it passed validation
+func (h *MediaHandler) GetContentCredentials(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := h.mediaService.GetContentCredentials(eventID, mediaID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, credentials)
+}
+
+// VerifyMedia re-hashes a stored file and reports whether it matches the
+// hash recorded at upload
+func (h *MediaHandler) VerifyMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.mediaService.VerifyMedia(eventID, mediaID, GetUserIDFromRequest(r))
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	RespondJSON(w, result)
+}
+
+// GetCustodyLog returns the chain-of-custody log for a media item
+func (h *MediaHandler) GetCustodyLog(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.mediaService.GetCustodyLog(eventID, mediaID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, entries)
+}
+
+// RedactMedia creates a redacted copy of a photo from the regions in the
+// request body
+func (h *MediaHandler) RedactMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	var redaction models.Redaction
+	if err := json.NewDecoder(r.Body).Decode(&redaction); err != nil {
+		RespondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.mediaService.RedactMedia(eventID, mediaID, GetUserIDFromRequest(r), &redaction)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, services.ErrRedactionUnsupported):
+			status = http.StatusUnsupportedMediaType
+		case quarantined(err):
+			status = http.StatusForbidden
+		case err.Error() == "media not found":
+			status = http.StatusNotFound
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	RespondJSON(w, media)
+}
+
+// GetMediaVersions lists the redacted copies made from a media item
+func (h *MediaHandler) GetMediaVersions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := h.mediaService.GetMediaVersions(eventID, mediaID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, versions)
+}
+
+// GetSimilarMedia lists photos in any event that look like a media item.
+// The optional threshold query parameter is the maximum perceptual hash
+// distance in bits.
+func (h *MediaHandler) GetSimilarMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(vars["mediaId"])
+	if err != nil {
+		RespondError(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	threshold, ok := similarityThreshold(r)
+	if !ok {
+		RespondError(w, "Invalid threshold", http.StatusBadRequest)
+		return
+	}
+
+	similar, err := h.mediaService.FindSimilarMedia(eventID, mediaID, threshold)
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, services.ErrNotHashed) {
+			status = http.StatusConflict
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	RespondJSON(w, similar)
+}
+
+// GetSimilarAcrossEvents lists pairs of similar photos attached to
+// different events, closest first
+func (h *MediaHandler) GetSimilarAcrossEvents(w http.ResponseWriter, r *http.Request) {
+	threshold, ok := similarityThreshold(r)
+	if !ok {
+		RespondError(w, "Invalid threshold", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			RespondError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	pairs, err := h.mediaService.FindSimilarAcrossEvents(threshold, limit)
+	if err != nil {
+		RespondError(w, "Failed to compare media", http.StatusInternalServerError)
+		return
+	}
+
+	RespondJSON(w, pairs)
+}
+
+// similarityThreshold reads the threshold query parameter, falling back to
+// the default when it is absent
+func similarityThreshold(r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("threshold")
+	if value == "" {
+		return services.DefaultSimilarityThreshold, true
+	}
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 || threshold > services.MaxSimilarityThreshold {
+		return 0, false
+	}
+	return threshold, true
+}
+
+// parseMediaFilter reads the media list filters: type, uploadedBy,
+// capturedFrom/capturedTo and uploadedFrom/uploadedTo (RFC 3339),
+// minSize/maxSize (bytes), minDuration/maxDuration (seconds), minWidth,
+// minHeight, sort and order
+func parseMediaFilter(r *http.Request) (*models.MediaFilter, error) {
+	query := r.URL.Query()
+	filter := &models.MediaFilter{
+		Type:        query.Get("type"),
+		Credentials: query.Get("credentials"),
+		Sort:        query.Get("sort"),
+		Order:       query.Get("order"),
+	}
+	if filter.Order != "" && filter.Order != "asc" && filter.Order != "desc" {
+		return nil, errors.New("order must be asc or desc")
+	}
+	switch filter.Credentials {
+	case "", "none", models.CredentialsValid, models.CredentialsUnverified, models.CredentialsInvalid:
+	default:
+		return nil, errors.New("credentials must be valid, unverified, invalid or none")
+	}
+
+	times := map[string]**time.Time{
+		"capturedFrom": &filter.CapturedFrom,
+		"capturedTo":   &filter.CapturedTo,
+		"uploadedFrom": &filter.UploadedFrom,
+		"uploadedTo":   &filter.UploadedTo,
+	}
+	for name, dest := range times {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s", name)
+			}
+			parsed = parsed.UTC()
+			*dest = &parsed
+		}
+	}
+
+	numbers := map[string]func(string) error{
+		"uploadedBy": func(v string) (err error) { filter.UploadedBy, err = strconv.Atoi(v); return },
+		"minSize":    func(v string) (err error) { filter.MinSize, err = strconv.ParseInt(v, 10, 64); return },
+		"maxSize":    func(v string) (err error) { filter.MaxSize, err = strconv.ParseInt(v, 10, 64); return },
+		"minDuration": func(v string) (err error) {
+			filter.MinDuration, err = strconv.ParseFloat(v, 64)
+			return
+		},
+		"maxDuration": func(v string) (err error) {
+			filter.MaxDuration, err = strconv.ParseFloat(v, 64)
+			return
+		},
+		"minWidth":  func(v string) (err error) { filter.MinWidth, err = strconv.Atoi(v); return },
+		"minHeight": func(v string) (err error) { filter.MinHeight, err = strconv.Atoi(v); return },
+	}
+	for name, parse := range numbers {
+		if value := query.Get(name); value != "" {
+			if err := parse(value); err != nil {
+				return nil, fmt.Errorf("invalid %s", name)
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// uploadSource collects what the client tells us about an upload: the
+// capture time it claims and where the request came from. The service
+// decides what is kept.
+func uploadSource(r *http.Request, capturedAt string) (*models.UploadSource, error) {
+	source := &models.UploadSource{
+		IP:                clientIP(r),
+		DeviceFingerprint: r.Header.Get("X-Device-Id"),
+	}
+	if source.DeviceFingerprint == "" {
+		source.DeviceFingerprint = r.UserAgent()
+	}
+
+	if capturedAt != "" {
+		parsed, err := time.Parse(time.RFC3339, capturedAt)
+		if err != nil {
+			return nil, errors.New("capturedAt must be an RFC 3339 time")
+		}
+		source.CapturedAt = &parsed
+	}
+	return source, nil
+}
+
+// clientIP is the address the request came from
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// setDisposition names the file served for media. ?download=true saves it
+// instead of opening it in the browser.
+func setDisposition(w http.ResponseWriter, r *http.Request, media *models.Media) {
+	extension := media.Extension
+	if extension == "" {
+		extension = filepath.Ext(media.FilePath)
+	}
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`%s; filename="media_%d%s"`, disposition, media.ID, extension))
+
+	// Scripts and forms in a PDF opened inline must not run with the
+	// application's origin
+	if media.Type == "document" {
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+}
+
+// errCustodyFailed stops ServeContent writing a body after custody could
+// not be recorded
+var errCustodyFailed = errors.New("custody not recorded")
+
+// custodyWriter calls record just before a GET response with status 200 or
+// 206 is sent. If recording fails, an error response is sent instead and
+// no content is written.
+type custodyWriter struct {
+	http.ResponseWriter
+	method  string
+	record  func() error
+	started bool
+	failed  bool
+}
+
+func (w *custodyWriter) WriteHeader(code int) {
+	if w.started {
+		return
+	}
+	w.started = true
+
+	if w.method == http.MethodGet && (code == http.StatusOK || code == http.StatusPartialContent) {
+		if err := w.record(); err != nil {
+			w.failed = true
+			for _, header := range []string{"Content-Disposition", "Content-Length", "Content-Range", "ETag", "Last-Modified"} {
+				w.Header().Del(header)
+			}
+			RespondError(w.ResponseWriter, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *custodyWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.failed {
+		return 0, errCustodyFailed
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// storageStatus maps size limit, quota and disk space errors to HTTP
+// status codes, or returns 0 for other errors
+func storageStatus(err error) int {
+	switch {
+	case errors.Is(err, mediatype.ErrTooLarge), errors.Is(err, services.ErrUserQuotaExceeded),
+		errors.Is(err, services.ErrEventQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrInsufficientStorage):
+		return http.StatusInsufficientStorage
+	}
+	return 0
+}
+
+// quarantined reports whether err refuses access to media that have not
+// passed a malware scan
+func quarantined(err error) bool {
+	return errors.Is(err, services.ErrQuarantined) || errors.Is(err, services.ErrInfected)
+}
+
+// retentionStatus maps deletion and legal hold errors to HTTP status codes
+func retentionStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrLegalHold), errors.Is(err, services.ErrAlreadyDeleted),
+		errors.Is(err, services.ErrRestoreDuplicate):
+		return http.StatusConflict
+	case err.Error() == "media not found":
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// uploadStatus maps media upload errors to HTTP status codes
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, mediatype.ErrUnknownType), errors.Is(err, mediatype.ErrTypeMismatch):
+		return http.StatusUnsupportedMediaType
+	case storageStatus(err) != 0:
+		return storageStatus(err)
+	case err.Error() == "event not found":
+		return http.StatusNotFound
+	case err.Error() == "invalid media type":
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
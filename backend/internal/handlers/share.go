@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/services"
+)
+
+type ShareHandler struct {
+	shareService *services.ShareService
+}
+
+func NewShareHandler(shareService *services.ShareService) *ShareHandler {
+	return &ShareHandler{
+		shareService: shareService,
+	}
+}
+
+// CreateShareLink creates a share link for an event or one of its media
+func (h *ShareHandler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.shareService.CreateLink(eventID, GetUserIDFromRequest(r), &req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "event not found" || err.Error() == "media not found" {
+			status = http.StatusNotFound
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	RespondJSON(w, link)
+}
+
+// GetShareLinks lists an event's share links
+func (h *ShareHandler) GetShareLinks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	links, err := h.shareService.GetLinks(eventID)
+	if err != nil {
+		RespondError(w, "Failed to retrieve share links", http.StatusInternalServerError)
+		return
+	}
+
+	RespondJSON(w, links)
+}
+
+// RevokeShareLink stops a share link from working
+func (h *ShareHandler) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	eventID, linkID, ok := shareLinkVars(w, r)
+	if !ok {
+		return
+	}
+
+	link, err := h.shareService.RevokeLink(eventID, linkID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, link)
+}
+
+// GetShareAccessLog lists every recorded use of a share link
+func (h *ShareHandler) GetShareAccessLog(w http.ResponseWriter, r *http.Request) {
+	eventID, linkID, ok := shareLinkVars(w, r)
+	if !ok {
+		return
+	}
+
+	accessLog, err := h.shareService.GetAccessLog(eventID, linkID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, accessLog)
+}
+
+// GetShared describes what a share link gives access to. It is served
+// without authentication; the token is the credential.
+func (h *ShareHandler) GetShared(w http.ResponseWriter, r *http.Request) {
+	shared, err := h.shareService.Describe(shareRequest(r))
+	if err != nil {
+		RespondError(w, err.Error(), shareStatus(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	RespondJSON(w, shared)
+}
+
+// GetSharedContent streams the media file behind a single-item share link,
+// or the signed ZIP evidence package behind an event share link
+func (h *ShareHandler) GetSharedContent(w http.ResponseWriter, r *http.Request) {
+	req := shareRequest(r)
+
+	bundle, err := h.shareService.IsBundle(req.Token)
+	if err != nil {
+		RespondError(w, err.Error(), shareStatus(err))
+		return
+	}
+	if bundle {
+		h.serveSharedExport(w, req)
+		return
+	}
+
+	media, content, err := h.shareService.OpenSharedMedia(req)
+	if err != nil {
+		RespondError(w, err.Error(), shareStatus(err))
+		return
+	}
+	defer content.Close()
+
+	contentType := media.MimeType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(media.FilePath))
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	setDisposition(w, r, media)
+
+	// Without a modification time there are no conditional requests, so
+	// every request that used up a view is answered with content
+	http.ServeContent(w, r, "", time.Time{}, content)
+}
+
+// serveSharedExport streams the evidence package behind an event share link
+func (h *ShareHandler) serveSharedExport(w http.ResponseWriter, req *services.ShareRequest) {
+	export, err := h.shareService.PrepareSharedExport(req)
+	if err != nil {
+		status := shareStatus(err)
+		if err == services.ErrExportSigningKey {
+			status = http.StatusServiceUnavailable
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename()))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err = export.Write(w); err != nil {
+		log.Printf("Failed to export shared event: %v", err)
+	}
+}
+
+// shareLinkVars parses the event and share link IDs from the route
+func shareLinkVars(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	linkID, err := strconv.Atoi(vars["linkId"])
+	if err != nil {
+		RespondError(w, "Invalid share link ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return eventID, linkID, true
+}
+
+// shareRequest collects the token, password and client details of a
+// request to a public share route
+func shareRequest(r *http.Request) *services.ShareRequest {
+	return &services.ShareRequest{
+		Token:     mux.Vars(r)["token"],
+		Password:  r.Header.Get("X-Share-Password"),
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// shareStatus maps share link errors to HTTP status codes
+func shareStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrShareLinkNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrSharePassword):
+		return http.StatusUnauthorized
+	case quarantined(err):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrShareLinkExpired), errors.Is(err, services.ErrShareLinkRevoked),
+		errors.Is(err, services.ErrShareViewLimit):
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/services"
+)
+
+// tusVersion is the tus resumable upload protocol version implemented here
+const tusVersion = "1.0.0"
+
+// StatusChecksumMismatch is the tus checksum extension's status code
+const StatusChecksumMismatch = 460
+
+// UploadHandler implements the core tus protocol plus the creation,
+// expiration, checksum and termination extensions. The full-file SHA-256
+// may be passed as "sha256" in Upload-Metadata and is checked once the
+// last chunk arrives.
+type UploadHandler struct {
+	uploadService *services.UploadService
+}
+
+func NewUploadHandler(uploadService *services.UploadService) *UploadHandler {
+	return &UploadHandler{
+		uploadService: uploadService,
+	}
+}
+
+// CreateUpload starts a resumable upload
+func (h *UploadHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.tus(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		RespondError(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		RespondError(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	source, err := uploadSource(r, meta["capturedAt"])
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.uploadService.CreateSession(eventID, GetUserIDFromRequest(r),
+		meta["type"], length, strings.ToLower(meta["sha256"]), source)
+	if err != nil {
+		status := storageStatus(err)
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/events/%d/uploads/%s", eventID, session.ID))
+	w.Header().Set("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetUploadOffset reports how much of an upload has been received
+func (h *UploadHandler) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !h.tus(w, r) {
+		return
+	}
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	w.Header().Set("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	if session.MediaID != 0 {
+		w.Header().Set("Upload-Media-Id", strconv.Itoa(session.MediaID))
+		if session.Duplicate {
+			w.Header().Set("Upload-Media-Duplicate", "true")
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload appends a chunk to an upload
+func (h *UploadHandler) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.tus(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		RespondError(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		RespondError(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	var checksum []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		algorithm, encoded, _ := strings.Cut(header, " ")
+		if algorithm != "sha256" {
+			RespondError(w, "Unsupported checksum algorithm", http.StatusBadRequest)
+			return
+		}
+		if checksum, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			RespondError(w, "Invalid Upload-Checksum", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, err := h.uploadService.AppendChunk(eventID, GetUserIDFromRequest(r), vars["uploadId"], offset, r.Body, checksum)
+	if session != nil {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		w.Header().Set("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	}
+	if err != nil {
+		RespondError(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+
+	if session.MediaID != 0 {
+		w.Header().Set("Upload-Media-Id", strconv.Itoa(session.MediaID))
+		if session.Duplicate {
+			w.Header().Set("Upload-Media-Duplicate", "true")
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUpload terminates an upload and discards the received data
+func (h *UploadHandler) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.tus(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.uploadService.Terminate(eventID, GetUserIDFromRequest(r), vars["uploadId"])
+	if err != nil {
+		RespondError(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UploadHandler) session(w http.ResponseWriter, r *http.Request) (*models.UploadSession, bool) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	session, err := h.uploadService.GetSession(eventID, GetUserIDFromRequest(r), vars["uploadId"])
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+func uploadErrorStatus(err error) int {
+	if status := storageStatus(err); status != 0 {
+		return status
+	}
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrUploadOffset):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrChecksumMismatch):
+		return StatusChecksumMismatch
+	}
+	return http.StatusInternalServerError
+}
+
+// tus sets the tus protocol headers and checks that the client speaks the
+// same version, answering 412 Precondition Failed when it does not. OPTIONS
+// requests are answered with the headers alone.
+func (h *UploadHandler) tus(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,checksum,termination")
+	w.Header().Set("Tus-Checksum-Algorithm", "sha256")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.uploadService.MaxSize(), 10))
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		RespondError(w, "Unsupported Tus-Resumable version; expected "+tusVersion, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes the tus "key base64value,key base64value"
+// header format
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
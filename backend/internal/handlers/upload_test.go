@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/protest-tracker/internal/services"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"one pair", "type dmlkZW8=", map[string]string{"type": "video"}, false},
+		{
+			"several pairs with spaces",
+			"type cGhvdG8=, sha256 YWJj ,capturedAt MjAyNC0wNS0wMVQxODowNDo0MFo=",
+			map[string]string{"type": "photo", "sha256": "abc", "capturedAt": "2024-05-01T18:04:40Z"},
+			false,
+		},
+		{"key without value", "is_confidential", map[string]string{"is_confidential": ""}, false},
+		{"invalid base64", "type not-base64!", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUploadMetadata(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{services.ErrUploadNotFound, http.StatusNotFound},
+		{services.ErrUploadOffset, http.StatusConflict},
+		{services.ErrUploadTooLarge, http.StatusRequestEntityTooLarge},
+		{services.ErrChecksumMismatch, StatusChecksumMismatch},
+		{fmt.Errorf("finishing upload: %w", services.ErrChecksumMismatch), StatusChecksumMismatch},
+		{errors.New("upload interrupted: unexpected EOF"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := uploadErrorStatus(tt.err); got != tt.want {
+			t.Errorf("uploadErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -20,7 +22,8 @@ func NewWitnessHandler(witnessService *services.WitnessService) *WitnessHandler
 	}
 }
 
-// ContactWitnesses sends a message to all witnesses subscribed to an event
+// ContactWitnesses queues a message to all witnesses subscribed to an event
+// and returns the contact request tracking its delivery
 func (h *WitnessHandler) ContactWitnesses(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	eventID, err := strconv.Atoi(vars["id"])
@@ -40,13 +43,118 @@ func (h *WitnessHandler) ContactWitnesses(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = h.witnessService.ContactWitnesses(eventID, req.Message)
+	contact, err := h.witnessService.ContactWitnesses(eventID, GetUserIDFromRequest(r), req.Subject, req.Message)
 	if err != nil {
-		RespondError(w, err.Error(), http.StatusBadRequest)
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrNoNotifiers) {
+			status = http.StatusServiceUnavailable
+		}
+		RespondError(w, err.Error(), status)
 		return
 	}
 
-	RespondJSON(w, models.MessageResponse{Message: "Witnesses notified"})
+	w.Header().Set("Location", fmt.Sprintf("/api/events/%d/contact-requests/%d", eventID, contact.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(contact)
+}
+
+// GetContactRequests lists the messages sent to an event's witnesses
+func (h *WitnessHandler) GetContactRequests(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	requests, err := h.witnessService.GetContactRequests(eventID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, requests)
+}
+
+// GetContactRequest returns a message sent to an event's witnesses with the
+// delivery status for each witness and channel
+func (h *WitnessHandler) GetContactRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+	requestID, err := strconv.Atoi(vars["requestId"])
+	if err != nil {
+		RespondError(w, "Invalid contact request ID", http.StatusBadRequest)
+		return
+	}
+
+	contact, err := h.witnessService.GetContactRequest(eventID, requestID)
+	if err == services.ErrContactRequestNotFound {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	RespondJSON(w, contact)
+}
+
+// SubmitStatement records the current user's statement about an event they
+// subscribed to as a witness
+func (h *WitnessHandler) SubmitStatement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.StatementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	statement, err := h.witnessService.SubmitStatement(eventID, GetUserIDFromRequest(r), req.Statement)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, services.ErrNotSubscribed):
+			status = http.StatusForbidden
+		case err.Error() == "event not found":
+			status = http.StatusNotFound
+		}
+		RespondError(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(statement)
+}
+
+// GetStatements lists the statements witnesses have given about an event
+func (h *WitnessHandler) GetStatements(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondError(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	statements, err := h.witnessService.GetStatements(eventID)
+	if err != nil {
+		RespondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	RespondJSON(w, statements)
 }
 
 // GetWitnessCount returns the number of witnesses for an event
@@ -0,0 +1,117 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/protest-tracker/internal/metadata"
+)
+
+// JPEGQuality is used for every derivative image
+const JPEGQuality = 85
+
+// MaxPixels caps the decoded size of a source image so a small file with
+// huge declared dimensions cannot exhaust memory
+const MaxPixels = 100_000_000
+
+// ErrTooLarge is returned for images whose dimensions exceed MaxPixels
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// Decode decodes a JPEG or PNG image into an RGBA copy that can be drawn
+// on, turned upright according to a JPEG's EXIF orientation
+func Decode(r io.Reader) (*image.RGBA, error) {
+	var buf bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	orientation := metadata.JPEGOrientation(buf.Bytes())
+	img, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return nil, err
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return Orient(rgba, orientation), nil
+}
+
+// EncodeJPEG encodes img as a JPEG
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodePNG encodes img as a PNG
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fit scales src down so that neither side exceeds maxSide, keeping the
+// aspect ratio. Each output pixel is the average of the source pixels it
+// covers, which avoids the aliasing of nearest-neighbour sampling. Images
+// already small enough are returned unchanged.
+func Fit(src *image.RGBA, maxSide int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= maxSide && h <= maxSide {
+		return src
+	}
+
+	dw, dh := maxSide, maxSide
+	if w >= h {
+		dh = max(1, h*maxSide/w)
+	} else {
+		dw = max(1, w*maxSide/h)
+	}
+	return resample(src, dw, dh)
+}
+
+// resample scales src to exactly dw by dh pixels by area averaging
+func resample(src *image.RGBA, dw, dh int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			setAverage(dst, x, y, src, image.Rect(x0, y0, x1, y1))
+		}
+	}
+	return dst
+}
+
+// setAverage sets dst at (x, y) to the mean colour of area in src
+func setAverage(dst *image.RGBA, x, y int, src *image.RGBA, area image.Rectangle) {
+	var r, g, b, a, n uint64
+	for sy := area.Min.Y; sy < area.Max.Y; sy++ {
+		row := src.Pix[src.PixOffset(area.Min.X, sy):src.PixOffset(area.Max.X, sy)]
+		for i := 0; i < len(row); i += 4 {
+			r += uint64(row[i])
+			g += uint64(row[i+1])
+			b += uint64(row[i+2])
+			a += uint64(row[i+3])
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	i := dst.PixOffset(x, y)
+	dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+}
@@ -0,0 +1,45 @@
+package imaging
+
+import "image"
+
+// Orient turns an image decoded as stored into the way it is meant to be
+// viewed, given its EXIF orientation (1 to 8). Orientations 5 to 8 swap
+// width and height. Other values return img unchanged.
+func Orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			// The stored pixel shown at (dx, dy)
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-dx, dy
+			case 3: // upside down
+				sx, sy = w-1-dx, h-1-dy
+			case 4: // mirrored upside down
+				sx, sy = dx, h-1-dy
+			case 5: // mirrored, turned a quarter anticlockwise
+				sx, sy = dy, dx
+			case 6: // turned a quarter anticlockwise
+				sx, sy = dy, h-1-dx
+			case 7: // mirrored, turned a quarter clockwise
+				sx, sy = w-1-dy, h-1-dx
+			case 8: // turned a quarter clockwise
+				sx, sy = w-1-dy, dx
+			}
+			si := img.PixOffset(img.Rect.Min.X+sx, img.Rect.Min.Y+sy)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], img.Pix[si:si+4])
+		}
+	}
+	return dst
+}
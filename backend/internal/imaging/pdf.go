@@ -0,0 +1,66 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"regexp"
+)
+
+// maxPDFScan bounds how much of a PDF is searched for a preview image
+const maxPDFScan = 64 << 20
+
+// minPreviewSide skips logos and stamps when looking for a page scan
+const minPreviewSide = 200
+
+// ErrNoPreview is returned for PDFs without an embedded JPEG to preview,
+// such as documents made of text or scanned to fax-style bilevel images
+var ErrNoPreview = errors.New("no preview image in document")
+
+var (
+	pdfImage    = regexp.MustCompile(`/Subtype\s*/Image`)
+	pdfDCT      = regexp.MustCompile(`/Filter\s*(\[\s*)?/DCTDecode`)
+	pdfStreamAt = regexp.MustCompile(`stream\r?\n`)
+)
+
+// DecodePDFPreview decodes the first sizeable JPEG image embedded in a PDF.
+// Scanners store each page as one JPEG, so for scanned documents this is
+// the first page. PDFs are not rendered; text-only documents have no
+// preview.
+func DecodePDFPreview(r io.Reader) (*image.RGBA, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPDFScan))
+	if err != nil {
+		return nil, err
+	}
+
+	for offset := 0; offset < len(data); {
+		loc := pdfStreamAt.FindIndex(data[offset:])
+		if loc == nil {
+			break
+		}
+		streamStart := offset + loc[1]
+		if bytes.HasSuffix(data[:offset+loc[0]], []byte("end")) {
+			offset = streamStart
+			continue
+		}
+
+		// The stream dictionary lies between the object header and the
+		// stream keyword
+		dictStart := bytes.LastIndex(data[offset:offset+loc[0]], []byte(" obj"))
+		dict := data[offset+max(dictStart, 0) : offset+loc[0]]
+		offset = streamStart
+
+		// Images decoded by another filter first cannot be read directly
+		if !pdfImage.Match(dict) || !pdfDCT.Match(dict) || bytes.Contains(dict, []byte("/FlateDecode")) {
+			continue
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data[streamStart:]))
+		if err != nil || config.Width < minPreviewSide || config.Height < minPreviewSide {
+			continue
+		}
+		return Decode(bytes.NewReader(data[streamStart:]))
+	}
+
+	return nil, ErrNoPreview
+}
@@ -0,0 +1,86 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// phashSize is the side of the grayscale image the DCT is taken over
+const phashSize = 32
+
+// PerceptualHash computes a 64-bit DCT perceptual hash. Visually similar
+// images (rescaled, recompressed, lightly cropped or colour adjusted) have
+// hashes a small Hamming distance apart.
+func PerceptualHash(img *image.RGBA) uint64 {
+	small := resample(img, phashSize, phashSize)
+
+	var luma [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for x := 0; x < phashSize; x++ {
+			i := small.PixOffset(x, y)
+			r, g, b := float64(small.Pix[i]), float64(small.Pix[i+1]), float64(small.Pix[i+2])
+			luma[y][x] = 0.299*r + 0.587*g + 0.114*b
+		}
+	}
+
+	coeffs := dct2D(&luma)
+
+	// Keep the 8x8 lowest frequencies; the DC term is left out of the
+	// median because it only reflects overall brightness
+	var low [64]float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			low[y*8+x] = coeffs[y][x]
+		}
+	}
+	sorted := append([]float64{}, low[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range low {
+		if c > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return hash
+}
+
+// HammingDistance counts the bits that differ between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// dct2D computes the type-II discrete cosine transform of a square block,
+// rows first and then columns
+func dct2D(in *[phashSize][phashSize]float64) *[phashSize][phashSize]float64 {
+	var cos [phashSize][phashSize]float64
+	for k := 0; k < phashSize; k++ {
+		for n := 0; n < phashSize; n++ {
+			cos[k][n] = math.Cos(math.Pi / phashSize * (float64(n) + 0.5) * float64(k))
+		}
+	}
+
+	var rows, out [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for k := 0; k < phashSize; k++ {
+			sum := 0.0
+			for n := 0; n < phashSize; n++ {
+				sum += in[y][n] * cos[k][n]
+			}
+			rows[y][k] = sum
+		}
+	}
+	for x := 0; x < phashSize; x++ {
+		for k := 0; k < phashSize; k++ {
+			sum := 0.0
+			for n := 0; n < phashSize; n++ {
+				sum += rows[n][x] * cos[k][n]
+			}
+			out[k][x] = sum
+		}
+	}
+	return &out
+}
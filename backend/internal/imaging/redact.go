@@ -0,0 +1,111 @@
+package imaging
+
+import "image"
+
+// RedactMode selects how redacted regions are obscured
+type RedactMode string
+
+const (
+	// Pixelate replaces each region with coarse blocks of its average colour
+	Pixelate RedactMode = "pixelate"
+	// Blackout fills each region with solid black
+	Blackout RedactMode = "blackout"
+)
+
+// minBlockSize is the smallest pixelation block. Smaller blocks leave too
+// much detail for faces to stay unrecognisable.
+const minBlockSize = 16
+
+// Redact obscures every polygon in img, in place. Polygons are lists of
+// vertices in image coordinates; a rectangle is a polygon of four. Pixels
+// are inside a polygon by the even-odd rule, so self-intersecting shapes
+// behave predictably.
+func Redact(img *image.RGBA, polygons [][]image.Point, mode RedactMode) {
+	bounds := img.Bounds()
+	block := max(minBlockSize, max(bounds.Dx(), bounds.Dy())/50)
+
+	for _, polygon := range polygons {
+		area := polygonBounds(polygon).Intersect(bounds)
+		if area.Empty() {
+			continue
+		}
+
+		// Snap blocks to a grid anchored at the image origin so neighbouring
+		// regions pixelate consistently
+		for by := area.Min.Y - area.Min.Y%block; by < area.Max.Y; by += block {
+			for bx := area.Min.X - area.Min.X%block; bx < area.Max.X; bx += block {
+				cell := image.Rect(bx, by, bx+block, by+block).Intersect(area)
+				redactCell(img, cell, polygon, mode)
+			}
+		}
+	}
+}
+
+// redactCell obscures the pixels of cell that lie inside polygon. Pixelated
+// cells take the average of the whole cell so no in-polygon detail leaks
+// through the colour of a partially covered block.
+func redactCell(img *image.RGBA, cell image.Rectangle, polygon []image.Point, mode RedactMode) {
+	var fill [4]uint8
+	if mode == Blackout {
+		fill = [4]uint8{0, 0, 0, 255}
+	} else {
+		var sum [4]uint64
+		n := uint64(0)
+		for y := cell.Min.Y; y < cell.Max.Y; y++ {
+			for x := cell.Min.X; x < cell.Max.X; x++ {
+				i := img.PixOffset(x, y)
+				for c := 0; c < 4; c++ {
+					sum[c] += uint64(img.Pix[i+c])
+				}
+				n++
+			}
+		}
+		for c := 0; c < 4; c++ {
+			fill[c] = uint8(sum[c] / n)
+		}
+	}
+
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			if !insidePolygon(polygon, x, y) {
+				continue
+			}
+			i := img.PixOffset(x, y)
+			copy(img.Pix[i:i+4], fill[:])
+		}
+	}
+}
+
+// insidePolygon reports whether the centre of pixel (x, y) lies inside
+// polygon using the even-odd rule
+func insidePolygon(polygon []image.Point, x, y int) bool {
+	// Work in doubled coordinates so pixel centres are integers
+	px, py := 2*x+1, 2*y+1
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		ax, ay := 2*polygon[i].X, 2*polygon[i].Y
+		bx, by := 2*polygon[j].X, 2*polygon[j].Y
+		if (ay > py) == (by > py) {
+			continue
+		}
+		// Compare px with the edge's x at height py without dividing
+		lhs := (px - ax) * (by - ay)
+		rhs := (bx - ax) * (py - ay)
+		if (by > ay && lhs < rhs) || (by < ay && lhs > rhs) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func polygonBounds(polygon []image.Point) image.Rectangle {
+	if len(polygon) == 0 {
+		return image.Rectangle{}
+	}
+	r := image.Rectangle{Min: polygon[0], Max: polygon[0]}
+	for _, p := range polygon[1:] {
+		r.Min.X, r.Min.Y = min(r.Min.X, p.X), min(r.Min.Y, p.Y)
+		r.Max.X, r.Max.Y = max(r.Max.X, p.X), max(r.Max.Y, p.Y)
+	}
+	return r
+}
@@ -0,0 +1,135 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestInsidePolygon(t *testing.T) {
+	square := []image.Point{{0, 0}, {10, 0}, {10, 10}, {0, 10}}
+	// An L shape: the top-right quarter is cut away
+	ell := []image.Point{{0, 0}, {4, 0}, {4, 6}, {10, 6}, {10, 10}, {0, 10}}
+	triangle := []image.Point{{0, 0}, {10, 0}, {0, 10}}
+
+	tests := []struct {
+		name    string
+		polygon []image.Point
+		x, y    int
+		want    bool
+	}{
+		{"square top left pixel", square, 0, 0, true},
+		{"square bottom right pixel", square, 9, 9, true},
+		{"square right edge is exclusive", square, 10, 5, false},
+		{"square bottom edge is exclusive", square, 5, 10, false},
+		{"left of square", square, -1, 5, false},
+		{"L inside the stem", ell, 2, 2, true},
+		{"L inside the foot", ell, 8, 8, true},
+		{"L cut away corner", ell, 8, 2, false},
+		{"triangle near the right angle", triangle, 1, 1, true},
+		{"triangle on the hypotenuse", triangle, 5, 5, false},
+		{"triangle beyond the hypotenuse", triangle, 8, 8, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := insidePolygon(tt.polygon, tt.x, tt.y); got != tt.want {
+				t.Errorf("insidePolygon(%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+// testImage fills a w×h image with a pattern that differs at every pixel
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 5), uint8(y * 5), uint8(x ^ y), 255})
+		}
+	}
+	return img
+}
+
+func TestRedactBlackout(t *testing.T) {
+	tests := []struct {
+		name    string
+		polygon []image.Point
+		inside  func(x, y int) bool
+	}{
+		{
+			"rectangle",
+			[]image.Point{{10, 12}, {30, 12}, {30, 20}, {10, 20}},
+			func(x, y int) bool { return x >= 10 && x < 30 && y >= 12 && y < 20 },
+		},
+		{
+			"rectangle past the edge",
+			[]image.Point{{40, -10}, {80, -10}, {80, 10}, {40, 10}},
+			func(x, y int) bool { return x >= 40 && y < 10 },
+		},
+		{
+			"triangle",
+			[]image.Point{{0, 0}, {20, 0}, {0, 20}},
+			func(x, y int) bool { return 2*x+1+2*y+1 < 40 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := testImage(48, 32)
+			original := testImage(48, 32)
+			Redact(img, [][]image.Point{tt.polygon}, Blackout)
+
+			for y := 0; y < 32; y++ {
+				for x := 0; x < 48; x++ {
+					want := original.RGBAAt(x, y)
+					if tt.inside(x, y) {
+						want = color.RGBA{0, 0, 0, 255}
+					}
+					if got := img.RGBAAt(x, y); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Pixelated blocks are a single colour and nothing outside the region
+// changes
+func TestRedactPixelate(t *testing.T) {
+	img := testImage(64, 64)
+	original := testImage(64, 64)
+	Redact(img, [][]image.Point{{{16, 16}, {48, 16}, {48, 48}, {16, 48}}}, Pixelate)
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			inside := x >= 16 && x < 48 && y >= 16 && y < 48
+			got := img.RGBAAt(x, y)
+			if !inside {
+				if got != original.RGBAAt(x, y) {
+					t.Fatalf("pixel (%d, %d) outside the region changed", x, y)
+				}
+				continue
+			}
+			// Blocks are minBlockSize pixels on a grid from the origin
+			corner := img.RGBAAt(x-x%minBlockSize, y-y%minBlockSize)
+			if got != corner {
+				t.Fatalf("pixel (%d, %d) = %v, its block is %v", x, y, got, corner)
+			}
+			if got == original.RGBAAt(x, y) && x%minBlockSize != 0 {
+				t.Fatalf("pixel (%d, %d) kept its original colour", x, y)
+			}
+		}
+	}
+}
+
+func TestRedactIgnoresRegionsOutsideImage(t *testing.T) {
+	img := testImage(16, 16)
+	original := testImage(16, 16)
+	Redact(img, [][]image.Point{{{20, 20}, {30, 20}, {30, 30}}, {}}, Blackout)
+
+	if string(img.Pix) != string(original.Pix) {
+		t.Error("image changed")
+	}
+}
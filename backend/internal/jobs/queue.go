@@ -0,0 +1,270 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/repository"
+)
+
+const (
+	// pollInterval is how often idle workers look for due jobs that were
+	// not enqueued by this process, or whose retry time has come
+	pollInterval = 5 * time.Second
+
+	// lease is how long a job may run before it is presumed abandoned by
+	// a crashed worker and queued again
+	lease = 30 * time.Minute
+
+	// keepFinished is how long successful jobs are kept for inspection
+	keepFinished = 7 * 24 * time.Hour
+
+	// Retries wait baseBackoff, doubling with each attempt up to maxBackoff
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+)
+
+// Retry errors that handlers map to specific status codes
+var (
+	ErrNotDead = errors.New("dead job not found")
+	ErrPending = repository.ErrJobPending
+)
+
+// Handler runs one job. A returned error, or a panic, fails the attempt.
+type Handler func(job *models.Job) error
+
+type jobType struct {
+	handler     Handler
+	maxAttempts int
+}
+
+// Queue is a durable job queue stored in Postgres. Jobs survive restarts,
+// failed attempts are retried with exponential backoff and jobs that keep
+// failing are dead-lettered. Several processes may work the same queue.
+type Queue struct {
+	repo *repository.JobRepository
+
+	mu    sync.RWMutex
+	types map[string]jobType
+
+	wake chan struct{}
+}
+
+func NewQueue(repo *repository.JobRepository) *Queue {
+	return &Queue{
+		repo:  repo,
+		types: make(map[string]jobType),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Register makes jobs of type name run handler, trying each job up to
+// maxAttempts times. Register all types before calling Run.
+func (q *Queue) Register(name string, maxAttempts int, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.types[name] = jobType{handler: handler, maxAttempts: max(maxAttempts, 1)}
+}
+
+// Enqueue adds a job of a registered type. mediaID ties the job to a media
+// item (0 for none); a job of the same type already pending for the item
+// is not added twice. payload, if not nil, is stored as JSON.
+func (q *Queue) Enqueue(name string, mediaID int, payload interface{}) error {
+	return q.enqueue(name, mediaID, 0, payload, false)
+}
+
+// EnqueueEvent is Enqueue for a job tied to an event rather than a media
+// item
+func (q *Queue) EnqueueEvent(name string, eventID int, payload interface{}) error {
+	return q.enqueue(name, 0, eventID, payload, false)
+}
+
+// EnqueueOnce is Enqueue for catching up on work that predates the queue:
+// nothing is added if the media item has ever had a job of this type.
+func (q *Queue) EnqueueOnce(name string, mediaID int) error {
+	return q.enqueue(name, mediaID, 0, nil, true)
+}
+
+func (q *Queue) enqueue(name string, mediaID, eventID int, payload interface{}, once bool) error {
+	q.mu.RLock()
+	registered, ok := q.types[name]
+	q.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown job type %q", name)
+	}
+
+	job := &models.Job{
+		Type:        name,
+		MediaID:     mediaID,
+		EventID:     eventID,
+		MaxAttempts: registered.maxAttempts,
+		RunAt:       time.Now().UTC(),
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		job.Payload = data
+	}
+
+	added, err := q.repo.Enqueue(job, once)
+	if err != nil || !added {
+		return err
+	}
+	q.notify(job)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run starts workers goroutines that process jobs, then keeps the queue
+// tidy: abandoned jobs are requeued and old finished jobs deleted. It does
+// not return.
+func (q *Queue) Run(workers int) {
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+
+	for {
+		now := time.Now().UTC()
+		if n, err := q.repo.RequeueStale(now.Add(-lease), now); err != nil {
+			log.Printf("Failed to requeue abandoned jobs: %v", err)
+		} else if n > 0 {
+			log.Printf("Requeued %d abandoned jobs", n)
+		}
+		if _, err := q.repo.DeleteFinished(now.Add(-keepFinished)); err != nil {
+			log.Printf("Failed to delete finished jobs: %v", err)
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// Retry queues a dead job again with a fresh set of attempts
+func (q *Queue) Retry(id int64) (*models.Job, error) {
+	job, err := q.repo.Requeue(id, time.Now().UTC())
+	if err == sql.ErrNoRows {
+		return nil, ErrNotDead
+	}
+	if err != nil {
+		return nil, err
+	}
+	q.notify(job)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// GetDead lists up to limit dead jobs, newest first
+func (q *Queue) GetDead(limit int) ([]models.Job, error) {
+	return q.repo.GetByStatus(models.JobDead, limit)
+}
+
+// GetMediaJobs lists the jobs run for a media item
+func (q *Queue) GetMediaJobs(mediaID int) ([]models.Job, error) {
+	return q.repo.GetByMediaID(mediaID)
+}
+
+// work claims and runs due jobs until there are none, then waits to be
+// woken by Enqueue or for the next poll
+func (q *Queue) work() {
+	for {
+		q.mu.RLock()
+		names := make([]string, 0, len(q.types))
+		for name := range q.types {
+			names = append(names, name)
+		}
+		q.mu.RUnlock()
+
+		job, err := q.repo.Claim(names, time.Now().UTC())
+		if err == nil {
+			q.run(job)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to claim job: %v", err)
+		}
+
+		select {
+		case <-q.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// run makes one attempt at a claimed job and records the outcome
+func (q *Queue) run(job *models.Job) {
+	q.notify(job)
+
+	q.mu.RLock()
+	registered := q.types[job.Type]
+	q.mu.RUnlock()
+
+	err := safely(registered.handler, job)
+	now := time.Now().UTC()
+
+	switch {
+	case err == nil:
+		job.Status = models.JobDone
+		err = q.repo.Complete(job.ID, now)
+	case job.Attempts >= job.MaxAttempts:
+		log.Printf("Job %d (%s) failed for good after %d attempts: %v", job.ID, job.Type, job.Attempts, err)
+		job.Status, job.LastError = models.JobDead, err.Error()
+		err = q.repo.Bury(job.ID, now, job.LastError)
+	default:
+		log.Printf("Job %d (%s) failed, attempt %d of %d: %v", job.ID, job.Type, job.Attempts, job.MaxAttempts, err)
+		job.Status, job.LastError = models.JobQueued, err.Error()
+		err = q.repo.Retry(job.ID, now.Add(Backoff(job.Attempts)), job.LastError)
+	}
+	if err != nil {
+		log.Printf("Failed to record outcome of job %d: %v", job.ID, err)
+		return
+	}
+	q.notify(job)
+}
+
+// notify brings the processing status of the job's media item up to date
+func (q *Queue) notify(job *models.Job) {
+	if job.MediaID == 0 {
+		return
+	}
+	if err := q.repo.RefreshMediaStatus(job.MediaID); err != nil {
+		log.Printf("Failed to update processing status of media %d: %v", job.MediaID, err)
+	}
+}
+
+// safely runs handler, turning a panic into an error so one bad job cannot
+// stop its worker
+func safely(handler Handler, job *models.Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return handler(job)
+}
+
+// Backoff is the wait before the next try after the given failed attempt:
+// exponential, capped, with jitter so that jobs failing together do not
+// retry together
+func Backoff(attempt int) time.Duration {
+	attempt = max(attempt, 1)
+	wait := maxBackoff
+	if attempt < 20 {
+		wait = min(baseBackoff<<(attempt-1), maxBackoff)
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
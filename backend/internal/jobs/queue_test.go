@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		wait    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{19, time.Hour},
+		{20, time.Hour},
+		{1000, time.Hour},
+	}
+
+	for _, tt := range tests {
+		// The jitter takes off up to half the wait
+		low, high := tt.wait/2, tt.wait
+		for i := 0; i < 100; i++ {
+			if got := Backoff(tt.attempt); got < low || got > high {
+				t.Fatalf("Backoff(%d) = %v, want between %v and %v", tt.attempt, got, low, high)
+			}
+		}
+	}
+}
+
+// Jobs that fail together spread their retries out
+func TestBackoffJitter(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		seen[Backoff(5)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("20 retries of attempt 5 all wait %v", Backoff(5))
+	}
+}
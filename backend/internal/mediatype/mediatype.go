@@ -0,0 +1,158 @@
+package mediatype
+
+import (
+	"bytes"
+	"errors"
+)
+
+// SniffLen is how many leading bytes Detect needs to see
+const SniffLen = 4096
+
+// ErrUnknownType is returned when the content matches no supported format
+var ErrUnknownType = errors.New("unrecognized file type")
+
+// ErrTypeMismatch is returned when the content is not allowed for the
+// claimed media category
+var ErrTypeMismatch = errors.New("file content does not match media type")
+
+// ErrTooLarge is returned when a file is bigger than its category allows
+var ErrTooLarge = errors.New("file exceeds the size limit for its media type")
+
+// allowed lists the MIME types accepted for each media category
+var allowed = map[string][]string{
+	"photo": {"image/jpeg", "image/png", "image/heic", "image/heif"},
+	"video": {"video/mp4", "video/quicktime", "video/3gpp", "video/webm", "video/x-matroska"},
+	"audio": {"audio/mpeg", "audio/mp4", "audio/3gpp", "audio/webm", "audio/wav",
+		"audio/ogg", "audio/flac", "audio/amr", "audio/aac"},
+	"document": {"application/pdf"},
+}
+
+// audioContainers maps container formats that hold audio or video alike to
+// their audio type. Phones and browsers record audio into the same MP4 and
+// WebM containers as video, so the magic bytes cannot tell them apart.
+var audioContainers = map[string]string{
+	"video/mp4":  "audio/mp4",
+	"video/3gpp": "audio/3gpp",
+	"video/webm": "audio/webm",
+}
+
+// extensions maps detected MIME types to the extension used in storage
+var extensions = map[string]string{
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/heic":       ".heic",
+	"image/heif":       ".heif",
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/3gpp":       ".3gp",
+	"video/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+	"audio/mpeg":       ".mp3",
+	"audio/mp4":        ".m4a",
+	"audio/3gpp":       ".3gp",
+	"audio/webm":       ".weba",
+	"audio/wav":        ".wav",
+	"audio/ogg":        ".ogg",
+	"audio/flac":       ".flac",
+	"audio/amr":        ".amr",
+	"audio/aac":        ".aac",
+	"application/pdf":  ".pdf",
+}
+
+// Detected describes the real type of a file
+type Detected struct {
+	MimeType  string
+	Extension string
+}
+
+// Valid reports whether category is a known media category
+func Valid(category string) bool {
+	_, ok := allowed[category]
+	return ok
+}
+
+// Validate detects the type of head (the first SniffLen bytes of a file)
+// and checks that it is allowed for category
+func Validate(category string, head []byte) (*Detected, error) {
+	mimeType := Detect(head)
+	if mimeType == "" {
+		return nil, ErrUnknownType
+	}
+	if audio, ok := audioContainers[mimeType]; ok && category == "audio" {
+		mimeType = audio
+	}
+	for _, candidate := range allowed[category] {
+		if candidate == mimeType {
+			return &Detected{MimeType: mimeType, Extension: extensions[mimeType]}, nil
+		}
+	}
+	return nil, ErrTypeMismatch
+}
+
+// Detect returns the MIME type identified by the file's magic bytes, or
+// "" if the format is not supported
+func Detect(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "audio/mpeg"
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return "audio/wav"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		// Theora video is the only Ogg content that is not audio
+		if bytes.Contains(head, []byte("\x80theora")) {
+			return ""
+		}
+		return "audio/ogg"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(head, []byte("#!AMR")):
+		return "audio/amr"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync; layer bits 00 mark an AAC ADTS stream
+		if head[1]&0x06 == 0 {
+			return "audio/aac"
+		}
+		return "audio/mpeg"
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// EBML header; the DocType element distinguishes WebM from Matroska
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return detectFtyp(head)
+	case len(head) >= 8:
+		// QuickTime files predating the ftyp box start with another atom
+		switch string(head[4:8]) {
+		case "moov", "mdat", "wide", "free", "skip", "pnot":
+			return "video/quicktime"
+		}
+	}
+	return ""
+}
+
+// detectFtyp classifies ISO base media files by their major brand
+func detectFtyp(head []byte) string {
+	switch string(head[8:12]) {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx":
+		return "image/heic"
+	case "mif1", "msf1":
+		return "image/heif"
+	case "qt  ":
+		return "video/quicktime"
+	case "M4A ", "M4B ":
+		return "audio/mp4"
+	case "3gp4", "3gp5", "3gp6", "3gp7", "3ge6", "3ge7", "3gg6", "3g2a", "3g2b", "3g2c":
+		return "video/3gpp"
+	case "isom", "iso2", "iso3", "iso4", "iso5", "iso6", "mp41", "mp42", "mp71",
+		"avc1", "M4V ", "M4VP", "dash", "mmp4", "MSNV", "NDAS", "f4v ":
+		return "video/mp4"
+	}
+	return ""
+}
@@ -0,0 +1,202 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+const (
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagDateTimeOriginal = 0x9003
+	tagOffsetTimeOrig   = 0x9011
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+)
+
+// typeSizes maps TIFF field types to their size in bytes
+var typeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseExif extracts capture time, GPS position and orientation from a
+// TIFF-structured EXIF payload. Parsing is best effort: a damaged block
+// yields an empty Info rather than an error, since the block is being
+// discarded anyway.
+func parseExif(tiff []byte) *Info {
+	info := &Info{}
+	if len(tiff) < 8 {
+		return info
+	}
+
+	t := &tiffReader{data: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return info
+	}
+
+	ifd0 := t.readIFD(t.order.Uint32(tiff[4:8]))
+	if ifd0 == nil {
+		return info
+	}
+
+	if e, ok := ifd0[tagOrientation]; ok {
+		if orientation := t.long(e); orientation >= 1 && orientation <= 8 {
+			info.Orientation = int(orientation)
+		}
+	}
+
+	dateTime := t.ascii(ifd0[tagDateTime])
+	offset := ""
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if exifIFD := t.readIFD(t.long(e)); exifIFD != nil {
+			if v := t.ascii(exifIFD[tagDateTimeOriginal]); v != "" {
+				dateTime = v
+			}
+			offset = t.ascii(exifIFD[tagOffsetTimeOrig])
+		}
+	}
+	info.CapturedAt = parseExifTime(dateTime, offset)
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps := t.readIFD(t.long(e)); gps != nil {
+			lat, latOK := t.degrees(gps[tagGPSLatitude])
+			lon, lonOK := t.degrees(gps[tagGPSLongitude])
+			if latOK && lonOK {
+				if strings.HasPrefix(t.ascii(gps[tagGPSLatitudeRef]), "S") {
+					lat = -lat
+				}
+				if strings.HasPrefix(t.ascii(gps[tagGPSLongitudeRef]), "W") {
+					lon = -lon
+				}
+				info.Latitude = &lat
+				info.Longitude = &lon
+			}
+		}
+	}
+
+	return info
+}
+
+// readIFD reads the directory at offset into a map keyed by tag
+func (t *tiffReader) readIFD(offset uint32) map[uint16]*tiffEntry {
+	if offset < 8 || uint64(offset)+2 > uint64(len(t.data)) {
+		return nil
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	pos := offset + 2
+	if uint64(pos)+uint64(count)*12 > uint64(len(t.data)) {
+		return nil
+	}
+
+	entries := make(map[uint16]*tiffEntry, count)
+	for i := 0; i < count; i++ {
+		raw := t.data[pos : pos+12]
+		pos += 12
+
+		typ := t.order.Uint16(raw[2:4])
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+		count := t.order.Uint32(raw[4:8])
+		total := uint64(size) * uint64(count)
+
+		var value []byte
+		if total <= 4 {
+			value = raw[8 : 8+total]
+		} else {
+			start := uint64(t.order.Uint32(raw[8:12]))
+			if start+total > uint64(len(t.data)) {
+				continue
+			}
+			value = t.data[start : start+total]
+		}
+		entries[t.order.Uint16(raw[0:2])] = &tiffEntry{typ: typ, count: count, value: value}
+	}
+
+	return entries
+}
+
+func (t *tiffReader) long(e *tiffEntry) uint32 {
+	switch {
+	case e.typ == 4 && len(e.value) >= 4:
+		return t.order.Uint32(e.value)
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(t.order.Uint16(e.value))
+	}
+	return 0
+}
+
+func (t *tiffReader) ascii(e *tiffEntry) string {
+	if e == nil || e.typ != 2 {
+		return ""
+	}
+	if i := bytes.IndexByte(e.value, 0); i >= 0 {
+		return strings.TrimSpace(string(e.value[:i]))
+	}
+	return strings.TrimSpace(string(e.value))
+}
+
+// degrees converts a degrees/minutes/seconds rational triple to decimal
+func (t *tiffReader) degrees(e *tiffEntry) (float64, bool) {
+	if e == nil || e.typ != 5 || e.count < 3 {
+		return 0, false
+	}
+
+	var parts [3]float64
+	for i := range parts {
+		num := t.order.Uint32(e.value[i*8:])
+		den := t.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+
+	return parts[0] + parts[1]/60 + parts[2]/3600, true
+}
+
+// parseExifTime parses an EXIF "YYYY:MM:DD HH:MM:SS" timestamp. EXIF stores
+// local time; without an offset tag the value is interpreted as UTC.
+func parseExifTime(value, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	loc := time.UTC
+	if offset != "" {
+		if tz, err := time.Parse("-07:00", offset); err == nil {
+			_, secs := tz.Zone()
+			loc = time.FixedZone(offset, secs)
+		}
+	}
+
+	parsed, err := time.ParseInLocation("2006:01:02 15:04:05", value, loc)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
@@ -0,0 +1,284 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+type isoBox struct {
+	typ   string
+	start int // offset of the box header
+	body  int // offset of the box payload
+	end   int
+}
+
+type itemExtent struct {
+	offset uint64
+	length uint64
+}
+
+// stripHEIC blanks the Exif and XMP items of a HEIF container. Item
+// payloads are overwritten with zeros in place so that the offsets in the
+// iloc box, and therefore the image items, remain valid.
+func stripHEIC(data []byte) ([]byte, *Info, error) {
+	out := make([]byte, len(data))
+	copy(out, data)
+	info := &Info{}
+
+	boxes, err := readBoxes(out, 0, len(out))
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := findBox(boxes, "meta")
+	if meta == nil {
+		return out, info, nil
+	}
+
+	// meta is a full box: skip version and flags
+	children, err := readBoxes(out, meta.body+4, meta.end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iinf := findBox(children, "iinf")
+	iloc := findBox(children, "iloc")
+	if iinf == nil || iloc == nil {
+		return out, info, nil
+	}
+
+	targets, exifItems, err := metadataItems(out, iinf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(targets) == 0 {
+		return out, info, nil
+	}
+
+	locations, err := itemLocations(out, iloc, findBox(children, "idat"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for id := range targets {
+		for _, ext := range locations[id] {
+			n := uint64(len(out))
+			if ext.offset > n || ext.length > n-ext.offset {
+				return nil, nil, ErrMalformed
+			}
+			region := out[ext.offset : ext.offset+ext.length]
+			if exifItems[id] {
+				// Exif items start with a 4-byte offset to the TIFF header
+				if len(region) > 4 {
+					skip := 4 + uint64(binary.BigEndian.Uint32(region))
+					if skip < uint64(len(region)) {
+						info = parseExif(region[skip:])
+					}
+				}
+			}
+			for i := range region {
+				region[i] = 0
+			}
+		}
+	}
+
+	return out, info, nil
+}
+
+func readBoxes(data []byte, start, end int) ([]isoBox, error) {
+	var boxes []isoBox
+	pos := start
+	for pos+8 <= end {
+		size := uint64(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(end - pos)
+		case 1:
+			if pos+16 > end {
+				return nil, ErrMalformed
+			}
+			size = binary.BigEndian.Uint64(data[pos+8:])
+			header = 16
+		}
+		if size < uint64(header) || uint64(pos)+size > uint64(end) {
+			return nil, ErrMalformed
+		}
+		boxes = append(boxes, isoBox{typ: typ, start: pos, body: pos + header, end: pos + int(size)})
+		pos += int(size)
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []isoBox, typ string) *isoBox {
+	for i := range boxes {
+		if boxes[i].typ == typ {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// metadataItems returns the IDs of Exif and XMP items listed in iinf
+func metadataItems(data []byte, iinf *isoBox) (map[uint32]bool, map[uint32]bool, error) {
+	if iinf.body+6 > iinf.end {
+		return nil, nil, ErrMalformed
+	}
+	pos := iinf.body + 4
+	if data[iinf.body] == 0 {
+		pos += 2
+	} else {
+		pos += 4
+	}
+
+	entries, err := readBoxes(data, pos, iinf.end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets := make(map[uint32]bool)
+	exif := make(map[uint32]bool)
+	for _, infe := range entries {
+		if infe.typ != "infe" || infe.body+4 > infe.end {
+			continue
+		}
+		version := data[infe.body]
+		p := infe.body + 4
+		var id uint32
+		switch version {
+		case 2:
+			if p+8 > infe.end {
+				continue
+			}
+			id = uint32(binary.BigEndian.Uint16(data[p:]))
+			p += 2
+		case 3:
+			if p+10 > infe.end {
+				continue
+			}
+			id = binary.BigEndian.Uint32(data[p:])
+			p += 4
+		default:
+			continue
+		}
+		p += 2 // item_protection_index
+		itemType := string(data[p : p+4])
+		p += 4
+
+		switch itemType {
+		case "Exif":
+			targets[id] = true
+			exif[id] = true
+		case "mime":
+			// item_name and content_type are null-terminated strings
+			fields := strings.Split(string(data[p:infe.end]), "\x00")
+			if len(fields) > 1 && strings.Contains(fields[1], "rdf+xml") {
+				targets[id] = true
+			}
+		}
+	}
+
+	return targets, exif, nil
+}
+
+// itemLocations resolves every item in iloc to absolute file extents.
+// Items stored in idat (construction method 1) are resolved relative to the
+// idat payload; other construction methods are ignored.
+func itemLocations(data []byte, iloc, idat *isoBox) (map[uint32][]itemExtent, error) {
+	r := &boxReader{data: data, pos: iloc.body, end: iloc.end}
+	version := r.u8()
+	r.skip(3)
+	sizes := r.u8()
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = r.u8()
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+
+	locations := make(map[uint32][]itemExtent)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.uint(2))
+		} else {
+			id = uint32(r.uint(4))
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0x0F
+		}
+		r.skip(2) // data_reference_index
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+		for j := uint64(0); j < extents && r.err == nil; j++ {
+			if indexSize > 0 {
+				r.uint(indexSize)
+			}
+			offset := base + r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			switch method {
+			case 0:
+			case 1:
+				if idat == nil {
+					continue
+				}
+				offset += uint64(idat.body)
+			default:
+				continue
+			}
+			locations[id] = append(locations[id], itemExtent{offset: offset, length: length})
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return locations, nil
+}
+
+type boxReader struct {
+	data []byte
+	pos  int
+	end  int
+	err  error
+}
+
+func (r *boxReader) skip(n int) {
+	if r.pos+n > r.end {
+		r.err = ErrMalformed
+		return
+	}
+	r.pos += n
+}
+
+func (r *boxReader) u8() byte {
+	if r.pos+1 > r.end {
+		r.err = ErrMalformed
+		return 0
+	}
+	v := r.data[r.pos]
+	r.pos++
+	return v
+}
+
+// uint reads a big-endian unsigned integer of n bytes (0, 2, 4 or 8)
+func (r *boxReader) uint(n int) uint64 {
+	if r.pos+n > r.end {
+		r.err = ErrMalformed
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[r.pos : r.pos+n] {
+		v = v<<8 | uint64(b)
+	}
+	r.pos += n
+	return v
+}
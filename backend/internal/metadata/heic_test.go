@@ -0,0 +1,37 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	out := binary.BigEndian.AppendUint32(nil, uint32(size))
+	out = append(out, typ...)
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// An iloc extent whose offset and length wrap around when added must be
+// rejected, not sliced
+func TestStripHEICExtentOverflow(t *testing.T) {
+	infe := box("infe", []byte{2, 0, 0, 0}, []byte{0, 1, 0, 0}, []byte("Exif"), []byte{0})
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+
+	iloc := []byte{0, 0, 0, 0, 0x88, 0x00, 0, 1, 0, 1, 0, 0, 0, 1}
+	iloc = binary.BigEndian.AppendUint64(iloc, 0xFFFFFFFFFFFFFFF0)
+	iloc = binary.BigEndian.AppendUint64(iloc, 0x20)
+
+	data := append(box("ftyp", []byte("heic"), []byte{0, 0, 0, 0}),
+		box("meta", []byte{0, 0, 0, 0}, iinf, box("iloc", iloc))...)
+
+	if _, _, err := Strip(data); err != ErrMalformed {
+		t.Fatalf("Strip returned %v, want ErrMalformed", err)
+	}
+}
@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	"bytes"
+)
+
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP14 = 0xEE
+	markerCOM   = 0xFE
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// stripJPEG copies a JPEG segment by segment, dropping every APPn segment
+// except JFIF (APP0), ICC profiles (APP2) and Adobe colour info (APP14), as
+// well as comments and any data trailing the EOI marker. The EXIF block is
+// replaced by one holding only its orientation, when that is not upright.
+func stripJPEG(data []byte) ([]byte, *Info, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	info := &Info{}
+
+	pos := 2
+	for {
+		// Skip fill bytes before the marker code
+		for pos < len(data) && data[pos] == 0xFF && pos+1 < len(data) && data[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+1 >= len(data) || data[pos] != 0xFF {
+			return nil, nil, ErrMalformed
+		}
+
+		marker := data[pos+1]
+		if marker == markerEOI {
+			out.Write(data[pos : pos+2])
+			return out.Bytes(), info, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return nil, nil, ErrMalformed
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, nil, ErrMalformed
+		}
+		payload := data[pos+4 : end]
+
+		if marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			oriented := info.Orientation > 1
+			info = parseExif(payload[len(exifHeader):])
+			if info.Orientation > 1 && !oriented {
+				out.Write(orientationSegment(info.Orientation))
+			}
+		}
+		if keepJPEGSegment(marker, payload) {
+			out.Write(data[pos:end])
+		}
+		pos = end
+
+		if marker == markerSOS {
+			// Copy entropy-coded data up to the next real marker
+			scanEnd := scanEntropyData(data, pos)
+			out.Write(data[pos:scanEnd])
+			pos = scanEnd
+		}
+	}
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == markerCOM:
+		return false
+	case marker == markerAPP0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00")) || bytes.HasPrefix(payload, []byte("JFXX\x00"))
+	case marker == markerAPP2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == markerAPP14:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= markerAPP0 && marker <= 0xEF:
+		return false
+	}
+	return true
+}
+
+// orientationSegment builds an APP1 EXIF segment whose only field is the
+// orientation tag
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0, 0, 0, 8, // header, IFD0 at offset 8
+		0, 1, // one entry
+		byte(tagOrientation >> 8), byte(tagOrientation & 0xFF), 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0,
+		0, 0, 0, 0, // no next IFD
+	}
+	length := 2 + len(exifHeader) + len(tiff)
+	segment := []byte{0xFF, markerAPP1, byte(length >> 8), byte(length)}
+	segment = append(segment, exifHeader...)
+	return append(segment, tiff...)
+}
+
+// JPEGOrientation returns the EXIF orientation recorded in the start of a
+// JPEG, 1 to 8, or 0 if there is none. header need only reach the first
+// frame header.
+func JPEGOrientation(header []byte) int {
+	if !isJPEG(header) {
+		return 0
+	}
+	pos := 2
+	for pos+4 <= len(header) && header[pos] == 0xFF {
+		marker := header[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == markerSOS || marker == markerEOI || (marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC) {
+			return 0
+		}
+		end := pos + 2 + (int(header[pos+2])<<8 | int(header[pos+3]))
+		if end > len(header) {
+			return 0
+		}
+		payload := header[pos+4 : end]
+		if marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			return parseExif(payload[len(exifHeader):]).Orientation
+		}
+		pos = end
+	}
+	return 0
+}
+
+// scanEntropyData returns the offset of the first marker after pos that is
+// not a stuffed byte or restart marker
+func scanEntropyData(data []byte, pos int) int {
+	for pos+1 < len(data) {
+		if data[pos] != 0xFF {
+			pos++
+			continue
+		}
+		next := data[pos+1]
+		if next == 0x00 || next == 0xFF || (next >= 0xD0 && next <= 0xD7) {
+			pos++
+			continue
+		}
+		return pos
+	}
+	return len(data)
+}
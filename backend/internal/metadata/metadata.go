@@ -0,0 +1,61 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// ErrUnsupportedFormat is returned when an image is not JPEG, PNG or HEIC
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// ErrMalformed is returned when an image container cannot be parsed
+var ErrMalformed = errors.New("malformed image")
+
+// Info holds the fields kept from embedded metadata before it is removed
+type Info struct {
+	CapturedAt *time.Time
+	Latitude   *float64
+	Longitude  *float64
+
+	// EXIF orientation, 1 to 8; 0 when the image does not record one
+	Orientation int
+}
+
+// Strip removes EXIF, XMP and IPTC metadata from a JPEG, PNG or HEIC image.
+// It returns the sanitized image together with the capture time and GPS
+// position found in the EXIF block, if any. A JPEG's orientation is written
+// back in an EXIF block of its own so the photo still displays upright;
+// other EXIF fields (device serial numbers, owner names and so on) are
+// discarded.
+func Strip(data []byte) ([]byte, *Info, error) {
+	switch {
+	case isJPEG(data):
+		return stripJPEG(data)
+	case isPNG(data):
+		return stripPNG(data)
+	case isHEIC(data):
+		return stripHEIC(data)
+	default:
+		return nil, nil, ErrUnsupportedFormat
+	}
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) > 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF
+}
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+func isHEIC(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(data[8:12]) {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are ancillary chunks that can carry EXIF, XMP, IPTC or
+// free-form text. Everything else is copied unchanged with its CRC.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG copies a PNG chunk by chunk, dropping metadata chunks and any
+// data after IEND
+func stripPNG(data []byte) ([]byte, *Info, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	info := &Info{}
+
+	pos := len(pngSignature)
+	for {
+		if pos+8 > len(data) {
+			return nil, nil, ErrMalformed
+		}
+		length := uint64(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		end := uint64(pos) + 12 + length
+		if end > uint64(len(data)) {
+			return nil, nil, ErrMalformed
+		}
+
+		if chunkType == "eXIf" {
+			info = parseExif(data[pos+8 : uint64(pos)+8+length])
+		}
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[pos:end])
+		}
+		pos = int(end)
+
+		if chunkType == "IEND" {
+			return out.Bytes(), info, nil
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 	"github.com/protest-tracker/internal/models"
 )
 
@@ -13,10 +14,15 @@ import (
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, If-None-Match, "+
+			"Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Checksum, X-Share-Password, X-Device-Id")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Range, Content-Length, Accept-Ranges, ETag, Location, "+
+			"Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires, Upload-Media-Id, Upload-Media-Duplicate")
 
-		if r.Method == "OPTIONS" {
+		// Browser preflights are answered here. Other OPTIONS requests to
+		// the upload routes are tus discovery and reach their handler.
+		if r.Method == "OPTIONS" && (r.Header.Get("Access-Control-Request-Method") != "" || !tusRoute(r)) {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -25,6 +31,17 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// tusRoute reports whether a request was routed to a resumable upload
+// endpoint
+func tusRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	return err == nil && strings.HasPrefix(template, "/api/events/{id}/uploads")
+}
+
 // AuthMiddleware validates JWT tokens
 func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -65,6 +82,21 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 	}
 }
 
+// QueryTokenMiddleware accepts the JWT in the access_token query parameter
+// when there is no Authorization header, for clients such as EventSource
+// that cannot set headers. Place it before AuthMiddleware.
+func QueryTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if token := query.Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+			query.Del("access_token")
+			r.URL.RawQuery = query.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // AdvocateOnlyMiddleware restricts access to advocates only
 func AdvocateOnlyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
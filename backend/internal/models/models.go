@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,6 +14,10 @@ type User struct {
 	PhoneNumber string `json:"phone_number"`
 	Password    string `json:"-"`
 	Role        string `json:"role"`
+
+	// Channels the user wants to be notified on, in order of preference;
+	// empty for the default
+	NotificationChannels []string `json:"notification_channels,omitempty"`
 }
 
 // ArrestEvent represents a protest arrest event
@@ -23,14 +28,462 @@ type ArrestEvent struct {
 	Longitude float64   `json:"longitude"`
 	Notes     string    `json:"notes"`
 	CreatedBy int       `json:"createdBy"`
+
+	// A legal hold blocks deletion of the event and all of its media
+	LegalHold bool `json:"legalHold"`
+
+	// When a time-stamping authority attested to the event as created, or
+	// as it stood then for events stamped late
+	TimestampedAt *time.Time `json:"timestampedAt,omitempty"`
 }
 
 // Media represents uploaded media files
 type Media struct {
 	ID       int    `json:"id"`
 	EventID  int    `json:"eventId"`
-	FilePath string `json:"filePath"`
+	FilePath string `json:"-"`
 	Type     string `json:"type"`
+	SHA256   string `json:"sha256,omitempty"`
+
+	// Detected from the file's magic bytes, never from client input
+	MimeType  string `json:"mimeType,omitempty"`
+	Size      int64  `json:"size"`
+	Extension string `json:"extension,omitempty"`
+
+	// Provenance. CapturedAt comes from the photo's EXIF, the video
+	// container or, failing those, the client, as CaptureSource records.
+	UploadedBy    int        `json:"uploadedBy,omitempty"`
+	UploadedAt    time.Time  `json:"uploadedAt"`
+	CapturedAt    *time.Time `json:"capturedAt,omitempty"`
+	CaptureSource string     `json:"captureSource,omitempty"`
+	Width         int        `json:"width,omitempty"`
+	Height        int        `json:"height,omitempty"`
+	Duration      *float64   `json:"duration,omitempty"` // seconds
+
+	// Only recorded when enabled by the privacy settings, and never
+	// included in exports or share links
+	UploadIP          string `json:"uploadIp,omitempty"`
+	DeviceFingerprint string `json:"deviceFingerprint,omitempty"`
+
+	// Set on redacted derivatives: the original they were made from and
+	// the regions obscured. Originals are never modified.
+	ParentID  *int       `json:"parentId,omitempty"`
+	Redaction *Redaction `json:"redaction,omitempty"`
+
+	// A legal hold blocks deletion. Deleted media stay recoverable until
+	// PurgeAfter, when the reaper removes the file.
+	LegalHold    bool       `json:"legalHold"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"`
+	DeletedBy    int        `json:"deletedBy,omitempty"`
+	DeleteReason string     `json:"deleteReason,omitempty"`
+	PurgeAfter   *time.Time `json:"purgeAfter,omitempty"`
+
+	// Malware scan verdict. Media are quarantined (hidden from listings and
+	// blocked from download) until the scan comes back clean.
+	ScanStatus string     `json:"scanStatus"`
+	ScanResult string     `json:"scanResult,omitempty"`
+	ScannedAt  *time.Time `json:"scannedAt,omitempty"`
+
+	// Background processing (scan, probing, previews): queued, running,
+	// done or failed, derived from the media's jobs
+	ProcessingStatus string `json:"processingStatus"`
+
+	// When a time-stamping authority attested to SHA256
+	TimestampedAt *time.Time `json:"timestampedAt,omitempty"`
+
+	// Outcome of validating the file's C2PA content credentials: valid,
+	// unverified or invalid; empty if it has none
+	CredentialsStatus string `json:"credentialsStatus,omitempty"`
+
+	// 64-bit perceptual hash of a photo as 16 hex digits. Visually similar
+	// photos have hashes a small Hamming distance apart.
+	PerceptualHash string `json:"perceptualHash,omitempty"`
+
+	// Thumbnail sizes generated so far; filled in when listing an event's media
+	Thumbnails []string `json:"thumbnails,omitempty"`
+
+	// Envelope encryption: the file's data key sealed by master key KeyID.
+	// Both are empty for files stored in plaintext.
+	KeyID      string `json:"-"`
+	WrappedKey string `json:"-"`
+}
+
+// Where a media item's capture time came from
+const (
+	CaptureSourceEXIF      = "exif"
+	CaptureSourceContainer = "container"
+	CaptureSourceClient    = "client"
+)
+
+// UploadSource is what the client tells us about an upload besides the
+// file itself
+type UploadSource struct {
+	CapturedAt        *time.Time
+	IP                string
+	DeviceFingerprint string
+}
+
+// StorageUsage is the space used by a user's uploads or an event's media.
+// QuotaBytes is 0 and RemainingBytes omitted when there is no quota.
+type StorageUsage struct {
+	UsedBytes      int64  `json:"usedBytes"`
+	Files          int    `json:"files"`
+	QuotaBytes     int64  `json:"quotaBytes"`
+	RemainingBytes *int64 `json:"remainingBytes,omitempty"`
+}
+
+// MediaFilter narrows and orders an event's media list. Zero values do not
+// filter.
+type MediaFilter struct {
+	Type         string
+	UploadedBy   int
+	CapturedFrom *time.Time
+	CapturedTo   *time.Time
+	UploadedFrom *time.Time
+	UploadedTo   *time.Time
+	MinSize      int64
+	MaxSize      int64
+	MinDuration  float64
+	MaxDuration  float64
+	MinWidth     int
+	MinHeight    int
+
+	// Credentials is a content credentials status, or "none" for media
+	// without them
+	Credentials string
+
+	// Sort is one of uploadedAt (default), capturedAt, size, duration,
+	// width or height; Order is asc or desc (default)
+	Sort  string
+	Order string
+}
+
+// Malware scan statuses
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanError    = "error"
+)
+
+// Media processing statuses
+const (
+	ProcessingQueued  = "queued"
+	ProcessingRunning = "running"
+	ProcessingDone    = "done"
+	ProcessingFailed  = "failed"
+)
+
+// Job is a unit of background work in the durable job queue. Failed jobs
+// are retried with backoff until MaxAttempts, then left dead for an
+// advocate to inspect and retry.
+type Job struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	MediaID     int             `json:"mediaId,omitempty"`
+	EventID     int             `json:"eventId,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	LockedAt    *time.Time      `json:"lockedAt,omitempty"`
+	LastError   string          `json:"lastError,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// Job statuses
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Point is a pixel position in an image
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// RedactionRegion is an area of a photo to obscure: the rectangle given by
+// X, Y, Width and Height, or the polygon in Points when it is set
+type RedactionRegion struct {
+	X      int     `json:"x,omitempty"`
+	Y      int     `json:"y,omitempty"`
+	Width  int     `json:"width,omitempty"`
+	Height int     `json:"height,omitempty"`
+	Points []Point `json:"points,omitempty"`
+}
+
+// Redaction describes how a redacted derivative was produced
+type Redaction struct {
+	Mode    string            `json:"mode"` // "pixelate" or "blackout"
+	Regions []RedactionRegion `json:"regions"`
+}
+
+// Blob is a stored file shared by every media record with the same
+// content. Files are stored once per SHA-256 and removed when the last
+// referencing record is purged.
+type Blob struct {
+	SHA256     string
+	FilePath   string
+	Size       int64
+	KeyID      string
+	WrappedKey string
+	RefCount   int
+}
+
+// Thumbnail is a downscaled JPEG preview of a photo, stored and encrypted
+// like the original
+type Thumbnail struct {
+	MediaID    int    `json:"mediaId"`
+	Size       string `json:"size"`
+	FilePath   string `json:"-"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Bytes      int64  `json:"bytes"`
+	KeyID      string `json:"-"`
+	WrappedKey string `json:"-"`
+}
+
+// SimilarMedia is a photo that looks like another one, Distance bits apart
+// in perceptual hash
+type SimilarMedia struct {
+	Media    Media `json:"media"`
+	Distance int   `json:"distance"`
+}
+
+// SimilarPair is two similar photos attached to different events
+type SimilarPair struct {
+	A        Media `json:"a"`
+	B        Media `json:"b"`
+	Distance int   `json:"distance"`
+}
+
+// MediaMetadata holds capture details extracted from a photo before its
+// embedded metadata is stripped. It is only exposed to advocates.
+type MediaMetadata struct {
+	MediaID    int        `json:"mediaId"`
+	CapturedAt *time.Time `json:"capturedAt,omitempty"`
+	Latitude   *float64   `json:"latitude,omitempty"`
+	Longitude  *float64   `json:"longitude,omitempty"`
+}
+
+// Content credential statuses
+const (
+	CredentialsValid      = "valid"
+	CredentialsUnverified = "unverified"
+	CredentialsInvalid    = "invalid"
+)
+
+// ContentCredentials is what a media item's C2PA manifest says about where
+// it came from, and whether that could be verified. Photos are inspected
+// before their metadata is stripped, so the details are kept here rather
+// than read from the stored file. Only exposed to advocates.
+type ContentCredentials struct {
+	MediaID int    `json:"mediaId"`
+	Status  string `json:"status"`
+	// Why the manifest is invalid or could not be verified
+	Problems []string `json:"problems,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Who signed the manifest, and the certificate authority that vouched
+	// for them
+	Signer string `json:"signer,omitempty"`
+	Issuer string `json:"issuer,omitempty"`
+
+	// Claimed by the signer: the software that wrote the manifest, the
+	// capture device and the title
+	ClaimGenerator string `json:"claimGenerator,omitempty"`
+	Device         string `json:"device,omitempty"`
+	Title          string `json:"title,omitempty"`
+
+	// Edit history, oldest first, and the files the content was made from
+	Actions     []CredentialAction     `json:"actions,omitempty"`
+	Ingredients []CredentialIngredient `json:"ingredients,omitempty"`
+	Manifests   int                    `json:"manifests"`
+
+	InspectedAt time.Time `json:"inspectedAt"`
+}
+
+// CredentialAction is one step of the edit history, such as c2pa.created
+// or c2pa.cropped
+type CredentialAction struct {
+	Action        string `json:"action"`
+	When          string `json:"when,omitempty"`
+	SoftwareAgent string `json:"softwareAgent,omitempty"`
+	Description   string `json:"description,omitempty"`
+}
+
+// CredentialIngredient is a file content was made from
+type CredentialIngredient struct {
+	Title        string `json:"title,omitempty"`
+	Format       string `json:"format,omitempty"`
+	Relationship string `json:"relationship,omitempty"`
+}
+
+// Chain-of-custody actions
+const (
+	CustodyUpload   = "upload"
+	CustodyAccess   = "access"
+	CustodyDownload = "download"
+	CustodyExport   = "export"
+	CustodyDelete   = "delete"
+	CustodyVerify   = "verify"
+	CustodyRedact   = "redact"
+	CustodyHold     = "legal_hold"
+	CustodyRestore  = "restore"
+	CustodyPurge    = "purge"
+	CustodyScan     = "scan"
+)
+
+// CustodyEntry is one append-only record in a media item's custody log.
+// Each entry hashes the previous one so that removed or edited rows break
+// the chain.
+type CustodyEntry struct {
+	ID        int       `json:"id"`
+	MediaID   int       `json:"mediaId"`
+	EventID   int       `json:"eventId"`
+	UserID    int       `json:"userId,omitempty"`
+	Action    string    `json:"action"`
+	Details   string    `json:"details,omitempty"`
+	PrevHash  string    `json:"prevHash"`
+	EntryHash string    `json:"entryHash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// VerifyResult reports whether a stored file still matches its upload hash
+type VerifyResult struct {
+	MediaID  int    `json:"mediaId"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Match    bool   `json:"match"`
+}
+
+// UploadSession tracks a resumable upload that has not been turned into a
+// media record yet
+type UploadSession struct {
+	ID        string    `json:"id"`
+	EventID   int       `json:"eventId"`
+	UserID    int       `json:"userId"`
+	Type      string    `json:"type"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	SHA256    string    `json:"sha256,omitempty"`
+	MediaID   int       `json:"mediaId,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// Client-supplied capture time and, when recorded, origin of the upload
+	CapturedAt        *time.Time `json:"capturedAt,omitempty"`
+	UploadIP          string     `json:"-"`
+	DeviceFingerprint string     `json:"-"`
+
+	// Set when the finished file was already attached to the event and
+	// MediaID is the existing record
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	// Staging files are encrypted with this key when a master key is set
+	KeyID      string `json:"-"`
+	WrappedKey string `json:"-"`
+}
+
+// ExportFile is one file in an evidence export and its hash
+type ExportFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// ExportManifest lists every file in an evidence export. It is signed so
+// recipients can check the package offline.
+type ExportManifest struct {
+	EventID     int          `json:"eventId"`
+	GeneratedAt time.Time    `json:"generatedAt"`
+	GeneratedBy int          `json:"generatedBy"`
+	Algorithm   string       `json:"algorithm"`
+	KeyID       string       `json:"keyId"`
+	Files       []ExportFile `json:"files"`
+
+	// Media whose exported bytes no longer match the hash taken at upload
+	Mismatched []int `json:"mismatched,omitempty"`
+
+	// RFC 3161 timestamps of the event and its media, checked at export
+	Timestamps []TimestampCheck `json:"timestamps,omitempty"`
+	// The file holding the CA certificate the timestamps were checked
+	// against; empty when the server has none configured
+	TSACertificate string `json:"tsaCertificate,omitempty"`
+}
+
+// TimestampCheck is the outcome of verifying an RFC 3161 timestamp token
+// included in an evidence export
+type TimestampCheck struct {
+	// "event" or "media", and its ID
+	Subject string `json:"subject"`
+	ID      int    `json:"id"`
+	// The token file and, for events, the record it covers
+	Path       string `json:"path"`
+	RecordPath string `json:"recordPath,omitempty"`
+	// Set when the event record was taken after the event was created, so
+	// it shows the event as it stood then rather than as reported
+	RecordedLate bool `json:"recordedLate,omitempty"`
+	// Attested time and the authority's certificate subject
+	Time      *time.Time `json:"time,omitempty"`
+	Authority string     `json:"authority,omitempty"`
+	Valid     bool       `json:"valid"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// ShareLink gives someone without an account time-limited access to one
+// media item or, when MediaID is nil, a whole event as an evidence package
+type ShareLink struct {
+	ID          int        `json:"id"`
+	EventID     int        `json:"eventId"`
+	MediaID     *int       `json:"mediaId,omitempty"`
+	CreatedBy   int        `json:"createdBy"`
+	Note        string     `json:"note,omitempty"`
+	HasPassword bool       `json:"hasPassword"`
+	MaxViews    *int       `json:"maxViews,omitempty"`
+	ViewCount   int        `json:"viewCount"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+
+	// Signed token for the public share URL
+	Token string `json:"token,omitempty"`
+
+	PasswordHash string `json:"-"`
+}
+
+// Share link access outcomes
+const (
+	ShareAccessGranted     = "granted"
+	ShareAccessInfo        = "info"
+	ShareAccessBadPassword = "bad_password"
+	ShareAccessExpired     = "expired"
+	ShareAccessRevoked     = "revoked"
+	ShareAccessViewLimit   = "view_limit"
+)
+
+// ShareAccess is one attempt to use a share link
+type ShareAccess struct {
+	ID         int       `json:"id"`
+	LinkID     int       `json:"linkId"`
+	Outcome    string    `json:"outcome"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	AccessedAt time.Time `json:"accessedAt"`
+}
+
+// SharedContent describes what a share link gives access to
+type SharedContent struct {
+	EventID   int       `json:"eventId"`
+	EventTime time.Time `json:"eventTime"`
+	Media     []Media   `json:"media"`
+	Bundle    bool      `json:"bundle"` // content is a ZIP evidence package
+	ExpiresAt time.Time `json:"expiresAt"`
+	ViewsLeft *int      `json:"viewsLeft,omitempty"`
 }
 
 // Subscription represents event subscriptions
@@ -65,6 +518,121 @@ type MessageResponse struct {
 	Message string `json:"message"`
 }
 
+type UploadResponse struct {
+	Message   string `json:"message"`
+	MediaID   int    `json:"mediaId"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// BatchUploadResult is the outcome of one file of a batch upload. Status
+// is the HTTP status the file would have got uploaded on its own.
+type BatchUploadResult struct {
+	Filename  string `json:"filename"`
+	Status    int    `json:"status"`
+	MediaID   int    `json:"mediaId,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type BatchUploadResponse struct {
+	Uploaded int                 `json:"uploaded"`
+	Failed   int                 `json:"failed"`
+	Results  []BatchUploadResult `json:"results"`
+}
+
+// WitnessStatement is an account of an event given by a witness subscribed
+// to it. Statements cannot be edited; a correction is a new statement.
+type WitnessStatement struct {
+	ID        int       `json:"id"`
+	EventID   int       `json:"eventId"`
+	UserID    int       `json:"userId,omitempty"`
+	Statement string    `json:"statement"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type StatementRequest struct {
+	Statement string `json:"statement"`
+}
+
 type ContactWitnessRequest struct {
+	Subject string `json:"subject"`
 	Message string `json:"message"`
 }
+
+// ContactRequest is a message advocates sent an event's witnesses, with
+// how its delivery is going
+type ContactRequest struct {
+	ID        int       `json:"id"`
+	EventID   int       `json:"eventId"`
+	SentBy    int       `json:"sentBy,omitempty"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Witnesses messaged, and deliveries by status
+	Recipients int `json:"recipients"`
+	Queued     int `json:"queued"`
+	Sent       int `json:"sent"`
+	Failed     int `json:"failed"`
+	Bounced    int `json:"bounced"`
+
+	Deliveries []Delivery `json:"deliveries,omitempty"`
+}
+
+// Delivery is a message to one witness over one channel. Channel is empty
+// when no channel could reach the witness.
+type Delivery struct {
+	ID            int64      `json:"id"`
+	UserID        int        `json:"userId"`
+	Channel       string     `json:"channel,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+// Delivery statuses
+const (
+	DeliveryQueued  = "queued"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	DeliveryBounced = "bounced"
+)
+
+// OutboxMessage is a delivery claimed for sending, with the message and
+// the witness's current addresses
+type OutboxMessage struct {
+	ID       int64
+	EventID  int
+	UserID   int
+	Channel  string
+	Attempts int
+	Email    string
+	Phone    string
+	Subject  string
+	Message  string
+}
+
+// NotificationPreferences are how a user wants to be contacted
+type NotificationPreferences struct {
+	Channels    []string `json:"channels"`
+	PhoneNumber string   `json:"phone_number,omitempty"`
+}
+
+type LegalHoldRequest struct {
+	Hold bool `json:"hold"`
+}
+
+type CreateShareLinkRequest struct {
+	MediaID   *int   `json:"mediaId"`
+	ExpiresIn string `json:"expiresIn"` // Go duration, e.g. "72h"
+	Password  string `json:"password"`
+	MaxViews  *int   `json:"maxViews"`
+	Note      string `json:"note"`
+}
+
+type DeleteMediaRequest struct {
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Channels a message can be delivered over, in the order they are tried
+// for users without a preference
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelWebhook = "webhook"
+)
+
+// Channels lists every channel
+var Channels = []string{ChannelEmail, ChannelSMS, ChannelWebhook}
+
+// ErrNoAddress is returned when a recipient has no address on a channel,
+// such as a user without a phone number and SMS
+var ErrNoAddress = errors.New("recipient has no address for this channel")
+
+// ErrBounced is wrapped by errors for messages that were refused for good,
+// such as mail to an unknown mailbox or a text to a number the gateway
+// rejects. Sending them again will not help.
+var ErrBounced = errors.New("bounced")
+
+func bounced(err error) error {
+	return fmt.Errorf("%w: %v", ErrBounced, err)
+}
+
+// Recipient is a user a message is for, with the addresses channels use
+type Recipient struct {
+	UserID int
+	Email  string
+	Phone  string
+}
+
+// Message is a notification about an event
+type Message struct {
+	// ID identifies the delivery and stays the same when it is retried,
+	// so that receivers can drop duplicates
+	ID      int64
+	EventID int
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages over one channel
+type Notifier interface {
+	// Channel names the channel ("email", "sms", "webhook")
+	Channel() string
+	// Send delivers msg to recipient. An error means it may not have
+	// arrived; errors wrapping ErrBounced mean it never will.
+	Send(recipient Recipient, msg Message) error
+}
+
+// Config holds the settings of every channel. Channels without an address
+// (SMTP host, gateway or webhook URL) are left out.
+type Config struct {
+	SMTP    SMTPConfig
+	SMS     SMSConfig
+	Webhook WebhookConfig
+	Timeout time.Duration
+}
+
+// Load creates a notifier for each configured channel
+func Load(cfg Config) ([]Notifier, error) {
+	var notifiers []Notifier
+	if cfg.SMTP.Host != "" {
+		smtp, err := NewSMTP(cfg.SMTP, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, smtp)
+	}
+	if cfg.SMS.URL != "" {
+		sms, err := NewSMSGateway(cfg.SMS, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, sms)
+	}
+	if cfg.Webhook.URL != "" {
+		webhook, err := NewWebhook(cfg.Webhook, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	return notifiers, nil
+}
+
+// ValidChannel reports whether channel is a known channel
+func ValidChannel(channel string) bool {
+	for _, known := range Channels {
+		if channel == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SMSConfig holds the settings for an HTTP SMS gateway
+type SMSConfig struct {
+	URL   string
+	Token string
+	From  string
+}
+
+// SMSGateway sends text messages through an HTTP gateway. Each message is
+// POSTed as JSON {"to", "from", "message"}, with the token as a bearer
+// credential; any 2xx response counts as accepted, and 400, 404 or 422 as
+// a number that cannot be texted. Most providers, or a
+// small relay in front of them, can take this shape.
+type SMSGateway struct {
+	cfg    SMSConfig
+	client *http.Client
+}
+
+// NewSMSGateway creates an SMS notifier
+func NewSMSGateway(cfg SMSConfig, timeout time.Duration) (*SMSGateway, error) {
+	if err := checkURL(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid SMS gateway URL: %v", err)
+	}
+	return &SMSGateway{cfg: cfg, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Channel implements Notifier
+func (g *SMSGateway) Channel() string {
+	return ChannelSMS
+}
+
+// Send implements Notifier. Texts carry only the body; the subject is
+// left out to keep them short.
+func (g *SMSGateway) Send(recipient Recipient, msg Message) error {
+	if recipient.Phone == "" {
+		return ErrNoAddress
+	}
+	body, err := json.Marshal(map[string]string{
+		"to":      recipient.Phone,
+		"from":    g.cfg.From,
+		"message": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	headers := http.Header{}
+	if g.cfg.Token != "" {
+		headers.Set("Authorization", "Bearer "+g.cfg.Token)
+	}
+	err = post(g.client, g.cfg.URL, body, headers)
+	var refused *statusError
+	if errors.As(err, &refused) && rejectedNumber[refused.code] {
+		return bounced(err)
+	}
+	return err
+}
+
+// rejectedNumber are the gateway responses that mean the number cannot be
+// texted, rather than that the gateway is unavailable
+var rejectedNumber = map[int]bool{
+	http.StatusBadRequest:          true,
+	http.StatusNotFound:            true,
+	http.StatusUnprocessableEntity: true,
+}
+
+// statusError is returned for a response outside 2xx
+type statusError struct {
+	host   string
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s returned %s", e.host, e.status)
+}
+
+// post sends a JSON body and checks for a 2xx response
+func post(client *http.Client, target string, body []byte, headers http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = headers
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{host: req.URL.Host, status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
+
+func checkURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("%q is not an HTTP URL", raw)
+	}
+	return nil
+}
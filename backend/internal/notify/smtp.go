@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP transport security modes
+const (
+	// Upgrade the connection with STARTTLS; servers that do not offer it
+	// are refused
+	SMTPStartTLS = "starttls"
+	// Connect over TLS from the start, usually on port 465
+	SMTPTLS = "tls"
+	// Plain text, for local mail sinks only
+	SMTPPlain = "none"
+)
+
+// SMTPConfig holds the settings for sending email
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	Security string // starttls (default), tls or none
+}
+
+// SMTP sends notifications as plain-text email
+type SMTP struct {
+	cfg     SMTPConfig
+	from    *mail.Address
+	timeout time.Duration
+}
+
+// NewSMTP creates an email notifier
+func NewSMTP(cfg SMTPConfig, timeout time.Duration) (*SMTP, error) {
+	if cfg.Security == "" {
+		cfg.Security = SMTPStartTLS
+	}
+	if cfg.Security != SMTPStartTLS && cfg.Security != SMTPTLS && cfg.Security != SMTPPlain {
+		return nil, fmt.Errorf("unknown SMTP security mode %q", cfg.Security)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP sender %q: %v", cfg.From, err)
+	}
+	return &SMTP{cfg: cfg, from: from, timeout: timeout}, nil
+}
+
+// Channel implements Notifier
+func (s *SMTP) Channel() string {
+	return ChannelEmail
+}
+
+// Send implements Notifier
+func (s *SMTP) Send(recipient Recipient, msg Message) error {
+	if recipient.Email == "" {
+		return ErrNoAddress
+	}
+	to, err := mail.ParseAddress(recipient.Email)
+	if err != nil {
+		return bounced(fmt.Errorf("invalid email address: %v", err))
+	}
+	body, err := s.compose(to, msg)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.dial()
+	if err != nil {
+		return fmt.Errorf("SMTP connection failed: %v", err)
+	}
+	defer client.Close()
+
+	if s.cfg.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err = client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+	if err = client.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("SMTP server refused sender: %v", err)
+	}
+	if err = client.Rcpt(to.Address); err != nil {
+		refused := fmt.Errorf("SMTP server refused recipient: %v", err)
+		if permanent(err) {
+			return bounced(refused)
+		}
+		return refused
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP server refused message: %v", err)
+	}
+	if _, err = w.Write(body); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("SMTP server refused message: %v", err)
+	}
+	return client.Quit()
+}
+
+// dial connects to the server and secures the connection as configured.
+// The whole conversation must finish within the timeout.
+func (s *SMTP) dial() (*smtp.Client, error) {
+	address := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{Timeout: s.timeout}
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host}
+
+	var conn net.Conn
+	var err error
+	if s.cfg.Security == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.cfg.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("server does not support STARTTLS")
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// permanent reports whether err is a 5xx reply, which the server will
+// give again
+func permanent(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 500
+}
+
+// compose formats msg as a MIME message
+func (s *SMTP) compose(to *mail.Address, msg Message) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := s.from.Address[strings.LastIndex(s.from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	headers := [][2]string{
+		{"From", s.from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id) + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, header := range headers {
+		if strings.ContainsAny(header[1], "\r\n") {
+			return nil, fmt.Errorf("invalid %s header", header[0])
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
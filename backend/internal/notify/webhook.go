@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig holds the settings for webhook delivery
+type WebhookConfig struct {
+	URL    string
+	Secret string
+}
+
+// Webhook hands notifications to another system, such as a messaging
+// bridge, by POSTing them as JSON. With a secret, the body is signed with
+// HMAC-SHA256 in the X-Signature-256 header ("sha256=<hex>") so the
+// receiver can check it came from this server. Failed deliveries are
+// retried with the same id, which receivers should use to drop duplicates.
+type Webhook struct {
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+// webhookPayload is the JSON body of a webhook delivery
+type webhookPayload struct {
+	ID        int64            `json:"id"`
+	EventID   int              `json:"eventId"`
+	Subject   string           `json:"subject"`
+	Message   string           `json:"message"`
+	Recipient webhookRecipient `json:"recipient"`
+	SentAt    time.Time        `json:"sentAt"`
+}
+
+type webhookRecipient struct {
+	UserID int    `json:"userId"`
+	Email  string `json:"email,omitempty"`
+	Phone  string `json:"phone,omitempty"`
+}
+
+// NewWebhook creates a webhook notifier
+func NewWebhook(cfg WebhookConfig, timeout time.Duration) (*Webhook, error) {
+	if err := checkURL(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	return &Webhook{cfg: cfg, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Channel implements Notifier
+func (h *Webhook) Channel() string {
+	return ChannelWebhook
+}
+
+// Send implements Notifier
+func (h *Webhook) Send(recipient Recipient, msg Message) error {
+	body, err := json.Marshal(webhookPayload{
+		ID:      msg.ID,
+		EventID: msg.EventID,
+		Subject: msg.Subject,
+		Message: msg.Body,
+		Recipient: webhookRecipient{
+			UserID: recipient.UserID,
+			Email:  recipient.Email,
+			Phone:  recipient.Phone,
+		},
+		SentAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	headers := http.Header{}
+	if h.cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.cfg.Secret))
+		mac.Write(body)
+		headers.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return post(h.client, h.cfg.URL, body, headers)
+}
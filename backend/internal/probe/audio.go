@@ -0,0 +1,263 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// oggTailSize is how much of the end of an Ogg file is searched for the
+// last page, whose granule position gives the length
+const oggTailSize = 64 << 10
+
+// seconds converts a sample count at rate into a duration
+func seconds(samples, rate uint64) *float64 {
+	if rate == 0 {
+		return nil
+	}
+	duration := float64(samples) / float64(rate)
+	return &duration
+}
+
+// fileSize returns the length of r, leaving it positioned at the start
+func fileSize(r io.ReadSeeker) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return size, err
+}
+
+// probeWAV reads the duration of a RIFF WAVE file from the byte rate in
+// its fmt chunk and the size of its data chunk
+func probeWAV(r io.ReadSeeker) (*Info, error) {
+	size, err := fileSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.Seek(12, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var byteRate uint32
+	offset := int64(12)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, errMalformed
+		}
+		offset += 8
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:]))
+
+		switch string(header[:4]) {
+		case "fmt ":
+			body := make([]byte, 12)
+			if chunkSize < 12 {
+				return nil, errMalformed
+			}
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, err
+			}
+			byteRate = binary.LittleEndian.Uint32(body[8:])
+		case "data":
+			if byteRate == 0 {
+				return nil, errMalformed
+			}
+			// Recorders that were cut off never fill in the data size
+			if chunkSize == 0 || chunkSize == 0xFFFFFFFF || offset+chunkSize > size {
+				chunkSize = size - offset
+			}
+			return &Info{Duration: seconds(uint64(chunkSize), uint64(byteRate))}, nil
+		}
+
+		// Chunks are padded to an even length
+		offset += chunkSize + chunkSize%2
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// probeFLAC reads the sample rate and total sample count from the
+// STREAMINFO block that always comes first in a FLAC file
+func probeFLAC(r io.ReadSeeker) (*Info, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	head := make([]byte, 8+34)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[4]&0x7F != 0 {
+		return nil, errMalformed
+	}
+
+	streamInfo := head[8:]
+	rate := uint64(streamInfo[10])<<12 | uint64(streamInfo[11])<<4 | uint64(streamInfo[12])>>4
+	samples := uint64(streamInfo[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(streamInfo[14:]))
+	if samples == 0 {
+		return &Info{}, nil // unknown length
+	}
+	return &Info{Duration: seconds(samples, rate)}, nil
+}
+
+// probeOgg reads the duration of an Opus or Vorbis stream from the granule
+// position of the last page, in samples at the rate named by the first
+func probeOgg(r io.ReadSeeker) (*Info, error) {
+	size, err := fileSize(r)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 128)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if len(head) < 28 {
+		return nil, errMalformed
+	}
+
+	// The first packet follows the page header and its segment table
+	packet := head[min(len(head), 27+int(head[26])):]
+	var rate, preSkip uint64
+	switch {
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 12:
+		// Opus granule positions always count 48 kHz samples
+		rate = 48000
+		preSkip = uint64(binary.LittleEndian.Uint16(packet[10:]))
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		rate = uint64(binary.LittleEndian.Uint32(packet[12:]))
+	default:
+		return nil, ErrUnsupported
+	}
+
+	start := max(size-oggTailSize, 0)
+	if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tail, err := io.ReadAll(io.LimitReader(r, oggTailSize))
+	if err != nil {
+		return nil, err
+	}
+	last := bytes.LastIndex(tail, []byte("OggS"))
+	if last < 0 || len(tail) < last+14 {
+		return nil, errMalformed
+	}
+	granule := binary.LittleEndian.Uint64(tail[last+6:])
+	if granule < preSkip {
+		return &Info{}, nil
+	}
+	return &Info{Duration: seconds(granule-preSkip, rate)}, nil
+}
+
+// MPEG audio tables for Layer III, indexed by the frame header fields
+var (
+	mp3Bitrates = map[bool][]uint64{
+		true:  {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		false: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mp3SampleRates = map[byte][]uint64{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// probeMP3 reads the duration of an MP3 file. Variable bitrate files carry
+// a frame count in a Xing, Info or VBRI header in their first frame;
+// without one the file is constant bitrate and the length follows from
+// the bitrate and size.
+func probeMP3(r io.ReadSeeker) (*Info, error) {
+	size, err := fileSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the ID3v2 tag, whose size is stored in 7-bit bytes
+	header := make([]byte, 10)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	start := int64(0)
+	if string(header[:3]) == "ID3" {
+		tagSize := int64(header[6]&0x7F)<<21 | int64(header[7]&0x7F)<<14 |
+			int64(header[8]&0x7F)<<7 | int64(header[9]&0x7F)
+		start = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	}
+	if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 4096)
+	n, err := io.ReadFull(r, frame)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	frame = frame[:n]
+
+	// Some encoders pad between the tag and the first frame
+	sync := -1
+	for i := 0; i+4 <= len(frame); i++ {
+		if frame[i] == 0xFF && frame[i+1]&0xE0 == 0xE0 {
+			sync = i
+			break
+		}
+	}
+	if sync < 0 {
+		return nil, errMalformed
+	}
+	start += int64(sync)
+	frame = frame[sync:]
+
+	version := (frame[1] >> 3) & 0x03
+	layer := (frame[1] >> 1) & 0x03
+	bitrateIndex := frame[2] >> 4
+	rateIndex := (frame[2] >> 2) & 0x03
+	rates, ok := mp3SampleRates[version]
+	if !ok || layer != 1 || rateIndex == 3 || bitrateIndex == 0 || bitrateIndex == 15 {
+		return nil, ErrUnsupported
+	}
+	mpeg1 := version == 3
+	rate := rates[rateIndex]
+	mono := frame[3]>>6 == 3
+
+	samplesPerFrame := uint64(576)
+	sideInfo := 17
+	if mpeg1 {
+		samplesPerFrame = 1152
+		if !mono {
+			sideInfo = 32
+		}
+	} else if mono {
+		sideInfo = 9
+	}
+
+	if xing := 4 + sideInfo; len(frame) >= xing+12 {
+		tag := string(frame[xing : xing+4])
+		flags := binary.BigEndian.Uint32(frame[xing+4:])
+		if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+			frames := uint64(binary.BigEndian.Uint32(frame[xing+8:]))
+			return &Info{Duration: seconds(frames*samplesPerFrame, rate)}, nil
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		frames := uint64(binary.BigEndian.Uint32(frame[36+14:]))
+		return &Info{Duration: seconds(frames*samplesPerFrame, rate)}, nil
+	}
+
+	audioBytes := size - start
+	if size >= 128 {
+		if _, err = r.Seek(size-128, io.SeekStart); err == nil {
+			tag := make([]byte, 3)
+			if _, err = io.ReadFull(r, tag); err == nil && string(tag) == "TAG" {
+				audioBytes -= 128 // ID3v1
+			}
+		}
+	}
+	bitrate := mp3Bitrates[mpeg1][bitrateIndex] * 1000
+	return &Info{Duration: seconds(uint64(audioBytes)*8, bitrate)}, nil
+}
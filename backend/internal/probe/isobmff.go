@@ -0,0 +1,192 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// maxIndexBoxSize bounds the moov or meta box read into memory
+const maxIndexBoxSize = 64 << 20
+
+var errMalformed = errors.New("malformed container")
+
+// mp4Epoch is the origin of ISO base media file timestamps
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type box struct {
+	typ  string
+	body []byte
+}
+
+// readTopLevelBox seeks through the top-level boxes of r and returns the
+// payload of the first box of type typ
+func readTopLevelBox(r io.ReadSeeker, typ string) ([]byte, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errMalformed
+			}
+			return nil, err
+		}
+		size := uint64(binary.BigEndian.Uint32(header))
+		boxType := string(header[4:8])
+		headerLen := uint64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return nil, errMalformed
+			}
+			size = binary.BigEndian.Uint64(header[8:])
+			headerLen = 16
+		}
+
+		if boxType == typ {
+			if size == 0 || size < headerLen || size-headerLen > maxIndexBoxSize {
+				return nil, errMalformed
+			}
+			body := make([]byte, size-headerLen)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, errMalformed
+			}
+			return body, nil
+		}
+
+		// A zero size means the box runs to the end of the file
+		if size == 0 || size < headerLen {
+			return nil, errMalformed
+		}
+		if _, err := r.Seek(int64(size-headerLen), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// children splits a box payload into the boxes it contains
+func children(data []byte) []box {
+	var boxes []box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerLen = 16
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return boxes
+		}
+		boxes = append(boxes, box{typ: string(data[4:8]), body: data[headerLen:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+func child(data []byte, typ string) []byte {
+	for _, b := range children(data) {
+		if b.typ == typ {
+			return b.body
+		}
+	}
+	return nil
+}
+
+// probeMP4 reads the duration and creation time from moov/mvhd and the
+// dimensions of the first video track from its tkhd
+func probeMP4(r io.ReadSeeker) (*Info, error) {
+	moov, err := readTopLevelBox(r, "moov")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{}
+	if mvhd := child(moov, "mvhd"); len(mvhd) >= 20 {
+		var created, timescale, duration uint64
+		if mvhd[0] == 1 {
+			if len(mvhd) < 32 {
+				return nil, errMalformed
+			}
+			created = binary.BigEndian.Uint64(mvhd[4:])
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+			duration = binary.BigEndian.Uint64(mvhd[24:])
+		} else {
+			created = uint64(binary.BigEndian.Uint32(mvhd[4:]))
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+		}
+		if timescale > 0 {
+			seconds := float64(duration) / float64(timescale)
+			info.Duration = &seconds
+		}
+		// Many encoders leave the creation time unset
+		if created > 0 {
+			t := mp4Epoch.Add(time.Duration(created) * time.Second)
+			info.CreatedAt = &t
+		}
+	}
+
+	for _, trak := range children(moov) {
+		if trak.typ != "trak" {
+			continue
+		}
+		tkhd := child(trak.body, "tkhd")
+		if len(tkhd) < 84 {
+			continue
+		}
+		// Width and height are 16.16 fixed point at the end of the box,
+		// after the 3x3 transformation matrix
+		width := int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+		height := int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+		if width == 0 || height == 0 {
+			continue // audio track
+		}
+		// Phones record portrait video as landscape plus a 90 degree
+		// rotation; report the dimensions as displayed
+		matrix := tkhd[len(tkhd)-44:]
+		if binary.BigEndian.Uint32(matrix[0:]) == 0 && binary.BigEndian.Uint32(matrix[4:]) != 0 {
+			width, height = height, width
+		}
+		info.Width, info.Height = width, height
+		break
+	}
+
+	return info, nil
+}
+
+// probeHEIF reads the image size from the ispe properties in meta/iprp.
+// The largest one belongs to the primary image; the others describe tiles
+// and thumbnails.
+func probeHEIF(r io.ReadSeeker) (*Info, error) {
+	meta, err := readTopLevelBox(r, "meta")
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) < 4 {
+		return nil, errMalformed
+	}
+
+	// meta is a full box: skip version and flags
+	ipco := child(child(meta[4:], "iprp"), "ipco")
+	info := &Info{}
+	for _, property := range children(ipco) {
+		if property.typ != "ispe" || len(property.body) < 12 {
+			continue
+		}
+		width := int(binary.BigEndian.Uint32(property.body[4:]))
+		height := int(binary.BigEndian.Uint32(property.body[8:]))
+		if width*height > info.Width*info.Height {
+			info.Width, info.Height = width, height
+		}
+	}
+	return info, nil
+}
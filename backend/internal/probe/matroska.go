@@ -0,0 +1,182 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// matroskaHeadSize is how much of a WebM or Matroska file is read. The
+// Info and Tracks elements come before the first cluster in practice.
+const matroskaHeadSize = 1 << 20
+
+// Matroska element IDs, with their length marker bits
+const (
+	idSegment       = 0x18538067
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idDateUTC       = 0x4461
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+)
+
+// matroskaEpoch is the origin of Matroska DateUTC values
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type element struct {
+	id   uint64
+	data []byte
+}
+
+// probeMatroska reads the duration, creation date and the first video
+// track's dimensions from the start of a WebM or Matroska file
+func probeMatroska(r io.ReadSeeker) (*Info, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	head := make([]byte, matroskaHeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	var segment []byte
+	for _, e := range elements(head) {
+		if e.id == idSegment {
+			segment = e.data
+		}
+	}
+	if segment == nil {
+		return nil, errMalformed
+	}
+
+	info := &Info{}
+	for _, e := range elements(segment) {
+		switch e.id {
+		case idInfo:
+			readMatroskaInfo(e.data, info)
+		case idTracks:
+			readMatroskaTracks(e.data, info)
+		}
+	}
+	return info, nil
+}
+
+func readMatroskaInfo(data []byte, info *Info) {
+	scale := uint64(1000000) // nanoseconds per timecode tick
+	var duration float64
+	for _, e := range elements(data) {
+		switch e.id {
+		case idTimecodeScale:
+			scale = readUint(e.data)
+		case idDuration:
+			duration = readFloat(e.data)
+		case idDateUTC:
+			if len(e.data) == 8 {
+				t := matroskaEpoch.Add(time.Duration(int64(binary.BigEndian.Uint64(e.data))))
+				info.CreatedAt = &t
+			}
+		}
+	}
+	if duration > 0 {
+		seconds := duration * float64(scale) / 1e9
+		info.Duration = &seconds
+	}
+}
+
+func readMatroskaTracks(data []byte, info *Info) {
+	for _, track := range elements(data) {
+		if track.id != idTrackEntry {
+			continue
+		}
+		for _, e := range elements(track.data) {
+			if e.id != idVideo {
+				continue
+			}
+			for _, v := range elements(e.data) {
+				switch v.id {
+				case idPixelWidth:
+					info.Width = int(readUint(v.data))
+				case idPixelHeight:
+					info.Height = int(readUint(v.data))
+				}
+			}
+			return
+		}
+	}
+}
+
+// elements splits EBML data into its child elements. An element of unknown
+// or overlong size, as written by live encoders, runs to the end of data.
+func elements(data []byte) []element {
+	var out []element
+	for len(data) > 0 {
+		id, idLen := readVint(data, true)
+		if idLen == 0 {
+			return out
+		}
+		size, sizeLen := readVint(data[idLen:], false)
+		if sizeLen == 0 {
+			return out
+		}
+		start := idLen + sizeLen
+		end := len(data)
+		if size < uint64(len(data)-start) {
+			end = start + int(size)
+		}
+		out = append(out, element{id: id, data: data[start:end]})
+		data = data[end:]
+	}
+	return out
+}
+
+// readVint decodes an EBML variable-length integer. IDs keep their length
+// marker bit; sizes do not, and an all-ones size means unknown.
+func readVint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || len(data) < length {
+		return 0, 0
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	if !keepMarker && value == 1<<(7*length)-1 {
+		value = math.MaxUint64
+	}
+	return value, length
+}
+
+func readUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func readFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"time"
+
+	"github.com/protest-tracker/internal/metadata"
+)
+
+// ErrUnsupported is returned for formats whose properties cannot be read
+var ErrUnsupported = errors.New("cannot probe this format")
+
+// Info holds the properties read from a photo, video or audio file. Fields
+// the format does not record are left zero or nil.
+type Info struct {
+	Width  int
+	Height int
+	// Duration of a video or recording in seconds
+	Duration *float64
+	// CreatedAt is the creation time recorded by the camera in a video
+	// container; photos carry theirs in EXIF instead
+	CreatedAt *time.Time
+}
+
+// Probe reads the dimensions, and for videos and audio the duration and
+// creation time, of a file of the given detected MIME type. Only the
+// headers and index are read, not the whole file.
+func Probe(r io.ReadSeeker, mimeType string) (*Info, error) {
+	switch mimeType {
+	case "image/jpeg", "image/png":
+		var header bytes.Buffer
+		config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+		if err != nil {
+			return nil, err
+		}
+		// Report the size the photo is shown at
+		if metadata.JPEGOrientation(header.Bytes()) >= 5 {
+			config.Width, config.Height = config.Height, config.Width
+		}
+		return &Info{Width: config.Width, Height: config.Height}, nil
+	case "image/heic", "image/heif":
+		return probeHEIF(r)
+	case "video/mp4", "video/quicktime", "video/3gpp":
+		return probeMP4(r)
+	case "video/webm", "video/x-matroska", "audio/webm":
+		return probeMatroska(r)
+	case "audio/mp4", "audio/3gpp":
+		return probeMP4(r)
+	case "audio/mpeg":
+		return probeMP3(r)
+	case "audio/wav":
+		return probeWAV(r)
+	case "audio/flac":
+		return probeFLAC(r)
+	case "audio/ogg":
+		return probeOgg(r)
+	}
+	return nil, ErrUnsupported
+}
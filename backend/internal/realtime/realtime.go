@@ -0,0 +1,196 @@
+// Package realtime tells connected clients about changes as they happen:
+// events reported, edited, held or deleted, new media, and new witness
+// subscriptions and statements. Messages are passed through Postgres LISTEN/NOTIFY, so
+// clients connected to any server instance hear of changes made on all of
+// them, including those made by background jobs.
+package realtime
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Message types
+const (
+	EventCreated        = "event.created"
+	EventUpdated        = "event.updated"
+	EventStatusChanged  = "event.status_changed"
+	MediaCreated        = "media.created"
+	SubscriptionCreated = "subscription.created"
+	StatementCreated    = "statement.created"
+
+	// Resync is sent after the feed may have missed messages, such as
+	// when the database connection dropped. Clients should reload what
+	// they show.
+	Resync = "resync"
+)
+
+const (
+	// channel is the Postgres notification channel messages go through
+	channel = "realtime_feed"
+
+	// maxPayload keeps notifications under Postgres' 8000 byte limit.
+	// Larger messages are sent without their data, which clients fetch.
+	maxPayload = 7500
+
+	// clientBuffer is how many messages a client may fall behind by
+	// before it is disconnected
+	clientBuffer = 64
+)
+
+// Message is a change clients are told about
+type Message struct {
+	Type    string          `json:"type"`
+	EventID int             `json:"eventId,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Time    time.Time       `json:"time"`
+}
+
+// notification is a message as sent through Postgres, with who may see it
+type notification struct {
+	Message
+	AdvocatesOnly bool `json:"advocatesOnly"`
+}
+
+// Client is a connection following the feed
+type Client struct {
+	advocate bool
+	messages chan Message
+}
+
+// Messages delivers the messages the client may see. It is closed when
+// the client falls too far behind or unsubscribes.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Hub fans messages out to clients
+type Hub struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+}
+
+// NewHub creates a hub that publishes through db, whose clients receive
+// messages once Listen is called. With a nil db, messages only go to the
+// hub's own clients.
+func NewHub(db *sql.DB) *Hub {
+	return &Hub{db: db, clients: make(map[*Client]struct{})}
+}
+
+// Listen connects to the database at databaseURL to receive the messages
+// published by every server instance, reconnecting when the connection
+// drops. It returns once listening has started.
+func (h *Hub) Listen(databaseURL string) error {
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Realtime feed listener: %v", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				if n == nil {
+					// Reconnected; anything sent meanwhile was lost
+					h.deliver(Message{Type: Resync, Time: time.Now().UTC()}, false)
+					continue
+				}
+				var msg notification
+				if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+					log.Printf("Invalid realtime notification: %v", err)
+					continue
+				}
+				h.deliver(msg.Message, msg.AdvocatesOnly)
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
+
+// Publish sends a message to every client allowed to see it. data, if not
+// nil, is sent as JSON. Publishing on a nil hub does nothing.
+func (h *Hub) Publish(msgType string, eventID int, data interface{}, advocatesOnly bool) {
+	if h == nil {
+		return
+	}
+	msg := Message{Type: msgType, EventID: eventID, Time: time.Now().UTC()}
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("Failed to encode %s message: %v", msgType, err)
+			return
+		}
+		msg.Data = encoded
+	}
+
+	payload, err := json.Marshal(notification{Message: msg, AdvocatesOnly: advocatesOnly})
+	if err == nil && len(payload) > maxPayload {
+		msg.Data = nil
+		payload, err = json.Marshal(notification{Message: msg, AdvocatesOnly: advocatesOnly})
+	}
+	if err == nil && h.db == nil {
+		h.deliver(msg, advocatesOnly)
+		return
+	}
+	if err == nil {
+		_, err = h.db.Exec("SELECT pg_notify($1, $2)", channel, string(payload))
+	}
+	if err != nil {
+		// Other instances miss out, but this one's clients are still told
+		log.Printf("Failed to publish %s message: %v", msgType, err)
+		h.deliver(msg, advocatesOnly)
+	}
+}
+
+// Subscribe adds a client. Advocates receive every message; everyone else
+// only those about events themselves.
+func (h *Hub) Subscribe(role string) *Client {
+	client := &Client{advocate: role == "advocate", messages: make(chan Message, clientBuffer)}
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+	return client
+}
+
+// Unsubscribe removes a client and closes its messages
+func (h *Hub) Unsubscribe(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.messages)
+	}
+}
+
+// deliver passes msg to the local clients allowed to see it. Clients that
+// have fallen behind are dropped rather than let them hold up the others;
+// they reconnect and reload.
+func (h *Hub) deliver(msg Message, advocatesOnly bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		if advocatesOnly && !client.advocate {
+			continue
+		}
+		select {
+		case client.messages <- msg:
+		default:
+			delete(h.clients, client)
+			close(client.messages)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+// BlobTx is a transaction holding the lock on one content hash. Storing
+// and releasing the same content is serialized through it across every
+// server instance, so that two uploads cannot both write the file and a
+// file is not deleted while another upload takes a reference to it.
+type BlobTx struct {
+	tx   *sql.Tx
+	hash string
+}
+
+// LockBlob begins a transaction and waits for the lock on content with the
+// given hash. The lock is released when the transaction ends.
+func (r *MediaRepository) LockBlob(hash string) (*BlobTx, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", blobLockKey(hash)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &BlobTx{tx: tx, hash: hash}, nil
+}
+
+// blobLockKey derives the advisory lock key of a content hash from its
+// leading bytes
+func blobLockKey(hash string) int64 {
+	var key uint64
+	if prefix, err := hex.DecodeString(hash[:min(len(hash), 16)]); err == nil {
+		for _, b := range prefix {
+			key = key<<8 | uint64(b)
+		}
+	}
+	return int64(key)
+}
+
+// Get retrieves the stored file for the locked content
+func (t *BlobTx) Get() (*models.Blob, error) {
+	var blob models.Blob
+	var keyID, wrappedKey sql.NullString
+
+	err := t.tx.QueryRow(`
+		SELECT sha256, file_path, size_bytes, key_id, wrapped_key, ref_count
+		FROM blobs
+		WHERE sha256 = $1
+	`, t.hash).Scan(&blob.SHA256, &blob.FilePath, &blob.Size, &keyID, &wrappedKey, &blob.RefCount)
+	if err != nil {
+		return nil, err
+	}
+
+	blob.KeyID = keyID.String
+	blob.WrappedKey = wrappedKey.String
+
+	return &blob, nil
+}
+
+// Create records a newly stored file with a single reference
+func (t *BlobTx) Create(blob *models.Blob) error {
+	_, err := t.tx.Exec(`
+		INSERT INTO blobs (sha256, file_path, size_bytes, key_id, wrapped_key, ref_count)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), 1)
+	`, t.hash, blob.FilePath, blob.Size, blob.KeyID, blob.WrappedKey)
+	blob.RefCount = 1
+	return err
+}
+
+// AddRef records another media record using the stored file
+func (t *BlobTx) AddRef() error {
+	_, err := t.tx.Exec("UPDATE blobs SET ref_count = ref_count + 1 WHERE sha256 = $1", t.hash)
+	return err
+}
+
+// Release drops one reference to the stored file and returns how many
+// remain. The blob record is removed along with its last reference.
+func (t *BlobTx) Release() (int, error) {
+	var remaining int
+	err := t.tx.QueryRow(`
+		UPDATE blobs SET ref_count = ref_count - 1 WHERE sha256 = $1 RETURNING ref_count
+	`, t.hash).Scan(&remaining)
+	if err != nil {
+		return 0, err
+	}
+	if remaining <= 0 {
+		if _, err = t.tx.Exec("DELETE FROM blobs WHERE sha256 = $1", t.hash); err != nil {
+			return 0, err
+		}
+	}
+	return remaining, nil
+}
+
+// Commit saves the changes and releases the lock
+func (t *BlobTx) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback discards the changes and releases the lock. It does nothing
+// after Commit.
+func (t *BlobTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// GetBlobsWithStaleKeys retrieves encrypted blobs whose data key is not
+// sealed by the given master key
+func (r *MediaRepository) GetBlobsWithStaleKeys(currentKeyID string) ([]models.Blob, error) {
+	rows, err := r.db.Query(`
+		SELECT sha256, key_id, wrapped_key
+		FROM blobs
+		WHERE key_id IS NOT NULL AND key_id <> $1
+		ORDER BY sha256
+	`, currentKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []models.Blob
+	for rows.Next() {
+		var blob models.Blob
+		if err := rows.Scan(&blob.SHA256, &blob.KeyID, &blob.WrappedKey); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, rows.Err()
+}
+
+// UpdateBlobKey replaces the sealed data key of a blob
+func (r *MediaRepository) UpdateBlobKey(hash, keyID, wrappedKey string) error {
+	_, err := r.db.Exec(`
+		UPDATE blobs SET key_id = $1, wrapped_key = $2 WHERE sha256 = $3
+	`, keyID, wrappedKey, hash)
+	return err
+}
+
+// RepointFile moves every media and blob record using oldRef to newRef
+func (r *MediaRepository) RepointFile(oldRef, newRef string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("UPDATE media SET file_path = $1 WHERE file_path = $2", newRef, oldRef); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE blobs SET file_path = $1 WHERE file_path = $2", newRef, oldRef); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// FindDuplicate retrieves a live upload to an event with the given content
+// hash. Redacted copies are not uploads and are never duplicates.
+func (r *MediaRepository) FindDuplicate(eventID int, hash string) (*models.Media, error) {
+	return scanMedia(r.db.QueryRow(`
+		SELECT `+mediaColumns+`
+		FROM media
+		WHERE event_id = $1 AND sha256 = $2 AND deleted_at IS NULL AND parent_id IS NULL
+		ORDER BY id
+		LIMIT 1
+	`, eventID, hash))
+}
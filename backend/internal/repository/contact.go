@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+type ContactRepository struct {
+	db *sql.DB
+}
+
+func NewContactRepository(db *sql.DB) *ContactRepository {
+	return &ContactRepository{db: db}
+}
+
+// contactCounts aggregates the outbox rows of each contact request
+const contactCounts = `
+	COUNT(DISTINCT o.user_id),
+	COUNT(*) FILTER (WHERE o.status = 'queued'),
+	COUNT(*) FILTER (WHERE o.status = 'sent'),
+	COUNT(*) FILTER (WHERE o.status = 'failed'),
+	COUNT(*) FILTER (WHERE o.status = 'bounced')`
+
+func scanContactRequest(row rowScanner) (*models.ContactRequest, error) {
+	var req models.ContactRequest
+	var sentBy sql.NullInt64
+	err := row.Scan(&req.ID, &req.EventID, &sentBy, &req.Subject, &req.Message, &req.CreatedAt,
+		&req.Recipients, &req.Queued, &req.Sent, &req.Failed, &req.Bounced)
+	if err != nil {
+		return nil, err
+	}
+	req.SentBy = int(sentBy.Int64)
+	return &req, nil
+}
+
+// Create stores a contact request together with its deliveries, so that
+// no message is lost between the two. It fills in their IDs.
+func (r *ContactRepository) Create(req *models.ContactRequest, deliveries []models.Delivery) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO contact_requests (event_id, sent_by, subject, message, created_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+		RETURNING id
+	`, req.EventID, req.SentBy, req.Subject, req.Message, req.CreatedAt).Scan(&req.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range deliveries {
+		d := &deliveries[i]
+		err = tx.QueryRow(`
+			INSERT INTO notification_outbox (contact_request_id, user_id, channel, status,
+				next_attempt_at, last_error, created_at, updated_at)
+			VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''), $5, $5)
+			RETURNING id
+		`, req.ID, d.UserID, d.Channel, d.Status, req.CreatedAt, d.LastError).Scan(&d.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetByEventID retrieves the contact requests sent for an event with their
+// delivery counts, newest first
+func (r *ContactRepository) GetByEventID(eventID int) ([]models.ContactRequest, error) {
+	rows, err := r.db.Query(`
+		SELECT c.id, c.event_id, c.sent_by, c.subject, c.message, c.created_at,`+contactCounts+`
+		FROM contact_requests c
+		LEFT JOIN notification_outbox o ON o.contact_request_id = c.id
+		WHERE c.event_id = $1
+		GROUP BY c.id
+		ORDER BY c.id DESC
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.ContactRequest
+	for rows.Next() {
+		req, err := scanContactRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+	return requests, rows.Err()
+}
+
+// GetByID retrieves a contact request with the status of every delivery
+func (r *ContactRepository) GetByID(id int) (*models.ContactRequest, error) {
+	req, err := scanContactRequest(r.db.QueryRow(`
+		SELECT c.id, c.event_id, c.sent_by, c.subject, c.message, c.created_at,`+contactCounts+`
+		FROM contact_requests c
+		LEFT JOIN notification_outbox o ON o.contact_request_id = c.id
+		WHERE c.id = $1
+		GROUP BY c.id
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, user_id, channel, status, attempts, next_attempt_at, last_error, updated_at, sent_at
+		FROM notification_outbox
+		WHERE contact_request_id = $1
+		ORDER BY user_id, id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	req.Deliveries = []models.Delivery{}
+	for rows.Next() {
+		var d models.Delivery
+		var channel, lastError sql.NullString
+		var nextAttemptAt time.Time
+		var sentAt sql.NullTime
+		err := rows.Scan(&d.ID, &d.UserID, &channel, &d.Status, &d.Attempts, &nextAttemptAt,
+			&lastError, &d.UpdatedAt, &sentAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Channel = channel.String
+		d.LastError = lastError.String
+		if d.Status == models.DeliveryQueued {
+			d.NextAttemptAt = &nextAttemptAt
+		}
+		if sentAt.Valid {
+			d.SentAt = &sentAt.Time
+		}
+		req.Deliveries = append(req.Deliveries, d)
+	}
+	return req, rows.Err()
+}
+
+// Claim locks the next queued delivery that is due, counting an attempt,
+// and returns it with what is needed to send it. Deliveries locked before
+// staleBefore are claimed again; their dispatcher is assumed to have died.
+// It returns sql.ErrNoRows when nothing is due.
+func (r *ContactRepository) Claim(now, staleBefore time.Time) (*models.OutboxMessage, error) {
+	var msg models.OutboxMessage
+	var phone sql.NullString
+	err := r.db.QueryRow(`
+		WITH claimed AS (
+			UPDATE notification_outbox
+			SET attempts = attempts + 1, locked_at = $1, updated_at = $1
+			WHERE id = (
+				SELECT id FROM notification_outbox
+				WHERE status = $2 AND next_attempt_at <= $1
+					AND (locked_at IS NULL OR locked_at < $3)
+				ORDER BY next_attempt_at, id
+				FOR UPDATE SKIP LOCKED
+				LIMIT 1
+			)
+			RETURNING id, contact_request_id, user_id, channel, attempts
+		)
+		SELECT o.id, c.event_id, o.user_id, o.channel, o.attempts, u.email, u.phone_number,
+			c.subject, c.message
+		FROM claimed o
+		JOIN contact_requests c ON c.id = o.contact_request_id
+		JOIN users u ON u.id = o.user_id
+	`, now, models.DeliveryQueued, staleBefore).Scan(&msg.ID, &msg.EventID, &msg.UserID, &msg.Channel,
+		&msg.Attempts, &msg.Email, &phone, &msg.Subject, &msg.Message)
+	if err != nil {
+		return nil, err
+	}
+	msg.Phone = phone.String
+	return &msg, nil
+}
+
+// MarkSent records that a delivery was accepted for sending
+func (r *ContactRepository) MarkSent(id int64, now time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE notification_outbox
+		SET status = $1, locked_at = NULL, last_error = NULL, updated_at = $2, sent_at = $2
+		WHERE id = $3
+	`, models.DeliverySent, now, id)
+	return err
+}
+
+// Retry releases a failed delivery to be tried again at nextAttemptAt
+func (r *ContactRepository) Retry(id int64, nextAttemptAt, now time.Time, lastError string) error {
+	_, err := r.db.Exec(`
+		UPDATE notification_outbox
+		SET locked_at = NULL, next_attempt_at = $1, last_error = $2, updated_at = $3
+		WHERE id = $4
+	`, nextAttemptAt, lastError, now, id)
+	return err
+}
+
+// Finish gives up on a delivery, marking it failed or bounced
+func (r *ContactRepository) Finish(id int64, status string, now time.Time, lastError string) error {
+	_, err := r.db.Exec(`
+		UPDATE notification_outbox
+		SET status = $1, locked_at = NULL, last_error = $2, updated_at = $3
+		WHERE id = $4
+	`, status, lastError, now, id)
+	return err
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+// SaveCredentials stores what a media item's content credentials say and
+// sets its credentials status
+func (r *MediaRepository) SaveCredentials(credentials *models.ContentCredentials) error {
+	details, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO media_credentials (media_id, status, details, inspected_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (media_id) DO UPDATE
+		SET status = $2, details = $3, inspected_at = $4
+	`, credentials.MediaID, credentials.Status, string(details), credentials.InspectedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE media SET credentials_status = $1 WHERE id = $2", credentials.Status, credentials.MediaID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetCredentials retrieves what a media item's content credentials say. It
+// returns sql.ErrNoRows if the media has none.
+func (r *MediaRepository) GetCredentials(mediaID int) (*models.ContentCredentials, error) {
+	var details string
+	err := r.db.QueryRow("SELECT details FROM media_credentials WHERE media_id = $1", mediaID).Scan(&details)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials models.ContentCredentials
+	if err = json.Unmarshal([]byte(details), &credentials); err != nil {
+		return nil, fmt.Errorf("media %d: invalid credentials: %v", mediaID, err)
+	}
+	return &credentials, nil
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+func custodyEntry() models.CustodyEntry {
+	return models.CustodyEntry{
+		MediaID:   7,
+		EventID:   3,
+		UserID:    12,
+		Action:    models.CustodyDownload,
+		Details:   "share link 4",
+		PrevHash:  "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		CreatedAt: time.Date(2024, 5, 1, 18, 4, 40, 123456000, time.UTC),
+	}
+}
+
+// Changing any field that is hashed must change the entry hash
+func TestCustodyEntryHashCoversEveryField(t *testing.T) {
+	base := custodyEntry()
+	baseHash := CustodyEntryHash(&base)
+
+	tests := []struct {
+		name   string
+		change func(*models.CustodyEntry)
+	}{
+		{"prev hash", func(e *models.CustodyEntry) { e.PrevHash = "" }},
+		{"media", func(e *models.CustodyEntry) { e.MediaID = 8 }},
+		{"event", func(e *models.CustodyEntry) { e.EventID = 4 }},
+		{"user", func(e *models.CustodyEntry) { e.UserID = 0 }},
+		{"action", func(e *models.CustodyEntry) { e.Action = models.CustodyAccess }},
+		{"details", func(e *models.CustodyEntry) { e.Details = "share link 5" }},
+		{"time", func(e *models.CustodyEntry) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := custodyEntry()
+			tt.change(&entry)
+			if CustodyEntryHash(&entry) == baseHash {
+				t.Errorf("hash did not change")
+			}
+		})
+	}
+}
+
+// The ID and stored hash are not part of the hash, and the time zone the
+// entry was read back in does not matter
+func TestCustodyEntryHashStable(t *testing.T) {
+	base := custodyEntry()
+	baseHash := CustodyEntryHash(&base)
+
+	entry := custodyEntry()
+	entry.ID = 99
+	entry.EntryHash = "stale"
+	entry.CreatedAt = entry.CreatedAt.In(time.FixedZone("UTC-5", -5*60*60))
+
+	if got := CustodyEntryHash(&entry); got != baseHash {
+		t.Errorf("hash = %s, want %s", got, baseHash)
+	}
+	if len(baseHash) != 64 {
+		t.Errorf("hash has %d hex digits, want 64", len(baseHash))
+	}
+}
+
+// Editing an entry after the fact breaks the link from the entry after it
+func TestCustodyChainDetectsEdits(t *testing.T) {
+	actions := []string{models.CustodyUpload, models.CustodyAccess, models.CustodyDownload, models.CustodyExport}
+
+	var chain []models.CustodyEntry
+	prev := ""
+	for i, action := range actions {
+		entry := custodyEntry()
+		entry.Action = action
+		entry.PrevHash = prev
+		entry.CreatedAt = entry.CreatedAt.Add(time.Duration(i) * time.Minute)
+		entry.EntryHash = CustodyEntryHash(&entry)
+		chain = append(chain, entry)
+		prev = entry.EntryHash
+	}
+
+	if i := brokenLink(chain); i != -1 {
+		t.Fatalf("intact chain broken at entry %d", i)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func([]models.CustodyEntry) []models.CustodyEntry
+		want   int
+	}{
+		{"edited details", func(c []models.CustodyEntry) []models.CustodyEntry {
+			c[1].Details = "nothing to see"
+			return c
+		}, 1},
+		{"removed entry", func(c []models.CustodyEntry) []models.CustodyEntry {
+			return append(c[:2:2], c[3:]...)
+		}, 2},
+		{"reordered entries", func(c []models.CustodyEntry) []models.CustodyEntry {
+			c[1], c[2] = c[2], c[1]
+			return c
+		}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(append([]models.CustodyEntry(nil), chain...))
+			if got := brokenLink(tampered); got != tt.want {
+				t.Errorf("chain broken at entry %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// brokenLink returns the index of the first entry whose stored hashes do
+// not match the chain, or -1
+func brokenLink(chain []models.CustodyEntry) int {
+	prev := ""
+	for i := range chain {
+		if chain[i].PrevHash != prev || CustodyEntryHash(&chain[i]) != chain[i].EntryHash {
+			return i
+		}
+		prev = chain[i].EntryHash
+	}
+	return -1
+}
@@ -17,7 +17,7 @@ func NewEventRepository(db *sql.DB) *EventRepository {
 // GetAll retrieves all arrest events
 func (r *EventRepository) GetAll() ([]models.ArrestEvent, error) {
 	rows, err := r.db.Query(`
-		SELECT id, time, latitude, longitude, notes, created_by 
+		SELECT id, time, latitude, longitude, notes, created_by, legal_hold, timestamped_at
 		FROM arrest_events 
 		ORDER BY time DESC
 	`)
@@ -30,14 +30,18 @@ func (r *EventRepository) GetAll() ([]models.ArrestEvent, error) {
 	for rows.Next() {
 		var event models.ArrestEvent
 		var notes sql.NullString
+		var timestampedAt sql.NullTime
 
 		err := rows.Scan(&event.ID, &event.Time, &event.Latitude, &event.Longitude,
-			&notes, &event.CreatedBy)
+			&notes, &event.CreatedBy, &event.LegalHold, &timestampedAt)
 		if err != nil {
 			return nil, err
 		}
 
 		event.Notes = notes.String
+		if timestampedAt.Valid {
+			event.TimestampedAt = &timestampedAt.Time
+		}
 		events = append(events, event)
 	}
 
@@ -48,19 +52,23 @@ func (r *EventRepository) GetAll() ([]models.ArrestEvent, error) {
 func (r *EventRepository) GetByID(id int) (*models.ArrestEvent, error) {
 	var event models.ArrestEvent
 	var notes sql.NullString
+	var timestampedAt sql.NullTime
 
 	err := r.db.QueryRow(`
-		SELECT id, time, latitude, longitude, notes, created_by 
+		SELECT id, time, latitude, longitude, notes, created_by, legal_hold, timestamped_at
 		FROM arrest_events 
 		WHERE id = $1
 	`, id).Scan(&event.ID, &event.Time, &event.Latitude, &event.Longitude,
-		&notes, &event.CreatedBy)
+		&notes, &event.CreatedBy, &event.LegalHold, &timestampedAt)
 
 	if err != nil {
 		return nil, err
 	}
 
 	event.Notes = notes.String
+	if timestampedAt.Valid {
+		event.TimestampedAt = &timestampedAt.Time
+	}
 
 	return &event, nil
 }
@@ -88,8 +96,41 @@ func (r *EventRepository) Update(event *models.ArrestEvent) error {
 	return err
 }
 
-// Delete deletes an event
-func (r *EventRepository) Delete(id int) error {
-	_, err := r.db.Exec("DELETE FROM arrest_events WHERE id = $1", id)
+// Delete deletes an event that is not under legal hold and has no media
+// left, not even deleted media awaiting purge. It reports whether the
+// event was deleted.
+func (r *EventRepository) Delete(id int) (bool, error) {
+	result, err := r.db.Exec(`
+		DELETE FROM arrest_events
+		WHERE id = $1 AND NOT legal_hold
+			AND NOT EXISTS (SELECT 1 FROM media WHERE event_id = $1)
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SetLegalHold places or releases a legal hold on an event
+func (r *EventRepository) SetLegalHold(id int, hold bool) error {
+	_, err := r.db.Exec("UPDATE arrest_events SET legal_hold = $1 WHERE id = $2", hold, id)
 	return err
 }
+
+// HasMedia reports whether an event has any media, including deleted media
+// not yet purged
+func (r *EventRepository) HasMedia(id int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM media WHERE event_id = $1)", id).Scan(&exists)
+	return exists, err
+}
+
+// HasMediaOnHold reports whether any media of an event is under legal hold
+func (r *EventRepository) HasMediaOnHold(id int) (bool, error) {
+	var held bool
+	err := r.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM media WHERE event_id = $1 AND legal_hold)
+	`, id).Scan(&held)
+	return held, err
+}
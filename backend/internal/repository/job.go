@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/protest-tracker/internal/models"
+)
+
+// ErrJobPending is returned when a job cannot be requeued because another
+// job of the same type is already pending for the media item or event
+var ErrJobPending = errors.New("a job of this type is already pending for this media or event")
+
+type JobRepository struct {
+	db *sql.DB
+}
+
+func NewJobRepository(db *sql.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+const jobColumns = `id, type, media_id, event_id, payload, status, attempts, max_attempts,
+	run_at, locked_at, last_error, created_at, finished_at`
+
+func scanJob(row rowScanner) (*models.Job, error) {
+	var job models.Job
+	var mediaID, eventID sql.NullInt64
+	var payload, lastError sql.NullString
+	var lockedAt, finishedAt sql.NullTime
+
+	err := row.Scan(&job.ID, &job.Type, &mediaID, &eventID, &payload, &job.Status, &job.Attempts,
+		&job.MaxAttempts, &job.RunAt, &lockedAt, &lastError, &job.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	job.MediaID = int(mediaID.Int64)
+	job.EventID = int(eventID.Int64)
+	if payload.Valid {
+		job.Payload = []byte(payload.String)
+	}
+	if lockedAt.Valid {
+		job.LockedAt = &lockedAt.Time
+	}
+	job.LastError = lastError.String
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+func scanJobs(rows *sql.Rows) ([]models.Job, error) {
+	defer rows.Close()
+
+	var jobs []models.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// Enqueue inserts a queued job. It reports false, and inserts nothing, when
+// a job of the same type is already queued or running for the media item
+// or event, or with once set, when the media item has ever had a job of
+// the type.
+func (r *JobRepository) Enqueue(job *models.Job, once bool) (bool, error) {
+	var payload sql.NullString
+	if len(job.Payload) > 0 {
+		payload = sql.NullString{String: string(job.Payload), Valid: true}
+	}
+
+	err := r.db.QueryRow(`
+		INSERT INTO jobs (type, media_id, event_id, payload, status, max_attempts, run_at, created_at)
+		SELECT $1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6, $7, $7
+		WHERE NOT $8 OR NOT EXISTS (SELECT 1 FROM jobs WHERE type = $1 AND media_id = $2)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`, job.Type, job.MediaID, job.EventID, payload, models.JobQueued, job.MaxAttempts, job.RunAt, once).Scan(&job.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	job.Status = models.JobQueued
+	job.CreatedAt = job.RunAt
+	return true, nil
+}
+
+// Claim locks the next due job of one of the given types and marks it
+// running. Jobs locked by other workers are skipped. It returns
+// sql.ErrNoRows when no job is due.
+func (r *JobRepository) Claim(types []string, now time.Time) (*models.Job, error) {
+	return scanJob(r.db.QueryRow(`
+		UPDATE jobs
+		SET status = $1, attempts = attempts + 1, locked_at = $2
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = $3 AND run_at <= $2 AND type = ANY($4)
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING `+jobColumns,
+		models.JobRunning, now, models.JobQueued, pq.Array(types)))
+}
+
+// Complete marks a running job done
+func (r *JobRepository) Complete(id int64, now time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE jobs SET status = $1, locked_at = NULL, last_error = NULL, finished_at = $2
+		WHERE id = $3
+	`, models.JobDone, now, id)
+	return err
+}
+
+// Retry puts a failed job back in the queue to run again at runAt
+func (r *JobRepository) Retry(id int64, runAt time.Time, lastError string) error {
+	_, err := r.db.Exec(`
+		UPDATE jobs SET status = $1, locked_at = NULL, run_at = $2, last_error = $3
+		WHERE id = $4
+	`, models.JobQueued, runAt, lastError, id)
+	return err
+}
+
+// Bury marks a job that has used up its attempts dead
+func (r *JobRepository) Bury(id int64, now time.Time, lastError string) error {
+	_, err := r.db.Exec(`
+		UPDATE jobs SET status = $1, locked_at = NULL, last_error = $2, finished_at = $3
+		WHERE id = $4
+	`, models.JobDead, lastError, now, id)
+	return err
+}
+
+// Requeue resets a dead job so that it runs again with a fresh set of
+// attempts
+func (r *JobRepository) Requeue(id int64, now time.Time) (*models.Job, error) {
+	job, err := scanJob(r.db.QueryRow(`
+		UPDATE jobs SET status = $1, attempts = 0, run_at = $2, finished_at = NULL
+		WHERE id = $3 AND status = $4
+		RETURNING `+jobColumns,
+		models.JobQueued, now, id, models.JobDead))
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, ErrJobPending
+	}
+	return job, err
+}
+
+// RequeueStale returns running jobs locked before cutoff to the queue.
+// Their worker is assumed to have died; the attempt still counts.
+func (r *JobRepository) RequeueStale(cutoff, now time.Time) (int64, error) {
+	result, err := r.db.Exec(`
+		UPDATE jobs SET status = $1, locked_at = NULL, run_at = $2,
+			last_error = 'worker stopped before the job finished'
+		WHERE status = $3 AND locked_at < $4
+	`, models.JobQueued, now, models.JobRunning, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteFinished removes jobs that finished successfully before cutoff
+func (r *JobRepository) DeleteFinished(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM jobs WHERE status = $1 AND finished_at < $2", models.JobDone, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetByID retrieves a job by ID
+func (r *JobRepository) GetByID(id int64) (*models.Job, error) {
+	return scanJob(r.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = $1", id))
+}
+
+// GetByStatus retrieves up to limit jobs with a status, newest first
+func (r *JobRepository) GetByStatus(status string, limit int) ([]models.Job, error) {
+	rows, err := r.db.Query(`
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE status = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// GetByMediaID retrieves the jobs run for a media item, oldest first
+func (r *JobRepository) GetByMediaID(mediaID int) ([]models.Job, error) {
+	rows, err := r.db.Query(`
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE media_id = $1
+		ORDER BY id
+	`, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// RefreshMediaStatus derives a media item's processing status from the
+// latest job of each type run for it: failed if any is dead, otherwise
+// running, queued or done
+func (r *JobRepository) RefreshMediaStatus(mediaID int) error {
+	_, err := r.db.Exec(`
+		UPDATE media SET processing_status = COALESCE((
+			SELECT CASE
+				WHEN bool_or(status = 'dead') THEN 'failed'
+				WHEN bool_or(status = 'running') THEN 'running'
+				WHEN bool_or(status = 'queued') THEN 'queued'
+				ELSE 'done'
+			END
+			FROM (
+				SELECT DISTINCT ON (type) status
+				FROM jobs
+				WHERE media_id = $1
+				ORDER BY type, id DESC
+			) latest
+		), 'done')
+		WHERE id = $1
+	`, mediaID)
+	return err
+}
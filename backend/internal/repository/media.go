@@ -1,11 +1,23 @@
 package repository
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/protest-tracker/internal/models"
 )
 
+// ErrDuplicateMedia is returned when saving a live upload whose file is
+// already attached to the event by another live upload
+var ErrDuplicateMedia = errors.New("an identical file is already attached to this event")
+
 type MediaRepository struct {
 	db *sql.DB
 }
@@ -14,14 +26,178 @@ func NewMediaRepository(db *sql.DB) *MediaRepository {
 	return &MediaRepository{db: db}
 }
 
-// GetByEventID retrieves all media for an event
+// mediaColumns is the column list read by scanMedia
+const mediaColumns = `id, event_id, file_path, type, sha256, key_id, wrapped_key,
+	mime_type, size_bytes, extension, parent_id, redaction,
+	legal_hold, deleted_at, deleted_by, delete_reason, purge_after, phash,
+	scan_status, scan_result, scanned_at, uploaded_by, created_at, captured_at,
+	capture_source, width, height, duration_seconds, upload_ip, device_fingerprint,
+	processing_status, timestamped_at, credentials_status`
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMedia(row rowScanner) (*models.Media, error) {
+	var media models.Media
+	var hash, keyID, wrappedKey, mimeType, extension, redaction sql.NullString
+	var parentID, deletedBy, phash sql.NullInt64
+	var deletedAt, purgeAfter, scannedAt sql.NullTime
+	var deleteReason, scanResult sql.NullString
+	var uploadedBy, width, height sql.NullInt64
+	var uploadedAt, capturedAt, timestampedAt sql.NullTime
+	var captureSource, uploadIP, deviceFingerprint, credentialsStatus sql.NullString
+	var duration sql.NullFloat64
+
+	err := row.Scan(&media.ID, &media.EventID, &media.FilePath, &media.Type,
+		&hash, &keyID, &wrappedKey, &mimeType, &media.Size, &extension, &parentID, &redaction,
+		&media.LegalHold, &deletedAt, &deletedBy, &deleteReason, &purgeAfter, &phash,
+		&media.ScanStatus, &scanResult, &scannedAt, &uploadedBy, &uploadedAt, &capturedAt,
+		&captureSource, &width, &height, &duration, &uploadIP, &deviceFingerprint,
+		&media.ProcessingStatus, &timestampedAt, &credentialsStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	if deletedAt.Valid {
+		media.DeletedAt = &deletedAt.Time
+	}
+	if purgeAfter.Valid {
+		media.PurgeAfter = &purgeAfter.Time
+	}
+	media.UploadedBy = int(uploadedBy.Int64)
+	media.UploadedAt = uploadedAt.Time
+	if capturedAt.Valid {
+		media.CapturedAt = &capturedAt.Time
+	}
+	media.CaptureSource = captureSource.String
+	media.Width = int(width.Int64)
+	media.Height = int(height.Int64)
+	if duration.Valid {
+		media.Duration = &duration.Float64
+	}
+	media.UploadIP = uploadIP.String
+	media.DeviceFingerprint = deviceFingerprint.String
+	if timestampedAt.Valid {
+		media.TimestampedAt = &timestampedAt.Time
+	}
+	media.CredentialsStatus = credentialsStatus.String
+
+	if scannedAt.Valid {
+		media.ScannedAt = &scannedAt.Time
+	}
+	media.ScanResult = scanResult.String
+	if phash.Valid {
+		media.PerceptualHash = fmt.Sprintf("%016x", uint64(phash.Int64))
+	}
+	media.DeletedBy = int(deletedBy.Int64)
+	media.DeleteReason = deleteReason.String
+
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		media.ParentID = &id
+	}
+	if redaction.Valid {
+		media.Redaction = &models.Redaction{}
+		if err = json.Unmarshal([]byte(redaction.String), media.Redaction); err != nil {
+			return nil, fmt.Errorf("media %d: invalid redaction: %v", media.ID, err)
+		}
+	}
+
+	media.SHA256 = hash.String
+	media.KeyID = keyID.String
+	media.WrappedKey = wrappedKey.String
+	media.MimeType = mimeType.String
+	media.Extension = extension.String
+
+	return &media, nil
+}
+
+// mediaSortColumns maps MediaFilter sort keys to columns
+var mediaSortColumns = map[string]string{
+	"":           "created_at",
+	"uploadedAt": "created_at",
+	"capturedAt": "captured_at",
+	"size":       "size_bytes",
+	"duration":   "duration_seconds",
+	"width":      "width",
+	"height":     "height",
+}
+
+// GetByEventID retrieves all media for an event, leaving out deleted and
+// quarantined media
 func (r *MediaRepository) GetByEventID(eventID int) ([]models.Media, error) {
+	return r.SearchByEvent(eventID, &models.MediaFilter{})
+}
+
+// SearchByEvent retrieves the media of an event matching filter, in the
+// order it asks for, leaving out deleted and quarantined media. Media
+// missing the sort field come last.
+func (r *MediaRepository) SearchByEvent(eventID int, filter *models.MediaFilter) ([]models.Media, error) {
+	column, ok := mediaSortColumns[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort field %q", filter.Sort)
+	}
+	order := "DESC"
+	if filter.Order == "asc" {
+		order = "ASC"
+	}
+
+	conditions := []string{"event_id = $1", "deleted_at IS NULL", "scan_status = 'clean'"}
+	args := []interface{}{eventID}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Type != "" {
+		where("type = $%d", filter.Type)
+	}
+	if filter.UploadedBy != 0 {
+		where("uploaded_by = $%d", filter.UploadedBy)
+	}
+	if filter.CapturedFrom != nil {
+		where("captured_at >= $%d", *filter.CapturedFrom)
+	}
+	if filter.CapturedTo != nil {
+		where("captured_at < $%d", *filter.CapturedTo)
+	}
+	if filter.UploadedFrom != nil {
+		where("created_at >= $%d", *filter.UploadedFrom)
+	}
+	if filter.UploadedTo != nil {
+		where("created_at < $%d", *filter.UploadedTo)
+	}
+	if filter.MinSize > 0 {
+		where("size_bytes >= $%d", filter.MinSize)
+	}
+	if filter.MaxSize > 0 {
+		where("size_bytes <= $%d", filter.MaxSize)
+	}
+	if filter.MinDuration > 0 {
+		where("duration_seconds >= $%d", filter.MinDuration)
+	}
+	if filter.MaxDuration > 0 {
+		where("duration_seconds <= $%d", filter.MaxDuration)
+	}
+	if filter.MinWidth > 0 {
+		where("width >= $%d", filter.MinWidth)
+	}
+	if filter.MinHeight > 0 {
+		where("height >= $%d", filter.MinHeight)
+	}
+	if filter.Credentials == "none" {
+		conditions = append(conditions, "credentials_status IS NULL")
+	} else if filter.Credentials != "" {
+		where("credentials_status = $%d", filter.Credentials)
+	}
+
 	rows, err := r.db.Query(`
-		SELECT id, event_id, file_path, type 
-		FROM media 
-		WHERE event_id = $1 
-		ORDER BY created_at DESC
-	`, eventID)
+		SELECT `+mediaColumns+`
+		FROM media
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY `+column+` `+order+` NULLS LAST, id `+order, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -29,12 +205,11 @@ func (r *MediaRepository) GetByEventID(eventID int) ([]models.Media, error) {
 
 	var mediaList []models.Media
 	for rows.Next() {
-		var media models.Media
-		err := rows.Scan(&media.ID, &media.EventID, &media.FilePath, &media.Type)
+		media, err := scanMedia(rows)
 		if err != nil {
 			return nil, err
 		}
-		mediaList = append(mediaList, media)
+		mediaList = append(mediaList, *media)
 	}
 
 	return mediaList, nil
@@ -42,27 +217,316 @@ func (r *MediaRepository) GetByEventID(eventID int) ([]models.Media, error) {
 
 // Create creates a new media record
 func (r *MediaRepository) Create(media *models.Media) error {
-	return r.db.QueryRow(`
-		INSERT INTO media (event_id, file_path, type) 
-		VALUES ($1, $2, $3)
+	var redaction sql.NullString
+	if media.Redaction != nil {
+		data, err := json.Marshal(media.Redaction)
+		if err != nil {
+			return err
+		}
+		redaction = sql.NullString{String: string(data), Valid: true}
+	}
+
+	err := r.db.QueryRow(`
+		INSERT INTO media (event_id, file_path, type, sha256, key_id, wrapped_key,
+			mime_type, size_bytes, extension, parent_id, redaction,
+			uploaded_by, created_at, captured_at, capture_source, width, height,
+			duration_seconds, upload_ip, device_fingerprint, processing_status) 
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''),
+			NULLIF($7, ''), $8, NULLIF($9, ''), $10, $11,
+			NULLIF($12, 0), $13, $14, NULLIF($15, ''), NULLIF($16, 0), NULLIF($17, 0),
+			$18, NULLIF($19, ''), NULLIF($20, ''), COALESCE(NULLIF($21, ''), 'done'))
 		RETURNING id
-	`, media.EventID, media.FilePath, media.Type).Scan(&media.ID)
+	`, media.EventID, media.FilePath, media.Type, media.SHA256,
+		media.KeyID, media.WrappedKey, media.MimeType, media.Size, media.Extension,
+		media.ParentID, redaction,
+		media.UploadedBy, media.UploadedAt, media.CapturedAt, media.CaptureSource, media.Width, media.Height,
+		media.Duration, media.UploadIP, media.DeviceFingerprint, media.ProcessingStatus).Scan(&media.ID)
+	return duplicateMedia(err)
 }
 
 // GetByID retrieves a media record by ID
 func (r *MediaRepository) GetByID(id int) (*models.Media, error) {
-	var media models.Media
-	err := r.db.QueryRow(`
-		SELECT id, event_id, file_path, type 
+	return scanMedia(r.db.QueryRow(`
+		SELECT `+mediaColumns+`
 		FROM media 
 		WHERE id = $1
-	`, id).Scan(&media.ID, &media.EventID, &media.FilePath, &media.Type)
+	`, id))
+}
 
+// GetByParentID retrieves the derivatives made from a media record,
+// leaving out deleted and quarantined media
+func (r *MediaRepository) GetByParentID(parentID int) ([]models.Media, error) {
+	rows, err := r.db.Query(`
+		SELECT `+mediaColumns+`
+		FROM media
+		WHERE parent_id = $1 AND deleted_at IS NULL AND scan_status = 'clean'
+		ORDER BY created_at DESC
+	`, parentID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &media, nil
+	var mediaList []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, *media)
+	}
+
+	return mediaList, nil
+}
+
+// GetAll retrieves every media record
+func (r *MediaRepository) GetAll() ([]models.Media, error) {
+	rows, err := r.db.Query(`SELECT ` + mediaColumns + ` FROM media ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mediaList []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, *media)
+	}
+
+	return mediaList, nil
+}
+
+// GetWithStaleKeys retrieves encrypted media whose data key is not sealed
+// by the given master key
+func (r *MediaRepository) GetWithStaleKeys(currentKeyID string) ([]models.Media, error) {
+	rows, err := r.db.Query(`
+		SELECT `+mediaColumns+`
+		FROM media
+		WHERE key_id IS NOT NULL AND key_id <> $1
+		ORDER BY id
+	`, currentKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mediaList []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, *media)
+	}
+
+	return mediaList, nil
+}
+
+// UpdateWrappedKey replaces the sealed data key of a media record
+func (r *MediaRepository) UpdateWrappedKey(id int, keyID, wrappedKey string) error {
+	_, err := r.db.Exec(`
+		UPDATE media SET key_id = $1, wrapped_key = $2 WHERE id = $3
+	`, keyID, wrappedKey, id)
+	return err
+}
+
+// SetProperties records the dimensions, duration and capture time read
+// from a media file
+func (r *MediaRepository) SetProperties(id, width, height int, duration *float64, capturedAt *time.Time, captureSource string) error {
+	_, err := r.db.Exec(`
+		UPDATE media SET width = NULLIF($1, 0), height = NULLIF($2, 0), duration_seconds = $3,
+			captured_at = $4, capture_source = NULLIF($5, '')
+		WHERE id = $6
+	`, width, height, duration, capturedAt, captureSource, id)
+	return err
+}
+
+// SetPerceptualHash records the perceptual hash of a photo
+func (r *MediaRepository) SetPerceptualHash(id int, hash uint64) error {
+	_, err := r.db.Exec(`UPDATE media SET phash = $1 WHERE id = $2`, int64(hash), id)
+	return err
+}
+
+// GetHashedPhotos retrieves every photo that has a perceptual hash, leaving
+// out deleted and quarantined media
+func (r *MediaRepository) GetHashedPhotos() ([]models.Media, error) {
+	rows, err := r.db.Query(`
+		SELECT ` + mediaColumns + `
+		FROM media
+		WHERE phash IS NOT NULL AND deleted_at IS NULL AND scan_status = 'clean'
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mediaList []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, *media)
+	}
+
+	return mediaList, rows.Err()
+}
+
+// GetQuarantinedByEventID retrieves the media of an event that have not
+// passed a malware scan, leaving out deleted media
+func (r *MediaRepository) GetQuarantinedByEventID(eventID int) ([]models.Media, error) {
+	rows, err := r.db.Query(`
+		SELECT `+mediaColumns+`
+		FROM media
+		WHERE event_id = $1 AND deleted_at IS NULL AND scan_status <> 'clean'
+		ORDER BY created_at DESC
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mediaList []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, *media)
+	}
+
+	return mediaList, rows.Err()
+}
+
+// GetUnscanned retrieves the IDs of media waiting for a malware scan or
+// whose last scan failed
+func (r *MediaRepository) GetUnscanned() ([]int, error) {
+	rows, err := r.db.Query(`
+		SELECT id FROM media
+		WHERE scan_status IN ('pending', 'error') AND deleted_at IS NULL
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// SetScanResult records the outcome of a malware scan
+func (r *MediaRepository) SetScanResult(id int, status, result string, scannedAt *time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE media SET scan_status = $1, scan_result = NULLIF($2, ''), scanned_at = $3 WHERE id = $4
+	`, status, result, scannedAt, id)
+	return err
+}
+
+// GetDeletedByEventID retrieves the deleted media of an event that have not
+// been purged yet
+func (r *MediaRepository) GetDeletedByEventID(eventID int) ([]models.Media, error) {
+	rows, err := r.db.Query(`
+		SELECT `+mediaColumns+`
+		FROM media
+		WHERE event_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mediaList []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, *media)
+	}
+
+	return mediaList, nil
+}
+
+// SoftDelete marks a media record deleted unless it or its event is under
+// legal hold. It reports whether the record was marked.
+func (r *MediaRepository) SoftDelete(id, userID int, reason string, deletedAt, purgeAfter time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE media
+		SET deleted_at = $1, deleted_by = $2, delete_reason = $3, purge_after = $4
+		WHERE id = $5 AND deleted_at IS NULL AND NOT legal_hold
+			AND NOT EXISTS (SELECT 1 FROM arrest_events e WHERE e.id = media.event_id AND e.legal_hold)
+	`, deletedAt, userID, reason, purgeAfter, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// Restore clears the deletion of a media record
+func (r *MediaRepository) Restore(id int) error {
+	_, err := r.db.Exec(`
+		UPDATE media
+		SET deleted_at = NULL, deleted_by = NULL, delete_reason = NULL, purge_after = NULL
+		WHERE id = $1
+	`, id)
+	return duplicateMedia(err)
+}
+
+// duplicateMedia turns a violation of the one live upload per file and
+// event index into ErrDuplicateMedia
+func duplicateMedia(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "idx_media_event_sha256_live" {
+		return ErrDuplicateMedia
+	}
+	return err
+}
+
+// SetLegalHold places or releases a legal hold on a media record
+func (r *MediaRepository) SetLegalHold(id int, hold bool) error {
+	_, err := r.db.Exec("UPDATE media SET legal_hold = $1 WHERE id = $2", hold, id)
+	return err
+}
+
+// GetPurgeable retrieves deleted media whose grace period ended before now
+// and that are not under legal hold
+func (r *MediaRepository) GetPurgeable(now time.Time) ([]models.Media, error) {
+	rows, err := r.db.Query(`
+		SELECT `+mediaColumns+`
+		FROM media
+		WHERE deleted_at IS NOT NULL AND purge_after < $1 AND NOT legal_hold
+			AND NOT EXISTS (SELECT 1 FROM arrest_events e WHERE e.id = media.event_id AND e.legal_hold)
+		ORDER BY id
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mediaList []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, *media)
+	}
+
+	return mediaList, nil
 }
 
 // Delete deletes a media record
@@ -70,3 +534,148 @@ func (r *MediaRepository) Delete(id int) error {
 	_, err := r.db.Exec("DELETE FROM media WHERE id = $1", id)
 	return err
 }
+
+// SaveMetadata stores the extracted capture metadata for a media record
+func (r *MediaRepository) SaveMetadata(meta *models.MediaMetadata) error {
+	_, err := r.db.Exec(`
+		INSERT INTO media_metadata (media_id, captured_at, latitude, longitude)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (media_id) DO UPDATE
+		SET captured_at = $2, latitude = $3, longitude = $4
+	`, meta.MediaID, meta.CapturedAt, meta.Latitude, meta.Longitude)
+	return err
+}
+
+// GetMetadata retrieves the capture metadata for a media record
+func (r *MediaRepository) GetMetadata(mediaID int) (*models.MediaMetadata, error) {
+	meta := models.MediaMetadata{MediaID: mediaID}
+	var capturedAt sql.NullTime
+	var latitude, longitude sql.NullFloat64
+
+	err := r.db.QueryRow(`
+		SELECT captured_at, latitude, longitude
+		FROM media_metadata
+		WHERE media_id = $1
+	`, mediaID).Scan(&capturedAt, &latitude, &longitude)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if capturedAt.Valid {
+		meta.CapturedAt = &capturedAt.Time
+	}
+	if latitude.Valid {
+		meta.Latitude = &latitude.Float64
+	}
+	if longitude.Valid {
+		meta.Longitude = &longitude.Float64
+	}
+
+	return &meta, nil
+}
+
+// custodyLockKey serializes appends so every entry links to its predecessor
+const custodyLockKey = 0x63757374
+
+// AppendCustody adds an entry to the chain-of-custody log. The entry hash
+// covers the previous entry's hash and the entry's own fields.
+func (r *MediaRepository) AppendCustody(entry *models.CustodyEntry) error {
+	_, err := r.appendCustody(entry, 0)
+	return err
+}
+
+// AppendCustodyOnce adds an entry to the custody log unless the same user
+// already has an identical entry for the media within the window. It
+// reports whether the entry was added.
+func (r *MediaRepository) AppendCustodyOnce(entry *models.CustodyEntry, window time.Duration) (bool, error) {
+	return r.appendCustody(entry, window)
+}
+
+func (r *MediaRepository) appendCustody(entry *models.CustodyEntry, window time.Duration) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", custodyLockKey); err != nil {
+		return false, err
+	}
+
+	if window > 0 {
+		var recent bool
+		err = tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM custody_log
+				WHERE media_id = $1 AND user_id IS NOT DISTINCT FROM NULLIF($2, 0)
+				  AND action = $3 AND COALESCE(details, '') = $4 AND created_at > $5
+			)
+		`, entry.MediaID, entry.UserID, entry.Action, entry.Details,
+			time.Now().UTC().Add(-window)).Scan(&recent)
+		if err != nil || recent {
+			return false, err
+		}
+	}
+
+	err = tx.QueryRow("SELECT entry_hash FROM custody_log ORDER BY id DESC LIMIT 1").Scan(&entry.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	entry.EntryHash = CustodyEntryHash(entry)
+
+	err = tx.QueryRow(`
+		INSERT INTO custody_log (media_id, event_id, user_id, action, details, prev_hash, entry_hash, created_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8)
+		RETURNING id
+	`, entry.MediaID, entry.EventID, entry.UserID, entry.Action, entry.Details,
+		entry.PrevHash, entry.EntryHash, entry.CreatedAt).Scan(&entry.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// GetCustodyLog retrieves the custody entries for a media record, oldest first
+func (r *MediaRepository) GetCustodyLog(mediaID int) ([]models.CustodyEntry, error) {
+	rows, err := r.db.Query(`
+		SELECT id, media_id, event_id, user_id, action, details, prev_hash, entry_hash, created_at
+		FROM custody_log
+		WHERE media_id = $1
+		ORDER BY id
+	`, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.CustodyEntry
+	for rows.Next() {
+		var entry models.CustodyEntry
+		var userID sql.NullInt64
+		var details sql.NullString
+
+		err := rows.Scan(&entry.ID, &entry.MediaID, &entry.EventID, &userID, &entry.Action,
+			&details, &entry.PrevHash, &entry.EntryHash, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.UserID = int(userID.Int64)
+		entry.Details = details.String
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// CustodyEntryHash computes the chained hash of a custody entry
+func CustodyEntryHash(entry *models.CustodyEntry) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%s|%s|%s",
+		entry.PrevHash, entry.MediaID, entry.EventID, entry.UserID, entry.Action,
+		entry.Details, entry.CreatedAt.UTC().Format(time.RFC3339Nano))))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+type ShareRepository struct {
+	db *sql.DB
+}
+
+func NewShareRepository(db *sql.DB) *ShareRepository {
+	return &ShareRepository{db: db}
+}
+
+const shareLinkColumns = `id, event_id, media_id, created_by, note, password_hash,
+	max_views, view_count, expires_at, revoked_at, created_at`
+
+func scanShareLink(row rowScanner) (*models.ShareLink, error) {
+	var link models.ShareLink
+	var mediaID, createdBy, maxViews sql.NullInt64
+	var note, passwordHash sql.NullString
+	var revokedAt sql.NullTime
+
+	err := row.Scan(&link.ID, &link.EventID, &mediaID, &createdBy, &note, &passwordHash,
+		&maxViews, &link.ViewCount, &link.ExpiresAt, &revokedAt, &link.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaID.Valid {
+		id := int(mediaID.Int64)
+		link.MediaID = &id
+	}
+	if maxViews.Valid {
+		views := int(maxViews.Int64)
+		link.MaxViews = &views
+	}
+	if revokedAt.Valid {
+		link.RevokedAt = &revokedAt.Time
+	}
+	link.CreatedBy = int(createdBy.Int64)
+	link.Note = note.String
+	link.PasswordHash = passwordHash.String
+	link.HasPassword = link.PasswordHash != ""
+
+	return &link, nil
+}
+
+// Create creates a new share link
+func (r *ShareRepository) Create(link *models.ShareLink) error {
+	return r.db.QueryRow(`
+		INSERT INTO share_links (event_id, media_id, created_by, note, password_hash, max_views, expires_at, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8)
+		RETURNING id
+	`, link.EventID, link.MediaID, link.CreatedBy, link.Note, link.PasswordHash,
+		link.MaxViews, link.ExpiresAt, link.CreatedAt).Scan(&link.ID)
+}
+
+// GetByID retrieves a share link by ID
+func (r *ShareRepository) GetByID(id int) (*models.ShareLink, error) {
+	row := r.db.QueryRow(`SELECT `+shareLinkColumns+` FROM share_links WHERE id = $1`, id)
+	return scanShareLink(row)
+}
+
+// GetByEventID retrieves every share link of an event, newest first
+func (r *ShareRepository) GetByEventID(eventID int) ([]models.ShareLink, error) {
+	rows, err := r.db.Query(`
+		SELECT `+shareLinkColumns+`
+		FROM share_links
+		WHERE event_id = $1
+		ORDER BY id DESC
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+
+	return links, rows.Err()
+}
+
+// Revoke marks a share link revoked. It returns false if the link was
+// already revoked.
+func (r *ShareRepository) Revoke(id int, now time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE share_links SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL
+	`, now, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// ConsumeView counts one view of a share link. It returns false when the
+// link has run out of views, expired or been revoked in the meantime.
+func (r *ShareRepository) ConsumeView(id int, now time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE share_links SET view_count = view_count + 1
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2
+			AND (max_views IS NULL OR view_count < max_views)
+	`, id, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// LogAccess records an attempt to use a share link
+func (r *ShareRepository) LogAccess(access *models.ShareAccess) error {
+	return r.db.QueryRow(`
+		INSERT INTO share_link_access (link_id, outcome, ip, user_agent, accessed_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)
+		RETURNING id
+	`, access.LinkID, access.Outcome, access.IP, access.UserAgent, access.AccessedAt).Scan(&access.ID)
+}
+
+// GetAccessLog retrieves every recorded use of a share link, oldest first
+func (r *ShareRepository) GetAccessLog(linkID int) ([]models.ShareAccess, error) {
+	rows, err := r.db.Query(`
+		SELECT id, link_id, outcome, ip, user_agent, accessed_at
+		FROM share_link_access
+		WHERE link_id = $1
+		ORDER BY id
+	`, linkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	log := []models.ShareAccess{}
+	for rows.Next() {
+		var access models.ShareAccess
+		var ip, userAgent sql.NullString
+		err := rows.Scan(&access.ID, &access.LinkID, &access.Outcome, &ip, &userAgent, &access.AccessedAt)
+		if err != nil {
+			return nil, err
+		}
+		access.IP = ip.String
+		access.UserAgent = userAgent.String
+		log = append(log, access)
+	}
+
+	return log, rows.Err()
+}
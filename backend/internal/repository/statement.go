@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+type StatementRepository struct {
+	db *sql.DB
+}
+
+func NewStatementRepository(db *sql.DB) *StatementRepository {
+	return &StatementRepository{db: db}
+}
+
+// Create stores a witness statement and fills in its ID
+func (r *StatementRepository) Create(statement *models.WitnessStatement) error {
+	return r.db.QueryRow(`
+		INSERT INTO witness_statements (event_id, user_id, statement, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, statement.EventID, statement.UserID, statement.Statement, statement.CreatedAt).Scan(&statement.ID)
+}
+
+// GetByEventID retrieves the statements given about an event, oldest first
+func (r *StatementRepository) GetByEventID(eventID int) ([]models.WitnessStatement, error) {
+	rows, err := r.db.Query(`
+		SELECT id, event_id, user_id, statement, created_at
+		FROM witness_statements
+		WHERE event_id = $1
+		ORDER BY id
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statements := []models.WitnessStatement{}
+	for rows.Next() {
+		var s models.WitnessStatement
+		var userID sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.EventID, &userID, &s.Statement, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.UserID = int(userID.Int64)
+		statements = append(statements, s)
+	}
+	return statements, rows.Err()
+}
@@ -14,14 +14,19 @@ func NewSubscriptionRepository(db *sql.DB) *SubscriptionRepository {
 	return &SubscriptionRepository{db: db}
 }
 
-// Subscribe adds a subscription for a user to an event
-func (r *SubscriptionRepository) Subscribe(eventID, userID int) error {
-	_, err := r.db.Exec(`
+// Subscribe adds a subscription for a user to an event. It reports false
+// if the user was already subscribed.
+func (r *SubscriptionRepository) Subscribe(eventID, userID int) (bool, error) {
+	result, err := r.db.Exec(`
 		INSERT INTO subscriptions (event_id, user_id) 
 		VALUES ($1, $2) 
 		ON CONFLICT (event_id, user_id) DO NOTHING
 	`, eventID, userID)
-	return err
+	if err != nil {
+		return false, err
+	}
+	added, err := result.RowsAffected()
+	return added > 0, err
 }
 
 // Unsubscribe removes a subscription
@@ -36,7 +41,7 @@ func (r *SubscriptionRepository) Unsubscribe(eventID, userID int) error {
 // GetSubscribersByEventID retrieves all subscribers for an event
 func (r *SubscriptionRepository) GetSubscribersByEventID(eventID int) ([]models.User, error) {
 	rows, err := r.db.Query(`
-		SELECT u.id, u.email, u.role 
+		SELECT u.id, u.email, u.phone_number, u.role, u.notification_channels
 		FROM subscriptions s 
 		JOIN users u ON s.user_id = u.id 
 		WHERE s.event_id = $1
@@ -49,10 +54,13 @@ func (r *SubscriptionRepository) GetSubscribersByEventID(eventID int) ([]models.
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.ID, &user.Email, &user.Role)
+		var phone, channels sql.NullString
+		err := rows.Scan(&user.ID, &user.Email, &phone, &user.Role, &channels)
 		if err != nil {
 			return nil, err
 		}
+		user.PhoneNumber = phone.String
+		user.NotificationChannels = splitChannels(channels)
 		users = append(users, user)
 	}
 
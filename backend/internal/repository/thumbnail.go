@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/protest-tracker/internal/models"
+)
+
+// thumbnailColumns is the column list read by scanThumbnail
+const thumbnailColumns = `media_id, size, file_path, width, height, size_bytes, key_id, wrapped_key`
+
+func scanThumbnail(row rowScanner) (*models.Thumbnail, error) {
+	var thumb models.Thumbnail
+	var keyID, wrappedKey sql.NullString
+
+	err := row.Scan(&thumb.MediaID, &thumb.Size, &thumb.FilePath, &thumb.Width, &thumb.Height,
+		&thumb.Bytes, &keyID, &wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb.KeyID = keyID.String
+	thumb.WrappedKey = wrappedKey.String
+
+	return &thumb, nil
+}
+
+func scanThumbnails(rows *sql.Rows) ([]models.Thumbnail, error) {
+	defer rows.Close()
+
+	var thumbs []models.Thumbnail
+	for rows.Next() {
+		thumb, err := scanThumbnail(rows)
+		if err != nil {
+			return nil, err
+		}
+		thumbs = append(thumbs, *thumb)
+	}
+
+	return thumbs, rows.Err()
+}
+
+// SaveThumbnail stores a thumbnail record, replacing any earlier one of the
+// same size
+func (r *MediaRepository) SaveThumbnail(thumb *models.Thumbnail) error {
+	_, err := r.db.Exec(`
+		INSERT INTO media_thumbnails (media_id, size, file_path, width, height, size_bytes, key_id, wrapped_key)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
+		ON CONFLICT (media_id, size) DO UPDATE
+		SET file_path = $3, width = $4, height = $5, size_bytes = $6, key_id = NULLIF($7, ''),
+			wrapped_key = NULLIF($8, ''), created_at = CURRENT_TIMESTAMP
+	`, thumb.MediaID, thumb.Size, thumb.FilePath, thumb.Width, thumb.Height, thumb.Bytes,
+		thumb.KeyID, thumb.WrappedKey)
+	return err
+}
+
+// GetThumbnail retrieves one thumbnail size of a media record
+func (r *MediaRepository) GetThumbnail(mediaID int, size string) (*models.Thumbnail, error) {
+	return scanThumbnail(r.db.QueryRow(`
+		SELECT `+thumbnailColumns+`
+		FROM media_thumbnails
+		WHERE media_id = $1 AND size = $2
+	`, mediaID, size))
+}
+
+// GetThumbnails retrieves every thumbnail of a media record
+func (r *MediaRepository) GetThumbnails(mediaID int) ([]models.Thumbnail, error) {
+	rows, err := r.db.Query(`
+		SELECT `+thumbnailColumns+`
+		FROM media_thumbnails
+		WHERE media_id = $1
+	`, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	return scanThumbnails(rows)
+}
+
+// GetAllThumbnails retrieves every thumbnail record
+func (r *MediaRepository) GetAllThumbnails() ([]models.Thumbnail, error) {
+	rows, err := r.db.Query(`SELECT ` + thumbnailColumns + ` FROM media_thumbnails ORDER BY media_id, size`)
+	if err != nil {
+		return nil, err
+	}
+	return scanThumbnails(rows)
+}
+
+// GetThumbnailSizesByEvent maps each media record of an event to the
+// thumbnail sizes generated for it
+func (r *MediaRepository) GetThumbnailSizesByEvent(eventID int) (map[int][]string, error) {
+	rows, err := r.db.Query(`
+		SELECT t.media_id, t.size
+		FROM media_thumbnails t
+		JOIN media m ON m.id = t.media_id
+		WHERE m.event_id = $1
+		ORDER BY t.media_id, t.width
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[int][]string)
+	for rows.Next() {
+		var mediaID int
+		var size string
+		if err := rows.Scan(&mediaID, &size); err != nil {
+			return nil, err
+		}
+		sizes[mediaID] = append(sizes[mediaID], size)
+	}
+
+	return sizes, rows.Err()
+}
+
+// GetUnprocessedMedia retrieves the IDs of clean photos and documents of
+// the given MIME types that are missing some of their sizeCount thumbnails,
+// or for photos their perceptual hash. Photos uploaded before type
+// detection are always included.
+func (r *MediaRepository) GetUnprocessedMedia(sizeCount int, mimeTypes []string) ([]int, error) {
+	rows, err := r.db.Query(`
+		SELECT m.id
+		FROM media m
+		LEFT JOIN media_thumbnails t ON t.media_id = m.id
+		WHERE m.type IN ('photo', 'document') AND m.deleted_at IS NULL AND m.scan_status = 'clean'
+			AND (m.mime_type IS NULL OR m.mime_type = ANY($2))
+		GROUP BY m.id
+		HAVING COUNT(t.size) < $1 OR (m.type = 'photo' AND m.phash IS NULL)
+		ORDER BY m.id
+	`, sizeCount, pq.Array(mimeTypes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// GetThumbnailsWithStaleKeys retrieves encrypted thumbnails whose data key
+// is not sealed by the given master key
+func (r *MediaRepository) GetThumbnailsWithStaleKeys(currentKeyID string) ([]models.Thumbnail, error) {
+	rows, err := r.db.Query(`
+		SELECT `+thumbnailColumns+`
+		FROM media_thumbnails
+		WHERE key_id IS NOT NULL AND key_id <> $1
+		ORDER BY media_id, size
+	`, currentKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return scanThumbnails(rows)
+}
+
+// UpdateThumbnailKey replaces the sealed data key of a thumbnail
+func (r *MediaRepository) UpdateThumbnailKey(mediaID int, size, keyID, wrappedKey string) error {
+	_, err := r.db.Exec(`
+		UPDATE media_thumbnails SET key_id = $1, wrapped_key = $2 WHERE media_id = $3 AND size = $4
+	`, keyID, wrappedKey, mediaID, size)
+	return err
+}
+
+// UpdateThumbnailPath points a thumbnail at a new storage reference
+func (r *MediaRepository) UpdateThumbnailPath(mediaID int, size, ref string) error {
+	_, err := r.db.Exec(`
+		UPDATE media_thumbnails SET file_path = $1 WHERE media_id = $2 AND size = $3
+	`, ref, mediaID, size)
+	return err
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SetTimestamp stores the RFC 3161 token over a media item's hash
+func (r *MediaRepository) SetTimestamp(id int, token []byte, timestampedAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE media SET timestamp_token = $1, timestamped_at = $2 WHERE id = $3
+	`, token, timestampedAt, id)
+	return err
+}
+
+// GetTimestampToken retrieves the RFC 3161 token over a media item's hash.
+// It returns sql.ErrNoRows if the media has not been timestamped.
+func (r *MediaRepository) GetTimestampToken(id int) ([]byte, error) {
+	var token []byte
+	err := r.db.QueryRow(`
+		SELECT timestamp_token FROM media WHERE id = $1 AND timestamp_token IS NOT NULL
+	`, id).Scan(&token)
+	return token, err
+}
+
+// GetUntimestamped retrieves the IDs of hashed media without a timestamp,
+// leaving out deleted media
+func (r *MediaRepository) GetUntimestamped() ([]int, error) {
+	return queryIDs(r.db, `
+		SELECT id FROM media
+		WHERE timestamp_token IS NULL AND sha256 IS NOT NULL AND deleted_at IS NULL
+		ORDER BY id
+	`)
+}
+
+// SetTimestamp stores the RFC 3161 token over an event's creation record,
+// together with the record itself
+func (r *EventRepository) SetTimestamp(id int, record string, token []byte, timestampedAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE arrest_events SET timestamp_record = $1, timestamp_token = $2, timestamped_at = $3
+		WHERE id = $4
+	`, record, token, timestampedAt, id)
+	return err
+}
+
+// GetTimestamp retrieves an event's creation record and the RFC 3161 token
+// over it. It returns sql.ErrNoRows if the event has not been timestamped.
+func (r *EventRepository) GetTimestamp(id int) (string, []byte, error) {
+	var record string
+	var token []byte
+	err := r.db.QueryRow(`
+		SELECT timestamp_record, timestamp_token FROM arrest_events
+		WHERE id = $1 AND timestamp_token IS NOT NULL
+	`, id).Scan(&record, &token)
+	return record, token, err
+}
+
+// GetUntimestamped retrieves the IDs of events without a timestamp
+func (r *EventRepository) GetUntimestamped() ([]int, error) {
+	return queryIDs(r.db, `
+		SELECT id FROM arrest_events WHERE timestamp_token IS NULL ORDER BY id
+	`)
+}
+
+func queryIDs(db *sql.DB, query string) ([]int, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
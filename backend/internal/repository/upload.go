@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+type UploadRepository struct {
+	db *sql.DB
+}
+
+func NewUploadRepository(db *sql.DB) *UploadRepository {
+	return &UploadRepository{db: db}
+}
+
+// Create creates a new upload session
+func (r *UploadRepository) Create(session *models.UploadSession) error {
+	_, err := r.db.Exec(`
+		INSERT INTO upload_sessions (id, event_id, user_id, type, length, sha256, key_id, wrapped_key,
+			captured_at, upload_ip, device_fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''),
+			$9, NULLIF($10, ''), NULLIF($11, ''), $12)
+	`, session.ID, session.EventID, session.UserID, session.Type, session.Length,
+		session.SHA256, session.KeyID, session.WrappedKey,
+		session.CapturedAt, session.UploadIP, session.DeviceFingerprint, session.ExpiresAt)
+	return err
+}
+
+// uploadLockClass is the first key of upload session advisory locks; the
+// second is a hash of the session ID
+const uploadLockClass = 0x75706c64
+
+// UploadLock holds the advisory lock of an upload session until Unlock is
+// called. It serializes work on a session across server instances.
+type UploadLock struct {
+	tx *sql.Tx
+}
+
+// Lock waits for and takes the advisory lock of an upload session
+func (r *UploadRepository) Lock(id string) (*UploadLock, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", uploadLockClass, id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &UploadLock{tx: tx}, nil
+}
+
+// Unlock releases the lock
+func (l *UploadLock) Unlock() {
+	l.tx.Rollback()
+}
+
+// GetByID retrieves an upload session by ID
+func (r *UploadRepository) GetByID(id string) (*models.UploadSession, error) {
+	var session models.UploadSession
+	var hash, keyID, wrappedKey, uploadIP, deviceFingerprint sql.NullString
+	var mediaID sql.NullInt64
+	var capturedAt sql.NullTime
+
+	err := r.db.QueryRow(`
+		SELECT id, event_id, user_id, type, length, upload_offset, sha256,
+			key_id, wrapped_key, media_id, duplicate, captured_at, upload_ip, device_fingerprint, expires_at
+		FROM upload_sessions
+		WHERE id = $1
+	`, id).Scan(&session.ID, &session.EventID, &session.UserID, &session.Type,
+		&session.Length, &session.Offset, &hash, &keyID, &wrappedKey, &mediaID, &session.Duplicate,
+		&capturedAt, &uploadIP, &deviceFingerprint, &session.ExpiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	session.SHA256 = hash.String
+	session.KeyID = keyID.String
+	session.WrappedKey = wrappedKey.String
+	session.MediaID = int(mediaID.Int64)
+	if capturedAt.Valid {
+		session.CapturedAt = &capturedAt.Time
+	}
+	session.UploadIP = uploadIP.String
+	session.DeviceFingerprint = deviceFingerprint.String
+
+	return &session, nil
+}
+
+// UpdateOffset records how many bytes have been received and extends the
+// session's expiry
+func (r *UploadRepository) UpdateOffset(id string, offset int64, expiresAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE upload_sessions SET upload_offset = $1, expires_at = $2 WHERE id = $3
+	`, offset, expiresAt, id)
+	return err
+}
+
+// Complete links a finished upload session to the media record it produced
+// or, for a duplicate, the existing record it matched
+func (r *UploadRepository) Complete(id string, mediaID int, duplicate bool) error {
+	_, err := r.db.Exec(`
+		UPDATE upload_sessions SET media_id = $1, duplicate = $2 WHERE id = $3
+	`, mediaID, duplicate, id)
+	return err
+}
+
+// Delete deletes an upload session
+func (r *UploadRepository) Delete(id string) error {
+	_, err := r.db.Exec("DELETE FROM upload_sessions WHERE id = $1", id)
+	return err
+}
+
+// DeleteExpired deletes sessions that expired before now and returns their IDs
+func (r *UploadRepository) DeleteExpired(now time.Time) ([]string, error) {
+	rows, err := r.db.Query("DELETE FROM upload_sessions WHERE expires_at < $1 RETURNING id", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
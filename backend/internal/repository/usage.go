@@ -0,0 +1,27 @@
+package repository
+
+// GetUsageByUser returns the bytes and number of media uploaded by a user.
+// Deleted media count until they are purged, since their files are kept.
+func (r *MediaRepository) GetUsageByUser(userID int) (int64, int, error) {
+	var used int64
+	var files int
+	err := r.db.QueryRow(`
+		SELECT COALESCE(SUM(size_bytes), 0), COUNT(*)
+		FROM media
+		WHERE uploaded_by = $1
+	`, userID).Scan(&used, &files)
+	return used, files, err
+}
+
+// GetUsageByEvent returns the bytes and number of media held by an event,
+// deleted media included until they are purged
+func (r *MediaRepository) GetUsageByEvent(eventID int) (int64, int, error) {
+	var used int64
+	var files int
+	err := r.db.QueryRow(`
+		SELECT COALESCE(SUM(size_bytes), 0), COUNT(*)
+		FROM media
+		WHERE event_id = $1
+	`, eventID).Scan(&used, &files)
+	return used, files, err
+}
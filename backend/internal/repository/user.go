@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"strings"
 
 	"github.com/protest-tracker/internal/models"
 )
@@ -30,20 +31,43 @@ func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
 // Create creates a new user
 func (r *UserRepository) Create(user *models.User) error {
 	return r.db.QueryRow(
-		"INSERT INTO users (email, password, role) VALUES ($1, $2, $3) RETURNING id",
-		user.Email, user.Password, user.Role,
+		"INSERT INTO users (email, phone_number, password, role) VALUES ($1, NULLIF($2, ''), $3, $4) RETURNING id",
+		user.Email, user.PhoneNumber, user.Password, user.Role,
 	).Scan(&user.ID)
 }
 
 // GetByID retrieves a user by ID
 func (r *UserRepository) GetByID(id int) (*models.User, error) {
 	var user models.User
-	err := r.db.QueryRow("SELECT id, email, password, role FROM users WHERE id = $1", id).
-		Scan(&user.ID, &user.Email, &user.Password, &user.Role)
+	var phone, channels sql.NullString
+	err := r.db.QueryRow(`
+		SELECT id, email, phone_number, password, role, notification_channels
+		FROM users WHERE id = $1
+	`, id).Scan(&user.ID, &user.Email, &phone, &user.Password, &user.Role, &channels)
 
 	if err != nil {
 		return nil, err
 	}
 
+	user.PhoneNumber = phone.String
+	user.NotificationChannels = splitChannels(channels)
 	return &user, nil
 }
+
+// SetNotificationPreferences stores the channels a user wants to be
+// notified on and their phone number
+func (r *UserRepository) SetNotificationPreferences(id int, channels []string, phone string) error {
+	_, err := r.db.Exec(`
+		UPDATE users SET notification_channels = NULLIF($1, ''), phone_number = NULLIF($2, '')
+		WHERE id = $3
+	`, strings.Join(channels, ","), phone, id)
+	return err
+}
+
+// splitChannels reads a comma-separated channel list
+func splitChannels(channels sql.NullString) []string {
+	if channels.String == "" {
+		return nil
+	}
+	return strings.Split(channels.String, ",")
+}
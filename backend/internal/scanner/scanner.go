@@ -0,0 +1,52 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Result is the verdict of a malware scan
+type Result struct {
+	Infected bool
+	// Signature names what was found in an infected file
+	Signature string
+}
+
+// Scanner checks uploaded files for malware
+type Scanner interface {
+	// Name identifies the scanner in scan records ("clamd", "none")
+	Name() string
+	// Scan reads r to the end and reports whether it is infected. An error
+	// means no verdict was reached.
+	Scan(r io.Reader) (*Result, error)
+}
+
+// NoOp passes every file without looking at it. It is used when no
+// scanner is configured.
+type NoOp struct{}
+
+// Name returns "none"
+func (NoOp) Name() string {
+	return "none"
+}
+
+// Scan reports r as clean
+func (NoOp) Scan(r io.Reader) (*Result, error) {
+	return &Result{}, nil
+}
+
+// Load creates the scanner selected by kind: "none" or "clamd", which
+// connects to the clamd daemon at address
+func Load(kind, address string, timeout time.Duration) (Scanner, error) {
+	switch kind {
+	case "", "none":
+		return NoOp{}, nil
+	case "clamd":
+		if address == "" {
+			return nil, fmt.Errorf("clamd scanner requires an address")
+		}
+		return NewClamd(address, timeout), nil
+	}
+	return nil, fmt.Errorf("unknown malware scanner %q", kind)
+}
@@ -3,9 +3,12 @@ package services
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/protest-tracker/internal/auth"
 	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/notify"
 	"github.com/protest-tracker/internal/repository"
 )
 
@@ -54,6 +57,11 @@ func (s *AuthService) Register(email, password, phone_number, role string) (*mod
 		return nil, errors.New("user already exists")
 	}
 
+	phone_number, err = normalizePhone(phone_number)
+	if err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := s.auth.HashPassword(password)
 	if err != nil {
@@ -62,9 +70,10 @@ func (s *AuthService) Register(email, password, phone_number, role string) (*mod
 
 	// Create user
 	user := &models.User{
-		Email:    email,
-		Password: hashedPassword,
-		Role:     role,
+		Email:       email,
+		PhoneNumber: phone_number,
+		Password:    hashedPassword,
+		Role:        role,
 	}
 
 	err = s.userRepo.Create(user)
@@ -76,3 +85,62 @@ func (s *AuthService) Register(email, password, phone_number, role string) (*mod
 	user.Password = ""
 	return user, nil
 }
+
+// GetNotificationPreferences retrieves how a user wants to be contacted
+func (s *AuthService) GetNotificationPreferences(userID int) (*models.NotificationPreferences, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	channels := user.NotificationChannels
+	if channels == nil {
+		channels = []string{}
+	}
+	return &models.NotificationPreferences{Channels: channels, PhoneNumber: user.PhoneNumber}, nil
+}
+
+// SetNotificationPreferences stores the channels a user wants to be
+// notified on, in order of preference, and their phone number for SMS
+func (s *AuthService) SetNotificationPreferences(userID int, prefs *models.NotificationPreferences) error {
+	seen := make(map[string]bool)
+	for _, channel := range prefs.Channels {
+		if !notify.ValidChannel(channel) {
+			return fmt.Errorf("unknown channel %q", channel)
+		}
+		if seen[channel] {
+			return fmt.Errorf("channel %q is listed twice", channel)
+		}
+		seen[channel] = true
+	}
+
+	phone, err := normalizePhone(prefs.PhoneNumber)
+	if err != nil {
+		return err
+	}
+	if seen[notify.ChannelSMS] && phone == "" {
+		return errors.New("a phone number is required for SMS")
+	}
+
+	prefs.PhoneNumber = phone
+	return s.userRepo.SetNotificationPreferences(userID, prefs.Channels, phone)
+}
+
+// normalizePhone strips the punctuation people write phone numbers with.
+// What is left must be 7 to 15 digits, optionally after a leading +.
+func normalizePhone(phone string) (string, error) {
+	phone = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -().", r) {
+			return -1
+		}
+		return r
+	}, phone)
+	if phone == "" {
+		return "", nil
+	}
+
+	digits := strings.TrimPrefix(phone, "+")
+	if len(digits) < 7 || len(digits) > 15 || strings.Trim(digits, "0123456789") != "" {
+		return "", errors.New("invalid phone number")
+	}
+	return phone, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+// storeBlob stores src content-addressed: files are kept once per SHA-256
+// and shared between media records. The content is encrypted and hashed
+// into a temporary file first, since its hash decides where it goes. The
+// caller owns one reference to the returned file.
+func (s *MediaService) storeBlob(src io.Reader) (*storedFile, error) {
+	if err := s.checkFreeSpace(0); err != nil {
+		return nil, err
+	}
+
+	stored := &storedFile{}
+	dataKey, err := s.newDataKey(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "media-blob-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	stored.Size, stored.SHA256, err = writeEncrypted(tmp, src, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	// Held until the blob is recorded, so that no other upload of the same
+	// content, on any instance, writes the file meanwhile
+	lock, err := s.mediaRepo.LockBlob(stored.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+
+	// Identical content is already stored; share it
+	blob, err := lock.Get()
+	if err == nil {
+		if err = lock.AddRef(); err != nil {
+			return nil, err
+		}
+		if err = lock.Commit(); err != nil {
+			return nil, err
+		}
+		return &storedFile{
+			Ref:        blob.FilePath,
+			SHA256:     blob.SHA256,
+			Size:       blob.Size,
+			KeyID:      blob.KeyID,
+			WrappedKey: blob.WrappedKey,
+		}, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	stored.Ref, err = s.storage.Put(s.blobKey(stored.SHA256), tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file: %v", err)
+	}
+
+	err = lock.Create(&models.Blob{
+		SHA256:     stored.SHA256,
+		FilePath:   stored.Ref,
+		Size:       stored.Size,
+		KeyID:      stored.KeyID,
+		WrappedKey: stored.WrappedKey,
+	})
+	if err == nil {
+		err = lock.Commit()
+	}
+	if err != nil {
+		s.storage.Delete(stored.Ref)
+		return nil, fmt.Errorf("failed to save file record: %v", err)
+	}
+
+	return stored, nil
+}
+
+// releaseFile drops a media record's reference to its file and deletes the
+// file once nothing else uses it. Files stored before content addressing
+// belong to a single record and are deleted directly.
+func (s *MediaService) releaseFile(sha256, ref string) error {
+	lock, err := s.mediaRepo.LockBlob(sha256)
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	blob, err := lock.Get()
+	if err == sql.ErrNoRows || (err == nil && blob.FilePath != ref) {
+		return s.storage.Delete(ref)
+	}
+	if err != nil {
+		return err
+	}
+
+	remaining, err := lock.Release()
+	if err != nil {
+		return err
+	}
+	// The file is deleted before the lock is released, so that a new
+	// upload of the same content cannot write it again only for it to be
+	// deleted here. If deleting fails, the reference is kept.
+	if remaining <= 0 {
+		if err = s.storage.Delete(ref); err != nil {
+			return err
+		}
+	}
+	return lock.Commit()
+}
+
+// blobKey is the storage key of content with the given hash. With
+// encryption enabled the name is keyed so the storage provider cannot tell
+// whether a known file is held.
+func (s *MediaService) blobKey(sha256 string) string {
+	name := sha256
+	if s.keyring != nil {
+		name = s.keyring.ContentName([]byte(sha256))
+	}
+	return "blobs/" + name[:2] + "/" + name
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/protest-tracker/internal/c2pa"
+	"github.com/protest-tracker/internal/models"
+)
+
+// JobInspectCredentials reads the content credentials of a clean video or
+// audio file. Photos are inspected while they are uploaded instead, since
+// stripping their metadata removes the manifest.
+const JobInspectCredentials = "inspect_credentials"
+
+// credentialTypes are the video and audio formats content credentials are
+// read from
+var credentialTypes = map[string]bool{
+	"video/mp4":       true,
+	"video/quicktime": true,
+	"audio/mp4":       true,
+}
+
+// ErrNoCredentials is returned for media without content credentials
+var ErrNoCredentials = errors.New("media has no content credentials")
+
+// readCredentials inspects the C2PA manifest embedded in r. It returns nil
+// when there is none, or it cannot be read; evidence without credentials
+// is the norm, so neither stops an upload.
+func (s *MediaService) readCredentials(r io.ReadSeeker) *c2pa.Report {
+	report, err := c2pa.Inspect(r, s.credentialRoots)
+	if err != nil {
+		if err != c2pa.ErrNoManifest {
+			log.Printf("Failed to read content credentials: %v", err)
+		}
+		return nil
+	}
+	return report
+}
+
+// saveCredentials stores what a media item's content credentials say.
+// Manifests that fail validation are logged as a warning: the file was
+// altered after it was signed, or the credentials were forged.
+func (s *MediaService) saveCredentials(media *models.Media, report *c2pa.Report) {
+	credentials := &models.ContentCredentials{
+		MediaID:        media.ID,
+		Status:         report.Status,
+		Problems:       report.Problems,
+		Warnings:       report.Warnings,
+		Signer:         report.Signer,
+		Issuer:         report.Issuer,
+		ClaimGenerator: report.ClaimGenerator,
+		Device:         report.Device,
+		Title:          report.Title,
+		Manifests:      report.Manifests,
+		InspectedAt:    time.Now().UTC(),
+	}
+	for _, action := range report.Actions {
+		credentials.Actions = append(credentials.Actions, models.CredentialAction(action))
+	}
+	for _, ingredient := range report.Ingredients {
+		credentials.Ingredients = append(credentials.Ingredients, models.CredentialIngredient(ingredient))
+	}
+
+	if err := s.mediaRepo.SaveCredentials(credentials); err != nil {
+		log.Printf("Failed to save content credentials of media %d: %v", media.ID, err)
+		return
+	}
+	media.CredentialsStatus = credentials.Status
+	if credentials.Status == models.CredentialsInvalid {
+		log.Printf("WARNING: content credentials of media %d in event %d failed validation: %v", media.ID, media.EventID, credentials.Problems)
+	}
+}
+
+// inspectCredentials reads the content credentials of a clean video or
+// audio file from storage
+func (s *MediaService) inspectCredentials(mediaID int) error {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		return err
+	}
+	if media.ScanStatus != models.ScanClean {
+		return nil
+	}
+
+	content, err := s.openContent(media)
+	if err != nil {
+		return err
+	}
+	report := s.readCredentials(content)
+	content.Close()
+	if report != nil {
+		s.saveCredentials(media, report)
+	}
+	return nil
+}
+
+// inspectable reports whether the content credentials of an upload are read
+// once it is clean. Redacted copies are made here and carry none.
+func inspectable(media *models.Media) bool {
+	return media.ParentID == nil && credentialTypes[media.MimeType]
+}
+
+// GetContentCredentials retrieves what a media item's content credentials
+// say: who signed them, the claimed capture device, the edit history and
+// whether they passed validation
+func (s *MediaService) GetContentCredentials(eventID, mediaID int) (*models.ContentCredentials, error) {
+	if _, err := s.findMedia(eventID, mediaID); err != nil {
+		return nil, err
+	}
+	credentials, err := s.mediaRepo.GetCredentials(mediaID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoCredentials
+	}
+	return credentials, err
+}
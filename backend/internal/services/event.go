@@ -2,23 +2,38 @@ package services
 
 import (
 	"errors"
+	"log"
 
 	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/realtime"
 	"github.com/protest-tracker/internal/repository"
 )
 
 type EventService struct {
 	eventRepo        *repository.EventRepository
 	subscriptionRepo *repository.SubscriptionRepository
+	timestamps       *TimestampService
+	feed             *realtime.Hub
 }
 
-func NewEventService(eventRepo *repository.EventRepository, subscriptionRepo *repository.SubscriptionRepository) *EventService {
+// NewEventService creates an event service. New events are timestamped by
+// timestamps, if not nil, and changes are published to feed.
+func NewEventService(eventRepo *repository.EventRepository, subscriptionRepo *repository.SubscriptionRepository, timestamps *TimestampService, feed *realtime.Hub) *EventService {
 	return &EventService{
 		eventRepo:        eventRepo,
 		subscriptionRepo: subscriptionRepo,
+		timestamps:       timestamps,
+		feed:             feed,
 	}
 }
 
+// Event statuses published when they change
+const (
+	EventStatusHeld     = "held"
+	EventStatusReleased = "released"
+	EventStatusDeleted  = "deleted"
+)
+
 // GetAllEvents retrieves all events
 func (s *EventService) GetAllEvents() ([]models.ArrestEvent, error) {
 	return s.eventRepo.GetAll()
@@ -36,7 +51,21 @@ func (s *EventService) CreateEvent(event *models.ArrestEvent) (int, error) {
 		return 0, errors.New("latitude and longitude are required")
 	}
 
-	return s.eventRepo.Create(event)
+	id, err := s.eventRepo.Create(event)
+	if err != nil {
+		return 0, err
+	}
+
+	// Timestamp and publish the event as stored, not as the client sent it
+	created, err := s.eventRepo.GetByID(id)
+	if err != nil {
+		log.Printf("Failed to load new event %d for timestamping: %v", id, err)
+		s.feed.Publish(realtime.EventCreated, id, nil, false)
+		return id, nil
+	}
+	s.timestamps.StampEvent(created)
+	s.feed.Publish(realtime.EventCreated, id, created, false)
+	return id, nil
 }
 
 // UpdateEvent updates an existing event
@@ -47,18 +76,84 @@ func (s *EventService) UpdateEvent(event *models.ArrestEvent) error {
 		return errors.New("event not found")
 	}
 
-	return s.eventRepo.Update(event)
+	if err = s.eventRepo.Update(event); err != nil {
+		return err
+	}
+
+	if updated, err := s.eventRepo.GetByID(event.ID); err == nil {
+		s.feed.Publish(realtime.EventUpdated, event.ID, updated, false)
+	} else {
+		s.feed.Publish(realtime.EventUpdated, event.ID, nil, false)
+	}
+	return nil
 }
 
-// DeleteEvent deletes an event
+// DeleteEvent deletes an event unless it or any of its media is under
+// legal hold. Its media must have been deleted and purged first.
 func (s *EventService) DeleteEvent(id int) error {
 	// Check if event exists
-	_, err := s.eventRepo.GetByID(id)
+	event, err := s.eventRepo.GetByID(id)
 	if err != nil {
 		return errors.New("event not found")
 	}
 
-	return s.eventRepo.Delete(id)
+	held, err := s.eventRepo.HasMediaOnHold(id)
+	if err != nil {
+		return err
+	}
+	if event.LegalHold || held {
+		return ErrEventLegalHold
+	}
+
+	// Media must go through soft deletion and purging first, so that its
+	// files are removed and the custody log records why
+	hasMedia, err := s.eventRepo.HasMedia(id)
+	if err != nil {
+		return err
+	}
+	if hasMedia {
+		return ErrEventHasMedia
+	}
+
+	deleted, err := s.eventRepo.Delete(id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		// Held, or given media, since the checks above
+		if hasMedia, err := s.eventRepo.HasMedia(id); err == nil && hasMedia {
+			return ErrEventHasMedia
+		}
+		return ErrEventLegalHold
+	}
+	s.publishStatus(id, EventStatusDeleted)
+	return nil
+}
+
+// SetLegalHold places or releases a legal hold on an event. While held,
+// none of the event's media can be deleted.
+func (s *EventService) SetLegalHold(id int, hold bool) (*models.ArrestEvent, error) {
+	event, err := s.eventRepo.GetByID(id)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	if err = s.eventRepo.SetLegalHold(id, hold); err != nil {
+		return nil, err
+	}
+
+	event.LegalHold = hold
+	if hold {
+		s.publishStatus(id, EventStatusHeld)
+	} else {
+		s.publishStatus(id, EventStatusReleased)
+	}
+	return event, nil
+}
+
+// publishStatus tells clients an event was held, released or deleted
+func (s *EventService) publishStatus(id int, status string) {
+	s.feed.Publish(realtime.EventStatusChanged, id, map[string]string{"status": status}, false)
 }
 
 // SubscribeToEvent subscribes a user to an event
@@ -69,7 +164,15 @@ func (s *EventService) SubscribeToEvent(eventID, userID int) error {
 		return errors.New("event not found")
 	}
 
-	return s.subscriptionRepo.Subscribe(eventID, userID)
+	added, err := s.subscriptionRepo.Subscribe(eventID, userID)
+	if err != nil {
+		return err
+	}
+	if added {
+		// Who the witnesses are is for advocates only
+		s.feed.Publish(realtime.SubscriptionCreated, eventID, map[string]int{"userId": userID}, true)
+	}
+	return nil
 }
 
 // UnsubscribeFromEvent unsubscribes a user from an event
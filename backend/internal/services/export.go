@@ -0,0 +1,461 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/repository"
+	"github.com/protest-tracker/internal/signing"
+)
+
+// ErrExportSigningKey is returned when exports are requested without a
+// signing key configured
+var ErrExportSigningKey = errors.New("export signing key is not configured")
+
+// ExportService builds evidence packages for lawyers: a ZIP holding an
+// event's records and media together with a signed manifest of hashes
+type ExportService struct {
+	eventRepo        *repository.EventRepository
+	subscriptionRepo *repository.SubscriptionRepository
+	statementRepo    *repository.StatementRepository
+	mediaRepo        *repository.MediaRepository
+	mediaService     *MediaService
+	timestamps       *TimestampService
+	signer           *signing.Signer
+}
+
+func NewExportService(eventRepo *repository.EventRepository, subscriptionRepo *repository.SubscriptionRepository, statementRepo *repository.StatementRepository, mediaRepo *repository.MediaRepository, mediaService *MediaService, timestamps *TimestampService, signer *signing.Signer) *ExportService {
+	return &ExportService{
+		eventRepo:        eventRepo,
+		subscriptionRepo: subscriptionRepo,
+		statementRepo:    statementRepo,
+		mediaRepo:        mediaRepo,
+		mediaService:     mediaService,
+		timestamps:       timestamps,
+		signer:           signer,
+	}
+}
+
+// PublicKeyPEM returns the key recipients use to verify export manifests
+func (s *ExportService) PublicKeyPEM() ([]byte, error) {
+	if s.signer == nil {
+		return nil, ErrExportSigningKey
+	}
+	return s.signer.PublicKeyPEM(), nil
+}
+
+// exportedMedia is a media record as listed in media.json
+type exportedMedia struct {
+	models.Media
+	Path     string                `json:"path"`
+	Metadata *models.MediaMetadata `json:"metadata,omitempty"`
+}
+
+// EventExport is an evidence package whose records have been gathered and
+// whose export has been recorded in the custody log. Media files are read
+// while the ZIP is written.
+type EventExport struct {
+	service     *ExportService
+	event       *models.ArrestEvent
+	media       []exportedMedia
+	custody     map[int][]models.CustodyEntry
+	subscribers []models.User
+	statements  []models.WitnessStatement
+	userID      int
+	generatedAt time.Time
+
+	// shared exports, made through a share link, leave out the event's
+	// subscribers and statements and where media were captured
+	shared bool
+
+	// RFC 3161 tokens by the path they are exported under, with the
+	// outcome of verifying each
+	timestamps     []models.TimestampCheck
+	timestampFiles map[string][]byte
+}
+
+// PrepareExport gathers everything needed to export an event and records
+// the export in each media item's custody log
+func (s *ExportService) PrepareExport(eventID, userID int) (*EventExport, error) {
+	return s.prepareExport(eventID, userID, "event export", false)
+}
+
+// prepareExport is PrepareExport with the details written to the custody
+// log. A shared export holds only what a share link may disclose.
+func (s *ExportService) prepareExport(eventID, userID int, details string, shared bool) (*EventExport, error) {
+	if s.signer == nil {
+		return nil, ErrExportSigningKey
+	}
+
+	event, err := s.eventRepo.GetByID(eventID)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	mediaList, err := s.mediaRepo.GetByEventID(eventID)
+	if err != nil {
+		return nil, err
+	}
+	withoutSource(mediaList)
+
+	export := &EventExport{
+		service:     s,
+		event:       event,
+		custody:     make(map[int][]models.CustodyEntry),
+		userID:      userID,
+		generatedAt: time.Now().UTC(),
+		shared:      shared,
+	}
+
+	if !shared {
+		export.subscribers, err = s.subscriptionRepo.GetSubscribersByEventID(eventID)
+		if err != nil {
+			return nil, err
+		}
+		// Witnesses' phone numbers and preferences stay on the platform
+		for i := range export.subscribers {
+			export.subscribers[i].PhoneNumber, export.subscribers[i].NotificationChannels = "", nil
+		}
+
+		export.statements, err = s.statementRepo.GetByEventID(eventID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range mediaList {
+		media := &mediaList[i]
+
+		// Record the export first so the exported log includes it
+		err = s.mediaService.recordCustody(media, userID, models.CustodyExport, details)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record custody: %v", err)
+		}
+
+		entries, err := s.mediaRepo.GetCustodyLog(media.ID)
+		if err != nil {
+			return nil, err
+		}
+		export.custody[media.ID] = entries
+
+		meta, err := s.mediaRepo.GetMetadata(media.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if meta != nil && shared {
+			meta.Latitude, meta.Longitude = nil, nil
+		}
+
+		extension := media.Extension
+		if extension == "" {
+			extension = filepath.Ext(media.FilePath)
+		}
+		export.media = append(export.media, exportedMedia{
+			Media:    *media,
+			Path:     fmt.Sprintf("media/%d%s", media.ID, extension),
+			Metadata: meta,
+		})
+	}
+
+	if err = export.addTimestamps(); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// addTimestamps gathers the timestamp tokens of the event and its media
+// and verifies each against what it should cover
+func (e *EventExport) addTimestamps() error {
+	e.timestampFiles = make(map[string][]byte)
+	check := func(subject string, id int, path string, token, digest []byte) models.TimestampCheck {
+		e.timestampFiles[path] = token
+		result := models.TimestampCheck{Subject: subject, ID: id, Path: path}
+		info, err := e.service.timestamps.Verify(token, digest)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Valid, result.Time, result.Authority = true, &info.Time, info.Authority
+		return result
+	}
+
+	if e.event.TimestampedAt != nil {
+		record, token, err := e.service.eventRepo.GetTimestamp(e.event.ID)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(record))
+		result := check("event", e.event.ID, "timestamps/event.tst", token, digest[:])
+		result.RecordPath = "timestamps/event.json"
+		var stamped eventRecord
+		if json.Unmarshal([]byte(record), &stamped) == nil {
+			result.RecordedLate = stamped.RecordedLate
+		}
+		e.timestampFiles[result.RecordPath] = []byte(record)
+		e.timestamps = append(e.timestamps, result)
+	}
+
+	for _, media := range e.media {
+		if media.TimestampedAt == nil {
+			continue
+		}
+		token, err := e.service.mediaRepo.GetTimestampToken(media.ID)
+		if err != nil {
+			return err
+		}
+		digest, err := hex.DecodeString(media.SHA256)
+		if err != nil {
+			return fmt.Errorf("media %d: invalid hash: %v", media.ID, err)
+		}
+		e.timestamps = append(e.timestamps, check("media", media.ID, fmt.Sprintf("timestamps/media/%d.tst", media.ID), token, digest))
+	}
+	return nil
+}
+
+// Filename is the suggested name of the ZIP file
+func (e *EventExport) Filename() string {
+	return fmt.Sprintf("event_%d_export_%s.zip", e.event.ID, e.generatedAt.Format("20060102T150405Z"))
+}
+
+// Write streams the ZIP to w. Every file is hashed as it is written; the
+// manifest of hashes is added last together with its signature and the
+// public key.
+func (e *EventExport) Write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	manifest := &models.ExportManifest{
+		EventID:     e.event.ID,
+		GeneratedAt: e.generatedAt,
+		GeneratedBy: e.userID,
+		Algorithm:   signing.Algorithm,
+		KeyID:       e.service.signer.KeyID(),
+		Timestamps:  e.timestamps,
+	}
+
+	add := func(name string, method uint16, src io.Reader) (string, error) {
+		file, err := e.create(zw, name, method)
+		if err != nil {
+			return "", err
+		}
+		hasher := sha256.New()
+		size, err := io.Copy(io.MultiWriter(file, hasher), src)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", name, err)
+		}
+		sum := hex.EncodeToString(hasher.Sum(nil))
+		manifest.Files = append(manifest.Files, models.ExportFile{Path: name, SHA256: sum, Size: size})
+		return sum, nil
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = add(name, zip.Deflate, bytes.NewReader(data))
+		return err
+	}
+
+	if err := addJSON("event.json", e.event); err != nil {
+		return err
+	}
+	if err := addJSON("media.json", e.media); err != nil {
+		return err
+	}
+	if !e.shared {
+		if err := addJSON("subscribers.json", e.subscribers); err != nil {
+			return err
+		}
+		if err := addJSON("statements.json", e.statements); err != nil {
+			return err
+		}
+	}
+	for _, media := range e.media {
+		if err := addJSON(fmt.Sprintf("custody/%d.json", media.ID), e.custody[media.ID]); err != nil {
+			return err
+		}
+	}
+	for _, stamp := range e.timestamps {
+		paths := []string{stamp.Path}
+		if stamp.RecordPath != "" {
+			paths = append(paths, stamp.RecordPath)
+		}
+		for _, path := range paths {
+			if _, err := add(path, zip.Deflate, bytes.NewReader(e.timestampFiles[path])); err != nil {
+				return err
+			}
+		}
+	}
+	if ca := e.service.timestamps.CACertificate(); len(e.timestamps) > 0 && ca != nil {
+		if _, err := add("tsa-ca.pem", zip.Deflate, bytes.NewReader(ca)); err != nil {
+			return err
+		}
+		manifest.TSACertificate = "tsa-ca.pem"
+	}
+
+	for _, media := range e.media {
+		content, err := e.service.mediaService.openContent(&media.Media)
+		if err != nil {
+			return fmt.Errorf("media %d: %v", media.ID, err)
+		}
+		// Photos and videos are already compressed
+		sum, err := add(media.Path, zip.Store, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+		if media.SHA256 != "" && sum != media.SHA256 {
+			manifest.Mismatched = append(manifest.Mismatched, media.ID)
+		}
+	}
+
+	var summary bytes.Buffer
+	if err := summaryTemplate.Execute(&summary, e.summaryData(manifest)); err != nil {
+		return err
+	}
+	if _, err := add("summary.html", zip.Deflate, &summary); err != nil {
+		return err
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", manifestData},
+		{"manifest.sig", e.service.signer.Sign(manifestData)},
+		{"signing-key.pem", e.service.signer.PublicKeyPEM()},
+	} {
+		out, err := e.create(zw, file.name, zip.Deflate)
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(file.data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (e *EventExport) create(zw *zip.Writer, name string, method uint16) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: e.generatedAt})
+}
+
+// summaryData is the view model for summary.html
+type summaryData struct {
+	Event       *models.ArrestEvent
+	Media       []exportedMedia
+	Subscribers int
+	Statements  []models.WitnessStatement
+	Manifest    *models.ExportManifest
+	Shared      bool
+}
+
+func (e *EventExport) summaryData(manifest *models.ExportManifest) summaryData {
+	return summaryData{
+		Event:       e.event,
+		Media:       e.media,
+		Subscribers: len(e.subscribers),
+		Statements:  e.statements,
+		Manifest:    manifest,
+		Shared:      e.shared,
+	}
+}
+
+var summaryTemplate = template.Must(template.New("summary").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Evidence package: event {{.Event.ID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+code { font-size: 0.85em; word-break: break-all; }
+.warning { color: #b00; font-weight: bold; }
+.statement { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Evidence package: event {{.Event.ID}}</h1>
+<p>Generated {{.Manifest.GeneratedAt.Format "2006-01-02 15:04:05 MST"}} by user {{.Manifest.GeneratedBy}}.</p>
+{{if .Shared}}<p>Exported through a share link. The event's witnesses, their statements and where media were captured are not included.</p>{{end}}
+
+<h2>Event</h2>
+<table>
+<tr><th>Time</th><td>{{.Event.Time.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Location</th><td>{{.Event.Latitude}}, {{.Event.Longitude}}</td></tr>
+<tr><th>Notes</th><td>{{.Event.Notes}}</td></tr>
+<tr><th>Reported by</th><td>user {{.Event.CreatedBy}}</td></tr>
+{{if not .Shared}}<tr><th>Subscribed witnesses</th><td>{{.Subscribers}}</td></tr>{{end}}
+</table>
+{{if not .Shared}}
+<h2>Witness statements</h2>
+{{range .Statements}}<h3>Statement {{.ID}}: {{with .UserID}}user {{.}}{{else}}deleted user{{end}}, {{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}</h3>
+<pre class="statement">{{.Statement}}</pre>
+{{else}}<p>No statements.</p>{{end}}
+{{end}}
+
+<h2>Media</h2>
+{{if .Manifest.Mismatched}}<p class="warning">The stored files of media {{range $i, $id := .Manifest.Mismatched}}{{if $i}}, {{end}}{{$id}}{{end}} no longer match the hash recorded at upload.</p>{{end}}
+{{if .Media}}
+<table>
+<tr><th>ID</th><th>File</th><th>Type</th><th>Size</th><th>Captured</th><th>Derived from</th><th>SHA-256 at upload</th></tr>
+{{range .Media}}<tr>
+<td>{{.ID}}</td>
+<td>{{.Path}}</td>
+<td>{{.MimeType}}</td>
+<td>{{.Size}}</td>
+<td>{{with .Metadata}}{{with .CapturedAt}}{{.Format "2006-01-02 15:04:05"}}{{end}}{{end}}</td>
+<td>{{with .ParentID}}{{.}} (redacted){{end}}</td>
+<td><code>{{.SHA256}}</code></td>
+</tr>
+{{end}}</table>
+{{else}}<p>No media.</p>{{end}}
+
+<h2>Trusted timestamps</h2>
+{{if .Manifest.Timestamps}}
+<table>
+<tr><th>Record</th><th>Token</th><th>Attested time</th><th>Authority</th><th>Check at export</th></tr>
+{{range .Manifest.Timestamps}}<tr>
+<td>{{.Subject}} {{.ID}}{{if .RecordedLate}} (recorded after it was reported){{end}}</td>
+<td>{{.Path}}</td>
+<td>{{with .Time}}{{.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+<td>{{.Authority}}</td>
+<td>{{if .Valid}}valid{{else}}<span class="warning">invalid: {{.Error}}</span>{{end}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>No timestamps.</p>{{end}}
+
+<h2>Verifying this package</h2>
+<p><code>manifest.json</code> lists the SHA-256 hash of every other file in this package.
+It is signed with the server's {{.Manifest.Algorithm}} key <code>{{.Manifest.KeyID}}</code>;
+the signature is in <code>manifest.sig</code> and the public key in <code>signing-key.pem</code>.
+Compare the key with the one published by the organisation, then run:</p>
+<pre>openssl pkeyutl -verify -pubin -inkey signing-key.pem -rawin -in manifest.json -sigfile manifest.sig
+sha256sum media/*</pre>
+<p>Each media file's custody log is in <code>custody/&lt;id&gt;.json</code>.</p>
+{{if .Manifest.Timestamps}}<p>The tokens in <code>timestamps/</code> are RFC 3161 timestamps from an independent authority.
+A media token covers the SHA-256 at upload; the event token covers <code>timestamps/event.json</code>, the event as it was created,
+or as it stood when first timestamped if <code>recordedLate</code> is set.
+{{if .Manifest.TSACertificate}}They were checked against the authority's CA certificate in <code>tsa-ca.pem</code>; compare it with the one the authority publishes, then run:
+{{else}}Save the authority's CA certificate as <code>tsa-ca.pem</code>, then run:{{end}}</p>
+<pre>openssl ts -verify -in timestamps/media/&lt;id&gt;.tst -token_in -digest &lt;SHA-256 at upload&gt; -CAfile tsa-ca.pem
+openssl ts -verify -in timestamps/event.tst -token_in -data timestamps/event.json -CAfile tsa-ca.pem</pre>{{end}}
+</body>
+</html>
+`))
@@ -1,120 +1,419 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"log"
 	"time"
 
+	"github.com/protest-tracker/internal/c2pa"
+	"github.com/protest-tracker/internal/encryption"
+	"github.com/protest-tracker/internal/jobs"
+	"github.com/protest-tracker/internal/mediatype"
+	"github.com/protest-tracker/internal/metadata"
 	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/realtime"
 	"github.com/protest-tracker/internal/repository"
+	"github.com/protest-tracker/internal/scanner"
+	"github.com/protest-tracker/internal/storage"
 )
 
 type MediaService struct {
 	mediaRepo *repository.MediaRepository
 	eventRepo *repository.EventRepository
-	mediaDir  string
+	storage   *storage.Registry
+	keyring   *encryption.Keyring
+	scanner   scanner.Scanner
+
+	// How long deleted media can be restored before the file is purged
+	deleteGrace time.Duration
+
+	provenance ProvenanceSettings
+	limits     UploadLimits
+
+	// Runs scans and other processing; nil until RegisterJobs
+	jobs *jobs.Queue
+
+	// Timestamps new media; nil when timestamping is not set up
+	timestamps *TimestampService
+
+	// Content credential signers are trusted if they chain to one of these
+	credentialRoots *x509.CertPool
+
+	// Tells advocates about new media; nil when nobody is listening
+	feed *realtime.Hub
 }
 
-func NewMediaService(mediaRepo *repository.MediaRepository, eventRepo *repository.EventRepository, mediaDir string) *MediaService {
+// NewMediaService creates a media service. When keyring is nil new files
+// are stored in plaintext. New media stay quarantined until scan passes
+// them, and are timestamped by timestamps if not nil. Content credentials
+// are only reported valid if their signer chains to credentialRoots. New
+// media are published to feed, if not nil.
+func NewMediaService(mediaRepo *repository.MediaRepository, eventRepo *repository.EventRepository, store *storage.Registry, keyring *encryption.Keyring, scan scanner.Scanner, deleteGrace time.Duration, provenance ProvenanceSettings, limits UploadLimits, timestamps *TimestampService, credentialRoots *x509.CertPool, feed *realtime.Hub) *MediaService {
 	return &MediaService{
-		mediaRepo: mediaRepo,
-		eventRepo: eventRepo,
-		mediaDir:  mediaDir,
+		mediaRepo:       mediaRepo,
+		eventRepo:       eventRepo,
+		storage:         store,
+		keyring:         keyring,
+		scanner:         scan,
+		deleteGrace:     deleteGrace,
+		provenance:      provenance,
+		limits:          limits,
+		timestamps:      timestamps,
+		credentialRoots: credentialRoots,
+		feed:            feed,
 	}
 }
 
-// GetEventMedia retrieves all media for an event
-func (s *MediaService) GetEventMedia(eventID int) ([]models.Media, error) {
+// CheckEvent returns an error unless the event exists
+func (s *MediaService) CheckEvent(eventID int) error {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return errors.New("event not found")
+	}
+	return nil
+}
+
+// GetEventMedia retrieves the media of an event matching filter
+func (s *MediaService) GetEventMedia(eventID int, filter *models.MediaFilter) ([]models.Media, error) {
 	// Check if event exists
 	_, err := s.eventRepo.GetByID(eventID)
 	if err != nil {
 		return nil, errors.New("event not found")
 	}
 
-	return s.mediaRepo.GetByEventID(eventID)
+	mediaList, err := s.mediaRepo.SearchByEvent(eventID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnails, err := s.mediaRepo.GetThumbnailSizesByEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range mediaList {
+		mediaList[i].Thumbnails = thumbnails[mediaList[i].ID]
+	}
+
+	return mediaList, nil
 }
 
-// UploadMedia saves a media file and creates a database record
-func (s *MediaService) UploadMedia(eventID int, file io.Reader, filename, mediaType string) error {
+// UploadMedia saves a media file and creates a database record. The real
+// file type is detected from its content and must be allowed for mediaType.
+// Client-supplied filenames are never used. If the event already has media
+// with identical content, no record is created: the existing one is
+// returned and the reported duplicate is true. source is stored subject to
+// the provenance settings.
+func (s *MediaService) UploadMedia(eventID, userID int, file io.Reader, mediaType string, source *models.UploadSource) (*models.Media, bool, error) {
 	// Check if event exists
 	_, err := s.eventRepo.GetByID(eventID)
 	if err != nil {
-		return errors.New("event not found")
+		return nil, false, errors.New("event not found")
 	}
 
 	// Validate media type
-	if mediaType != "photo" && mediaType != "video" {
-		return errors.New("invalid media type")
+	if !mediatype.Valid(mediaType) {
+		return nil, false, errors.New("invalid media type")
+	}
+
+	// The size is not known until the file is read; refuse early if a
+	// quota is used up already
+	if err = s.checkQuota(eventID, userID, 0); err != nil {
+		return nil, false, err
 	}
 
-	// Create event directory
-	eventDir := filepath.Join(s.mediaDir, fmt.Sprintf("event_%d", eventID))
-	err = os.MkdirAll(eventDir, 0755)
+	// Detect the real type from the leading bytes
+	buffered := bufio.NewReaderSize(file, mediatype.SniffLen)
+	head, err := buffered.Peek(mediatype.SniffLen)
+	if err != nil && err != io.EOF {
+		return nil, false, fmt.Errorf("failed to read file: %v", err)
+	}
+	detected, err := mediatype.Validate(mediaType, head)
 	if err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+		return nil, false, err
 	}
+	limited := &sizeLimiter{r: buffered, remaining: s.limits.maxSize(mediaType)}
+	file = limited
 
-	// Generate unique filename
-	uniqueFilename := fmt.Sprintf("%d_%s", time.Now().Unix(), filename)
-	filePath := filepath.Join(eventDir, uniqueFilename)
+	// Strip EXIF/XMP/IPTC from photos before anything touches the disk.
+	// Their content credentials go with it, so are read first.
+	var captured *metadata.Info
+	var credentials *c2pa.Report
+	if mediaType == "photo" {
+		data, err := io.ReadAll(file)
+		if limited.exceeded {
+			return nil, false, mediatype.ErrTooLarge
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read file: %v", err)
+		}
+		credentials = s.readCredentials(bytes.NewReader(data))
+		cleaned, info, err := metadata.Strip(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to sanitize photo: %v", err)
+		}
+		file = bytes.NewReader(cleaned)
+		captured = info
+	}
 
-	// Create file
-	dst, err := os.Create(filePath)
+	stored, err := s.storeBlob(file)
+	if limited.exceeded {
+		return nil, false, mediatype.ErrTooLarge
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return nil, false, err
 	}
-	defer dst.Close()
 
-	// Copy file content
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		return fmt.Errorf("failed to save file: %v", err)
+	// The same file attached to the same event twice is reported rather
+	// than stored again; the repeat upload is still noted in the custody log
+	existing, err := s.mediaRepo.FindDuplicate(eventID, stored.SHA256)
+	if err == nil {
+		return s.duplicateUpload(existing, userID, stored), true, nil
+	}
+	if err != sql.ErrNoRows {
+		s.releaseFile(stored.SHA256, stored.Ref)
+		return nil, false, err
+	}
+
+	if err = s.checkQuota(eventID, userID, stored.Size); err != nil {
+		s.releaseFile(stored.SHA256, stored.Ref)
+		return nil, false, err
 	}
 
 	// Save to database
 	media := &models.Media{
-		EventID:  eventID,
-		FilePath: filePath,
-		Type:     mediaType,
+		EventID:    eventID,
+		FilePath:   stored.Ref,
+		Type:       mediaType,
+		SHA256:     stored.SHA256,
+		KeyID:      stored.KeyID,
+		WrappedKey: stored.WrappedKey,
+		MimeType:   detected.MimeType,
+		Size:       stored.Size,
+		Extension:  detected.Extension,
+		ScanStatus: models.ScanPending,
+		UploadedBy: userID,
+		UploadedAt: time.Now().UTC(),
+
+		// Scanning, probing and previewing run in the background
+		ProcessingStatus: models.ProcessingQueued,
 	}
 
+	source = s.filterSource(source)
+	media.UploadIP, media.DeviceFingerprint = source.IP, source.DeviceFingerprint
+	var exifTime *time.Time
+	if captured != nil {
+		exifTime = captured.CapturedAt
+	}
+	setCaptureTime(media, exifTime, source)
+
 	err = s.mediaRepo.Create(media)
+	if err == repository.ErrDuplicateMedia {
+		// A concurrent upload of the same file was saved first
+		existing, findErr := s.mediaRepo.FindDuplicate(eventID, stored.SHA256)
+		if findErr == nil {
+			return s.duplicateUpload(existing, userID, stored), true, nil
+		}
+	}
 	if err != nil {
 		// Clean up file if database save fails
-		os.Remove(filePath)
-		return fmt.Errorf("failed to save media record: %v", err)
+		s.releaseFile(stored.SHA256, stored.Ref)
+		return nil, false, fmt.Errorf("failed to save media record: %v", err)
 	}
 
-	return nil
+	if captured != nil && (captured.CapturedAt != nil || captured.Latitude != nil) {
+		err = s.mediaRepo.SaveMetadata(&models.MediaMetadata{
+			MediaID:    media.ID,
+			CapturedAt: captured.CapturedAt,
+			Latitude:   captured.Latitude,
+			Longitude:  captured.Longitude,
+		})
+		if err != nil {
+			log.Printf("Failed to save metadata for media %d: %v", media.ID, err)
+		}
+	}
+
+	if credentials != nil {
+		s.saveCredentials(media, credentials)
+	}
+
+	if err = s.recordCustody(media, userID, models.CustodyUpload, "sha256="+media.SHA256); err != nil {
+		log.Printf("Failed to record upload of media %d: %v", media.ID, err)
+	}
+
+	s.enqueue(JobScanMedia, media)
+	s.timestamps.StampMedia(media)
+	s.feed.Publish(realtime.MediaCreated, eventID, media, true)
+
+	return media, false, nil
 }
 
-// DeleteMedia deletes a media file and its database record
-func (s *MediaService) DeleteMedia(mediaID int) error {
-	// Get media record
-	media, err := s.mediaRepo.GetByID(mediaID)
+// GetMedia retrieves a media record belonging to an event. Deleted media
+// are only listed by GetDeletedMedia.
+func (s *MediaService) GetMedia(eventID, mediaID int) (*models.Media, error) {
+	return s.findMedia(eventID, mediaID)
+}
+
+// GetMediaMetadata retrieves the capture metadata extracted from a photo
+func (s *MediaService) GetMediaMetadata(eventID, mediaID, userID int) (*models.MediaMetadata, error) {
+	media, err := s.findMedia(eventID, mediaID)
 	if err != nil {
-		return errors.New("media not found")
+		return nil, err
 	}
 
-	// Delete file
-	err = os.Remove(media.FilePath)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete file: %v", err)
+	err = s.recordCustody(media, userID, models.CustodyAccess, "metadata")
+	if err != nil {
+		return nil, fmt.Errorf("failed to record custody: %v", err)
 	}
 
-	// Delete database record
-	err = s.mediaRepo.Delete(mediaID)
+	meta, err := s.mediaRepo.GetMetadata(mediaID)
+	if err == sql.ErrNoRows {
+		return &models.MediaMetadata{MediaID: mediaID}, nil
+	}
+	return meta, err
+}
+
+// OpenMedia opens the stored file for a media record belonging to an event,
+// decrypting it on the fly if needed. Quarantined media are refused.
+// Nothing is logged; the caller records the download with RecordDownload
+// once it knows content is being served, and is responsible for closing
+// the content.
+func (s *MediaService) OpenMedia(eventID, mediaID int) (*models.Media, *MediaContent, error) {
+	media, err := s.findCleanMedia(eventID, mediaID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.openContent(media)
 	if err != nil {
-		return fmt.Errorf("failed to delete media record: %v", err)
+		return nil, nil, err
 	}
 
+	return media, content, nil
+}
+
+// downloadWindow is how long repeated requests by the same user for the
+// same media, such as Range requests while scrubbing a video, count as one
+// download in the custody log
+const downloadWindow = time.Minute
+
+// RecordDownload writes a download of media by a user to the custody log.
+// Requests within downloadWindow of a logged download by the same user
+// are folded into it.
+func (s *MediaService) RecordDownload(media *models.Media, userID int) error {
+	_, err := s.mediaRepo.AppendCustodyOnce(&models.CustodyEntry{
+		MediaID: media.ID,
+		EventID: media.EventID,
+		UserID:  userID,
+		Action:  models.CustodyDownload,
+	}, downloadWindow)
+	if err != nil {
+		return fmt.Errorf("failed to record custody: %v", err)
+	}
 	return nil
 }
 
-// GetMediaByID retrieves a media record by ID
-func (s *MediaService) GetMediaByID(id int) (*models.Media, error) {
-	return s.mediaRepo.GetByID(id)
+// VerifyMedia re-hashes a stored file and compares it with the hash taken
+// at upload. The outcome is recorded in the custody log.
+func (s *MediaService) VerifyMedia(eventID, mediaID, userID int) (*models.VerifyResult, error) {
+	media, err := s.findMedia(eventID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if media.SHA256 == "" {
+		return nil, errors.New("no hash recorded for this media")
+	}
+
+	content, err := s.openContent(media)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, content); err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	result := &models.VerifyResult{
+		MediaID:  media.ID,
+		Expected: media.SHA256,
+		Actual:   hex.EncodeToString(hasher.Sum(nil)),
+	}
+	result.Match = result.Expected == result.Actual
+
+	err = s.recordCustody(media, userID, models.CustodyVerify, fmt.Sprintf("match=%t sha256=%s", result.Match, result.Actual))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record custody: %v", err)
+	}
+
+	return result, nil
+}
+
+// GetCustodyLog retrieves the chain-of-custody entries for a media item
+func (s *MediaService) GetCustodyLog(eventID, mediaID int) ([]models.CustodyEntry, error) {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil || media.EventID != eventID {
+		return nil, errors.New("media not found")
+	}
+
+	return s.mediaRepo.GetCustodyLog(mediaID)
+}
+
+// findMedia retrieves a media record belonging to an event, treating
+// deleted media as missing
+func (s *MediaService) findMedia(eventID, mediaID int) (*models.Media, error) {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil || media.EventID != eventID || media.DeletedAt != nil {
+		return nil, errors.New("media not found")
+	}
+	return media, nil
+}
+
+// duplicateUpload releases a newly stored file that repeats an existing
+// upload to the same event and notes the repeat in the existing record's
+// custody log
+func (s *MediaService) duplicateUpload(existing *models.Media, userID int, stored *storedFile) *models.Media {
+	if err := s.releaseFile(stored.SHA256, stored.Ref); err != nil {
+		log.Printf("Failed to release duplicate of media %d: %v", existing.ID, err)
+	}
+	if err := s.recordCustody(existing, userID, models.CustodyUpload, "duplicate sha256="+stored.SHA256); err != nil {
+		log.Printf("Failed to record duplicate upload of media %d: %v", existing.ID, err)
+	}
+	return existing
+}
+
+// recordCustody appends an entry for media to the custody log
+func (s *MediaService) recordCustody(media *models.Media, userID int, action, details string) error {
+	return s.mediaRepo.AppendCustody(&models.CustodyEntry{
+		MediaID: media.ID,
+		EventID: media.EventID,
+		UserID:  userID,
+		Action:  action,
+		Details: details,
+	})
+}
+
+// sizeLimiter fails reads once more than remaining bytes have been read,
+// so an oversized upload is abandoned as soon as it crosses the limit
+type sizeLimiter struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded = true
+		return n, mediatype.ErrTooLarge
+	}
+	return n, err
 }
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/protest-tracker/internal/encryption"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/storage"
+)
+
+// MediaContent is a seekable view of a stored file's plaintext
+type MediaContent struct {
+	io.ReadSeeker
+	Size    int64
+	ModTime time.Time
+	object  storage.Object
+}
+
+// Close closes the underlying object
+func (c *MediaContent) Close() error {
+	return c.object.Close()
+}
+
+// storedFile describes a file written by storeFile or storeBlob
+type storedFile struct {
+	Ref        string
+	SHA256     string
+	Size       int64
+	KeyID      string
+	WrappedKey string
+}
+
+// storeFile streams src to the primary storage backend under key. The
+// plaintext is hashed on the way through and, when a master key is
+// configured, encrypted with a fresh data key.
+func (s *MediaService) storeFile(key string, src io.Reader) (*storedFile, error) {
+	stored := &storedFile{}
+
+	dataKey, err := s.newDataKey(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		stored.Size, stored.SHA256, err = writeEncrypted(pw, src, dataKey)
+		pw.CloseWithError(err)
+	}()
+
+	ref, err := s.storage.Put(key, pr)
+	// Unblock the writer if the backend stopped reading early
+	pr.Close()
+	<-done
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file: %v", err)
+	}
+
+	stored.Ref = ref
+	return stored, nil
+}
+
+// newDataKey generates a data key and records its sealed form in stored. It
+// returns nil when no master key is configured.
+func (s *MediaService) newDataKey(stored *storedFile) ([]byte, error) {
+	if s.keyring == nil {
+		return nil, nil
+	}
+	dataKey, keyID, wrapped, err := s.keyring.NewDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up encryption: %v", err)
+	}
+	stored.KeyID, stored.WrappedKey = keyID, wrapped
+	return dataKey, nil
+}
+
+// writeEncrypted copies src to w, encrypting it when dataKey is set, and
+// returns the size and hex SHA-256 of the plaintext
+func writeEncrypted(w io.Writer, src io.Reader, dataKey []byte) (int64, string, error) {
+	hasher := sha256.New()
+	if dataKey == nil {
+		size, err := io.Copy(io.MultiWriter(w, hasher), src)
+		return size, hex.EncodeToString(hasher.Sum(nil)), err
+	}
+
+	enc, err := encryption.NewWriter(w, dataKey)
+	if err != nil {
+		return 0, "", err
+	}
+	size, err := io.Copy(io.MultiWriter(enc, hasher), src)
+	if err == nil {
+		err = enc.Close()
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), err
+}
+
+// openContent opens the stored file for media and returns its plaintext,
+// decrypting on the fly if the file is encrypted
+func (s *MediaService) openContent(media *models.Media) (*MediaContent, error) {
+	return s.openStored(media.FilePath, media.KeyID, media.WrappedKey)
+}
+
+// openStored opens the file behind ref, decrypting it with the data key
+// sealed in wrappedKey when keyID is set
+func (s *MediaService) openStored(ref, keyID, wrappedKey string) (*MediaContent, error) {
+	object, err := s.storage.Open(ref)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, errors.New("media file missing")
+		}
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+
+	content := &MediaContent{ReadSeeker: object, Size: object.Size(), ModTime: object.ModTime(), object: object}
+	if keyID == "" {
+		return content, nil
+	}
+
+	if s.keyring == nil {
+		object.Close()
+		return nil, errors.New("media is encrypted but no master key is configured")
+	}
+	dataKey, err := s.keyring.Unwrap(keyID, wrappedKey)
+	if err != nil {
+		object.Close()
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	reader, err := encryption.NewReader(object, object.Size(), dataKey)
+	if err != nil {
+		object.Close()
+		return nil, fmt.Errorf("failed to decrypt file: %v", err)
+	}
+
+	content.ReadSeeker = reader
+	content.Size = reader.Size()
+	return content, nil
+}
+
+// RotateKeys re-wraps every data key sealed by a retired master key with
+// the current one. File contents are not re-encrypted. It returns the number
+// of media, blob and thumbnail records updated.
+func (s *MediaService) RotateKeys() (int, error) {
+	if s.keyring == nil {
+		return 0, errors.New("no master key configured")
+	}
+
+	stale, err := s.mediaRepo.GetWithStaleKeys(s.keyring.CurrentID())
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, media := range stale {
+		dataKey, err := s.keyring.Unwrap(media.KeyID, media.WrappedKey)
+		if err != nil {
+			return rotated, fmt.Errorf("media %d: %v", media.ID, err)
+		}
+		keyID, wrapped, err := s.keyring.Wrap(dataKey)
+		if err != nil {
+			return rotated, fmt.Errorf("media %d: %v", media.ID, err)
+		}
+		if err = s.mediaRepo.UpdateWrappedKey(media.ID, keyID, wrapped); err != nil {
+			return rotated, fmt.Errorf("media %d: %v", media.ID, err)
+		}
+		rotated++
+	}
+
+	staleBlobs, err := s.mediaRepo.GetBlobsWithStaleKeys(s.keyring.CurrentID())
+	if err != nil {
+		return rotated, err
+	}
+	for _, blob := range staleBlobs {
+		dataKey, err := s.keyring.Unwrap(blob.KeyID, blob.WrappedKey)
+		if err != nil {
+			return rotated, fmt.Errorf("blob %s: %v", blob.SHA256, err)
+		}
+		keyID, wrapped, err := s.keyring.Wrap(dataKey)
+		if err != nil {
+			return rotated, fmt.Errorf("blob %s: %v", blob.SHA256, err)
+		}
+		if err = s.mediaRepo.UpdateBlobKey(blob.SHA256, keyID, wrapped); err != nil {
+			return rotated, fmt.Errorf("blob %s: %v", blob.SHA256, err)
+		}
+		rotated++
+	}
+
+	staleThumbs, err := s.mediaRepo.GetThumbnailsWithStaleKeys(s.keyring.CurrentID())
+	if err != nil {
+		return rotated, err
+	}
+	for _, thumb := range staleThumbs {
+		dataKey, err := s.keyring.Unwrap(thumb.KeyID, thumb.WrappedKey)
+		if err != nil {
+			return rotated, fmt.Errorf("media %d %s thumbnail: %v", thumb.MediaID, thumb.Size, err)
+		}
+		keyID, wrapped, err := s.keyring.Wrap(dataKey)
+		if err != nil {
+			return rotated, fmt.Errorf("media %d %s thumbnail: %v", thumb.MediaID, thumb.Size, err)
+		}
+		if err = s.mediaRepo.UpdateThumbnailKey(thumb.MediaID, thumb.Size, keyID, wrapped); err != nil {
+			return rotated, fmt.Errorf("media %d %s thumbnail: %v", thumb.MediaID, thumb.Size, err)
+		}
+		rotated++
+	}
+
+	return rotated, nil
+}
+
+// MigrateStorage copies every file held by the from backend to the to
+// backend, repoints the records using it and removes the original.
+// Stored bytes are copied as-is, so encrypted files stay encrypted. Files
+// written before storage backends existed count as "local". It returns the
+// number of files moved.
+func (s *MediaService) MigrateStorage(from, to string) (int, error) {
+	target, ok := s.storage.Store(to)
+	if !ok {
+		return 0, fmt.Errorf("storage backend %q is not configured", to)
+	}
+	if from == to {
+		return 0, errors.New("source and target backends are the same")
+	}
+
+	mediaList, err := s.mediaRepo.GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	// Media sharing a content-addressed file are repointed together
+	done := make(map[string]bool)
+	moved := 0
+	for _, media := range mediaList {
+		if done[media.FilePath] {
+			continue
+		}
+		done[media.FilePath] = true
+
+		backend, key := storage.ParseRef(media.FilePath)
+		if backend == "" {
+			backend = "local"
+			key = fmt.Sprintf("event_%d/%s", media.EventID, filepath.Base(key))
+		}
+		if backend != from {
+			continue
+		}
+
+		newRef, err := s.copyObject(media.FilePath, target, key)
+		if err != nil {
+			return moved, fmt.Errorf("media %d: %v", media.ID, err)
+		}
+		if err = s.mediaRepo.RepointFile(media.FilePath, newRef); err != nil {
+			return moved, fmt.Errorf("media %d: %v", media.ID, err)
+		}
+		if err = s.storage.Delete(media.FilePath); err != nil {
+			return moved, fmt.Errorf("media %d: failed to remove original: %v", media.ID, err)
+		}
+		moved++
+	}
+
+	thumbnails, err := s.mediaRepo.GetAllThumbnails()
+	if err != nil {
+		return moved, err
+	}
+	for _, thumb := range thumbnails {
+		backend, key := storage.ParseRef(thumb.FilePath)
+		if backend != from {
+			continue
+		}
+
+		newRef, err := s.copyObject(thumb.FilePath, target, key)
+		if err != nil {
+			return moved, fmt.Errorf("media %d %s thumbnail: %v", thumb.MediaID, thumb.Size, err)
+		}
+		if err = s.mediaRepo.UpdateThumbnailPath(thumb.MediaID, thumb.Size, newRef); err != nil {
+			return moved, fmt.Errorf("media %d %s thumbnail: %v", thumb.MediaID, thumb.Size, err)
+		}
+		if err = s.storage.Delete(thumb.FilePath); err != nil {
+			return moved, fmt.Errorf("media %d %s thumbnail: failed to remove original: %v", thumb.MediaID, thumb.Size, err)
+		}
+		moved++
+	}
+
+	return moved, nil
+}
+
+// copyObject copies the stored bytes behind ref to key in target
+func (s *MediaService) copyObject(ref string, target storage.BlobStore, key string) (string, error) {
+	object, err := s.storage.Open(ref)
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	if err = target.Put(key, object); err != nil {
+		return "", err
+	}
+	return storage.Ref(target, key), nil
+}
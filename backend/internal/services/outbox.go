@@ -0,0 +1,92 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/protest-tracker/internal/jobs"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/notify"
+)
+
+const (
+	// dispatchPoll is how often an idle dispatcher looks for retries that
+	// have come due, or messages queued by another process
+	dispatchPoll = 5 * time.Second
+
+	// dispatchLease is how long a delivery may stay claimed before it is
+	// presumed abandoned by a crashed dispatcher and claimed again
+	dispatchLease = 5 * time.Minute
+
+	// maxDeliveryAttempts bounds the tries at each delivery. With the job
+	// queue's backoff, failing deliveries are retried for two to three
+	// hours.
+	maxDeliveryAttempts = 10
+)
+
+// RunDispatcher sends the messages in the notification outbox with workers
+// goroutines. Failed deliveries are retried with exponential backoff until
+// they bounce or run out of attempts. It does not return.
+func (s *WitnessService) RunDispatcher(workers int) {
+	for i := 1; i < workers; i++ {
+		go s.dispatch()
+	}
+	s.dispatch()
+}
+
+// dispatch sends due messages until there are none, then waits to be woken
+// by ContactWitnesses or for the next poll
+func (s *WitnessService) dispatch() {
+	for {
+		now := time.Now().UTC()
+		msg, err := s.contactRepo.Claim(now, now.Add(-dispatchLease))
+		if err == nil {
+			s.deliver(msg)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to claim notification: %v", err)
+		}
+
+		select {
+		case <-s.wake:
+		case <-time.After(dispatchPoll):
+		}
+	}
+}
+
+// deliver makes one attempt at sending a claimed message and records the
+// outcome
+func (s *WitnessService) deliver(msg *models.OutboxMessage) {
+	var err error
+	if notifier, ok := s.notifiers[msg.Channel]; ok {
+		err = notifier.Send(
+			notify.Recipient{UserID: msg.UserID, Email: msg.Email, Phone: msg.Phone},
+			notify.Message{ID: msg.ID, EventID: msg.EventID, Subject: msg.Subject, Body: msg.Message},
+		)
+	} else {
+		// Left over from a run with other channels configured
+		err = fmt.Errorf("%s notifications are not configured", msg.Channel)
+	}
+	now := time.Now().UTC()
+
+	switch {
+	case err == nil:
+		err = s.contactRepo.MarkSent(msg.ID, now)
+	case errors.Is(err, notify.ErrBounced):
+		log.Printf("Notification %d to user %d by %s bounced: %v", msg.ID, msg.UserID, msg.Channel, err)
+		err = s.contactRepo.Finish(msg.ID, models.DeliveryBounced, now, err.Error())
+	case errors.Is(err, notify.ErrNoAddress) || msg.Attempts >= maxDeliveryAttempts:
+		log.Printf("Notification %d to user %d by %s failed for good after %d attempts: %v", msg.ID, msg.UserID, msg.Channel, msg.Attempts, err)
+		err = s.contactRepo.Finish(msg.ID, models.DeliveryFailed, now, err.Error())
+	default:
+		log.Printf("Notification %d to user %d by %s failed, attempt %d of %d: %v", msg.ID, msg.UserID, msg.Channel, msg.Attempts, maxDeliveryAttempts, err)
+		err = s.contactRepo.Retry(msg.ID, now.Add(jobs.Backoff(msg.Attempts)), now, err.Error())
+	}
+	if err != nil {
+		log.Printf("Failed to record outcome of notification %d: %v", msg.ID, err)
+	}
+}
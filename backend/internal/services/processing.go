@@ -0,0 +1,123 @@
+package services
+
+import (
+	"log"
+
+	"github.com/protest-tracker/internal/jobs"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/probe"
+)
+
+// Background jobs run for each media item. A new upload is scanned first;
+// once clean it is probed and, if it can be, previewed and inspected for
+// content credentials.
+const (
+	JobScanMedia    = "scan_media"
+	JobProbeMedia   = "probe_media"
+	JobPreviewMedia = "preview_media"
+)
+
+// RegisterJobs makes queue run the media processing jobs. Without it, as
+// in the maintenance commands, no processing is scheduled.
+func (s *MediaService) RegisterJobs(queue *jobs.Queue) {
+	s.jobs = queue
+	queue.Register(JobScanMedia, 5, mediaJob(s.scanMedia))
+	queue.Register(JobProbeMedia, 3, mediaJob(s.probeMedia))
+	queue.Register(JobPreviewMedia, 3, mediaJob(s.processMedia))
+	queue.Register(JobInspectCredentials, 3, mediaJob(s.inspectCredentials))
+}
+
+func mediaJob(run func(mediaID int) error) jobs.Handler {
+	return func(job *models.Job) error {
+		return run(job.MediaID)
+	}
+}
+
+// QueueBacklog schedules processing for media stored before the job queue
+// existed, or whose job could not be enqueued: media still waiting for a
+// scan, and photos and documents without thumbnails.
+func (s *MediaService) QueueBacklog() {
+	unscanned, err := s.mediaRepo.GetUnscanned()
+	if err != nil {
+		log.Printf("Failed to find unscanned media: %v", err)
+	}
+	for _, mediaID := range unscanned {
+		if err = s.jobs.EnqueueOnce(JobScanMedia, mediaID); err != nil {
+			log.Printf("Failed to queue scan of media %d: %v", mediaID, err)
+		}
+	}
+
+	types := append([]string{documentPreviewType}, thumbnailTypes...)
+	unprocessed, err := s.mediaRepo.GetUnprocessedMedia(len(ThumbnailSizes), types)
+	if err != nil {
+		log.Printf("Failed to find unprocessed media: %v", err)
+	}
+	for _, mediaID := range unprocessed {
+		if err = s.jobs.EnqueueOnce(JobPreviewMedia, mediaID); err != nil {
+			log.Printf("Failed to queue preview of media %d: %v", mediaID, err)
+		}
+	}
+}
+
+// GetMediaJobs lists the processing jobs run for a media item
+func (s *MediaService) GetMediaJobs(eventID, mediaID int) ([]models.Job, error) {
+	if _, err := s.findMedia(eventID, mediaID); err != nil {
+		return nil, err
+	}
+	if s.jobs == nil {
+		return nil, nil
+	}
+	return s.jobs.GetMediaJobs(mediaID)
+}
+
+// enqueue schedules a processing job for a media item. A failure is only
+// logged: the media is stored, and QueueBacklog catches up on the next
+// start.
+func (s *MediaService) enqueue(jobType string, media *models.Media) {
+	if s.jobs == nil {
+		return
+	}
+	if err := s.jobs.Enqueue(jobType, media.ID, nil); err != nil {
+		log.Printf("Failed to queue %s for media %d: %v", jobType, media.ID, err)
+	}
+}
+
+// previewable reports whether thumbnails can be made for a media item
+func previewable(media *models.Media) bool {
+	return media.Type == "photo" && thumbnailable(media.MimeType) ||
+		media.Type == "document" && media.MimeType == documentPreviewType
+}
+
+// probeMedia records a clean media item's dimensions and duration. A
+// creation time in a video or audio container replaces a capture time the
+// client claimed, but not one read from EXIF. Files the prober cannot make
+// sense of are left undescribed rather than retried.
+func (s *MediaService) probeMedia(mediaID int) error {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		return err
+	}
+	if media.ScanStatus != models.ScanClean {
+		return nil
+	}
+
+	content, err := s.openContent(media)
+	if err != nil {
+		return err
+	}
+	info, err := probe.Probe(content, media.MimeType)
+	content.Close()
+	if err == probe.ErrUnsupported {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to read properties of %s media %d: %v", media.MimeType, media.ID, err)
+		return nil
+	}
+
+	capturedAt, captureSource := media.CapturedAt, media.CaptureSource
+	if info.CreatedAt != nil && captureSource != models.CaptureSourceEXIF {
+		capturedAt, captureSource = info.CreatedAt, models.CaptureSourceContainer
+	}
+	return s.mediaRepo.SetProperties(media.ID, info.Width, info.Height, info.Duration, capturedAt, captureSource)
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+// ProvenanceSettings controls which identifying details of an upload's
+// origin are stored. Both are off by default: spotters may be at risk if
+// their network address or device can be tied to the evidence.
+type ProvenanceSettings struct {
+	RecordIP     bool
+	RecordDevice bool
+}
+
+// maxClientClockSkew is how far in the future a client-supplied capture
+// time may be before it is ignored
+const maxClientClockSkew = 24 * time.Hour
+
+// filterSource applies the provenance settings to what a client sent.
+// Device identifiers are hashed so the raw value is never stored.
+func (s *MediaService) filterSource(source *models.UploadSource) *models.UploadSource {
+	filtered := &models.UploadSource{}
+	if source == nil {
+		return filtered
+	}
+
+	if source.CapturedAt != nil && source.CapturedAt.Before(time.Now().Add(maxClientClockSkew)) {
+		capturedAt := source.CapturedAt.UTC()
+		filtered.CapturedAt = &capturedAt
+	}
+	if s.provenance.RecordIP {
+		filtered.IP = source.IP
+	}
+	if s.provenance.RecordDevice && source.DeviceFingerprint != "" {
+		filtered.DeviceFingerprint = deviceFingerprint(source.DeviceFingerprint)
+	}
+	return filtered
+}
+
+// deviceFingerprint hashes a client's device identifier. Values that are
+// already fingerprints, as on resumed uploads, are kept.
+func deviceFingerprint(device string) string {
+	if len(device) == 32 {
+		if _, err := hex.DecodeString(device); err == nil {
+			return device
+		}
+	}
+	sum := sha256.Sum256([]byte(device))
+	return hex.EncodeToString(sum[:16])
+}
+
+// setCaptureTime fills in a new media record's capture time from the
+// photo's EXIF or, failing that, the client. A video or audio container's
+// own time is read later, when the clean file is probed.
+func setCaptureTime(media *models.Media, exifTime *time.Time, source *models.UploadSource) {
+	switch {
+	case exifTime != nil:
+		media.CapturedAt, media.CaptureSource = exifTime, models.CaptureSourceEXIF
+	case source.CapturedAt != nil:
+		media.CapturedAt, media.CaptureSource = source.CapturedAt, models.CaptureSourceClient
+	}
+}
+
+// withoutSource clears the privacy-sensitive provenance of media leaving
+// the platform, in exports and through share links
+func withoutSource(mediaList []models.Media) {
+	for i := range mediaList {
+		mediaList[i].UploadIP = ""
+		mediaList[i].DeviceFingerprint = ""
+	}
+}
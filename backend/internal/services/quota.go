@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/storage"
+)
+
+// UploadLimits bounds what can be stored. MaxSizes caps a single file of
+// each media type. Quotas are the bytes a user may upload and an event may
+// hold; zero means unlimited. Writes are refused while the media volume has
+// less than MinFreeSpace bytes left.
+type UploadLimits struct {
+	MaxSizes     map[string]int64
+	UserQuota    int64
+	EventQuota   int64
+	MinFreeSpace int64
+}
+
+// Storage errors that handlers map to specific status codes
+var (
+	ErrUserQuotaExceeded   = errors.New("your storage quota is used up")
+	ErrEventQuotaExceeded  = errors.New("this event's storage quota is used up")
+	ErrInsufficientStorage = errors.New("not enough free space on the media volume")
+)
+
+// maxSize is the largest file accepted for mediaType
+func (l UploadLimits) maxSize(mediaType string) int64 {
+	return l.MaxSizes[mediaType]
+}
+
+// MaxUploadSize is the largest file accepted of any media type
+func (s *MediaService) MaxUploadSize() int64 {
+	var largest int64
+	for _, size := range s.limits.MaxSizes {
+		largest = max(largest, size)
+	}
+	return largest
+}
+
+// checkQuota refuses an upload of size bytes by userID to eventID that
+// would take either past its quota. A size of zero checks that neither is
+// used up already.
+func (s *MediaService) checkQuota(eventID, userID int, size int64) error {
+	if s.limits.UserQuota > 0 && userID != 0 {
+		used, _, err := s.mediaRepo.GetUsageByUser(userID)
+		if err != nil {
+			return err
+		}
+		if used+size > s.limits.UserQuota || used >= s.limits.UserQuota {
+			return ErrUserQuotaExceeded
+		}
+	}
+	if s.limits.EventQuota > 0 {
+		used, _, err := s.mediaRepo.GetUsageByEvent(eventID)
+		if err != nil {
+			return err
+		}
+		if used+size > s.limits.EventQuota || used >= s.limits.EventQuota {
+			return ErrEventQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// checkFreeSpace refuses a write of size bytes that would leave less than
+// the minimum free space on the media volume. Backends that do not report
+// their capacity are not checked.
+func (s *MediaService) checkFreeSpace(size int64) error {
+	free, err := s.storage.FreeSpace()
+	if err == storage.ErrFreeSpaceUnknown {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check free space: %v", err)
+	}
+	if free-size < s.limits.MinFreeSpace {
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// GetUserUsage reports the storage used by a user's uploads against their
+// quota
+func (s *MediaService) GetUserUsage(userID int) (*models.StorageUsage, error) {
+	used, files, err := s.mediaRepo.GetUsageByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return newStorageUsage(used, files, s.limits.UserQuota), nil
+}
+
+// GetEventUsage reports the storage used by an event's media against its
+// quota
+func (s *MediaService) GetEventUsage(eventID int) (*models.StorageUsage, error) {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return nil, errors.New("event not found")
+	}
+	used, files, err := s.mediaRepo.GetUsageByEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	return newStorageUsage(used, files, s.limits.EventQuota), nil
+}
+
+func newStorageUsage(used int64, files int, quota int64) *models.StorageUsage {
+	usage := &models.StorageUsage{UsedBytes: used, Files: files, QuotaBytes: quota}
+	if quota > 0 {
+		remaining := max(quota-used, 0)
+		usage.RemainingBytes = &remaining
+	}
+	return usage
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"time"
+
+	"github.com/protest-tracker/internal/imaging"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/realtime"
+)
+
+// Limits on a single redaction request
+const (
+	maxRedactionRegions  = 200
+	maxRedactionVertices = 100
+
+	// maxRedactionCoordinate bounds every coordinate and size, well past
+	// any photo that decodes, so that sums and the products taken when
+	// testing pixels against polygons cannot overflow
+	maxRedactionCoordinate = imaging.MaxPixels
+)
+
+// ErrRedactionUnsupported is returned for media that cannot be redacted
+var ErrRedactionUnsupported = errors.New("only JPEG and PNG photos can be redacted")
+
+// RedactMedia produces a copy of a photo with the requested regions
+// obscured and stores it as a new media record linked to the original. The
+// original file is never modified.
+func (s *MediaService) RedactMedia(eventID, mediaID, userID int, redaction *models.Redaction) (*models.Media, error) {
+	original, err := s.findCleanMedia(eventID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if original.Type != "photo" || !thumbnailable(original.MimeType) {
+		return nil, ErrRedactionUnsupported
+	}
+
+	mode := imaging.RedactMode(redaction.Mode)
+	if mode != imaging.Pixelate && mode != imaging.Blackout {
+		return nil, errors.New("mode must be pixelate or blackout")
+	}
+	if len(redaction.Regions) == 0 {
+		return nil, errors.New("at least one region is required")
+	}
+	if len(redaction.Regions) > maxRedactionRegions {
+		return nil, fmt.Errorf("at most %d regions are allowed", maxRedactionRegions)
+	}
+
+	content, err := s.openContent(original)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := decodePhoto(content)
+	content.Close()
+	if err != nil {
+		return nil, err
+	}
+	polygons, err := redactionPolygons(redaction.Regions, img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+
+	imaging.Redact(img, polygons, mode)
+
+	// Keep PNG lossless; everything else is re-encoded as JPEG
+	mimeType, extension := "image/jpeg", ".jpg"
+	var data []byte
+	if format == "png" {
+		mimeType, extension = "image/png", ".png"
+		data, err = imaging.EncodePNG(img)
+	} else {
+		data, err = imaging.EncodeJPEG(img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode redacted photo: %v", err)
+	}
+
+	stored, err := s.storeBlob(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	derivative := &models.Media{
+		EventID:    eventID,
+		FilePath:   stored.Ref,
+		Type:       "photo",
+		SHA256:     stored.SHA256,
+		KeyID:      stored.KeyID,
+		WrappedKey: stored.WrappedKey,
+		MimeType:   mimeType,
+		Size:       stored.Size,
+		Extension:  extension,
+		ParentID:   &original.ID,
+		Redaction:  redaction,
+		ScanStatus: models.ScanPending,
+
+		ProcessingStatus: models.ProcessingQueued,
+
+		// The copy was made by the advocate but shows the original scene
+		UploadedBy:    userID,
+		UploadedAt:    time.Now().UTC(),
+		CapturedAt:    original.CapturedAt,
+		CaptureSource: original.CaptureSource,
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+	}
+	if err = s.mediaRepo.Create(derivative); err != nil {
+		s.releaseFile(stored.SHA256, stored.Ref)
+		return nil, fmt.Errorf("failed to save media record: %v", err)
+	}
+
+	err = s.recordCustody(original, userID, models.CustodyRedact, fmt.Sprintf("derivative=%d mode=%s", derivative.ID, mode))
+	if err != nil {
+		log.Printf("Failed to record redaction of media %d: %v", original.ID, err)
+	}
+	err = s.recordCustody(derivative, userID, models.CustodyRedact,
+		fmt.Sprintf("source=%d source_sha256=%s sha256=%s", original.ID, original.SHA256, derivative.SHA256))
+	if err != nil {
+		log.Printf("Failed to record redaction of media %d: %v", derivative.ID, err)
+	}
+
+	s.enqueue(JobScanMedia, derivative)
+	s.timestamps.StampMedia(derivative)
+	s.feed.Publish(realtime.MediaCreated, derivative.EventID, derivative, true)
+
+	return derivative, nil
+}
+
+// decodePhoto decodes a stored photo and reports whether it was a PNG
+func decodePhoto(content *MediaContent) (*image.RGBA, string, error) {
+	_, format, err := image.DecodeConfig(content)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode photo: %v", err)
+	}
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	img, err := imaging.Decode(content)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode photo: %v", err)
+	}
+	return img, format, nil
+}
+
+// redactionPolygons validates the requested regions against the bounds of
+// the photo and converts each into a polygon; rectangles become four
+// vertices. Regions that are empty or lie wholly outside the photo are
+// rejected, since they would redact nothing.
+func redactionPolygons(regions []models.RedactionRegion, bounds image.Rectangle) ([][]image.Point, error) {
+	polygons := make([][]image.Point, 0, len(regions))
+	for i, region := range regions {
+		var polygon []image.Point
+		if len(region.Points) > 0 {
+			if len(region.Points) < 3 || len(region.Points) > maxRedactionVertices {
+				return nil, fmt.Errorf("region %d: a polygon needs 3 to %d points", i, maxRedactionVertices)
+			}
+			polygon = make([]image.Point, len(region.Points))
+			for j, p := range region.Points {
+				if !inRedactionRange(p.X, p.Y) {
+					return nil, fmt.Errorf("region %d: coordinates out of range", i)
+				}
+				polygon[j] = image.Pt(p.X, p.Y)
+			}
+		} else {
+			if region.Width <= 0 || region.Height <= 0 {
+				return nil, fmt.Errorf("region %d: width and height must be positive", i)
+			}
+			if !inRedactionRange(region.X, region.Y, region.Width, region.Height) {
+				return nil, fmt.Errorf("region %d: coordinates out of range", i)
+			}
+			x0, y0 := region.X, region.Y
+			x1, y1 := x0+region.Width, y0+region.Height
+			polygon = []image.Point{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+		}
+
+		area := image.Rectangle{Min: polygon[0], Max: polygon[0]}
+		for _, p := range polygon[1:] {
+			area.Min.X, area.Min.Y = min(area.Min.X, p.X), min(area.Min.Y, p.Y)
+			area.Max.X, area.Max.Y = max(area.Max.X, p.X), max(area.Max.Y, p.Y)
+		}
+		if area.Empty() {
+			return nil, fmt.Errorf("region %d: region is empty", i)
+		}
+		if !area.Overlaps(bounds) {
+			return nil, fmt.Errorf("region %d: region lies outside the photo", i)
+		}
+		polygons = append(polygons, polygon)
+	}
+
+	return polygons, nil
+}
+
+// inRedactionRange reports whether every value is within
+// maxRedactionCoordinate of zero
+func inRedactionRange(values ...int) bool {
+	for _, v := range values {
+		if v < -maxRedactionCoordinate || v > maxRedactionCoordinate {
+			return false
+		}
+	}
+	return true
+}
+
+// GetMediaVersions retrieves the redacted derivatives made from a media item
+func (s *MediaService) GetMediaVersions(eventID, mediaID int) ([]models.Media, error) {
+	if _, err := s.findMedia(eventID, mediaID); err != nil {
+		return nil, err
+	}
+
+	return s.mediaRepo.GetByParentID(mediaID)
+}
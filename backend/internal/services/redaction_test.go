@@ -0,0 +1,73 @@
+package services
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+func TestRedactionPolygons(t *testing.T) {
+	bounds := image.Rect(0, 0, 640, 480)
+	manyPoints := make([]models.Point, maxRedactionVertices+1)
+	for i := range manyPoints {
+		manyPoints[i] = models.Point{X: i, Y: i % 7}
+	}
+
+	tests := []struct {
+		name    string
+		region  models.RedactionRegion
+		want    []image.Point
+		wantErr bool
+	}{
+		{
+			name:   "rectangle",
+			region: models.RedactionRegion{X: 10, Y: 20, Width: 30, Height: 40},
+			want:   []image.Point{{10, 20}, {40, 20}, {40, 60}, {10, 60}},
+		},
+		{
+			name:   "rectangle partly outside",
+			region: models.RedactionRegion{X: -10, Y: 470, Width: 30, Height: 40},
+			want:   []image.Point{{-10, 470}, {20, 470}, {20, 510}, {-10, 510}},
+		},
+		{
+			name:   "polygon",
+			region: models.RedactionRegion{Points: []models.Point{{X: 5, Y: 5}, {X: 50, Y: 10}, {X: 20, Y: 60}}},
+			want:   []image.Point{{5, 5}, {50, 10}, {20, 60}},
+		},
+		{name: "zero width", region: models.RedactionRegion{X: 10, Y: 10, Height: 5}, wantErr: true},
+		{name: "negative height", region: models.RedactionRegion{X: 10, Y: 10, Width: 5, Height: -5}, wantErr: true},
+		{name: "rectangle outside the photo", region: models.RedactionRegion{X: 640, Y: 0, Width: 10, Height: 10}, wantErr: true},
+		{name: "rectangle overflowing", region: models.RedactionRegion{X: 1 << 62, Y: 0, Width: 1 << 62, Height: 10}, wantErr: true},
+		{name: "two points", region: models.RedactionRegion{Points: []models.Point{{X: 0, Y: 0}, {X: 10, Y: 10}}}, wantErr: true},
+		{name: "too many points", region: models.RedactionRegion{Points: manyPoints}, wantErr: true},
+		{
+			name:    "polygon on a line",
+			region:  models.RedactionRegion{Points: []models.Point{{X: 0, Y: 5}, {X: 10, Y: 5}, {X: 20, Y: 5}}},
+			wantErr: true,
+		},
+		{
+			name:    "polygon outside the photo",
+			region:  models.RedactionRegion{Points: []models.Point{{X: -50, Y: -50}, {X: -10, Y: -50}, {X: -10, Y: -10}}},
+			wantErr: true,
+		},
+		{
+			name:    "polygon coordinate out of range",
+			region:  models.RedactionRegion{Points: []models.Point{{X: 0, Y: 0}, {X: maxRedactionCoordinate + 1, Y: 0}, {X: 0, Y: 10}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polygons, err := redactionPolygons([]models.RedactionRegion{tt.region}, bounds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(polygons, [][]image.Point{tt.want}) {
+				t.Errorf("polygons = %v, want %v", polygons, tt.want)
+			}
+		})
+	}
+}
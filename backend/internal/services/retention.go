@@ -0,0 +1,193 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/repository"
+)
+
+// Errors that handlers map to 409 Conflict
+var (
+	// ErrLegalHold is returned when deleting media that is under legal
+	// hold, directly or through its event
+	ErrLegalHold = errors.New("media is under legal hold")
+	// ErrEventLegalHold is returned when deleting an event that is held or
+	// has held media
+	ErrEventLegalHold = errors.New("event is under legal hold")
+	// ErrEventHasMedia is returned when deleting an event whose media has
+	// not all been deleted and purged
+	ErrEventHasMedia = errors.New("event still has media; delete it and wait for it to be purged")
+	// ErrAlreadyDeleted is returned when deleting media that another
+	// request has just deleted
+	ErrAlreadyDeleted = errors.New("media is already deleted")
+	// ErrRestoreDuplicate is returned when restoring media whose file has
+	// been uploaded to the event again since it was deleted
+	ErrRestoreDuplicate = errors.New("an identical file has been uploaded to this event since; delete it first")
+)
+
+// DeleteMedia marks a media item deleted. Its file is kept until the grace
+// period ends so the deletion can be undone; media under legal hold cannot
+// be deleted.
+func (s *MediaService) DeleteMedia(eventID, mediaID, userID int, reason string) (*models.Media, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errors.New("a reason is required")
+	}
+
+	media, err := s.findMedia(eventID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	purgeAfter := now.Add(s.deleteGrace)
+	deleted, err := s.mediaRepo.SoftDelete(mediaID, userID, reason, now, purgeAfter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete media: %v", err)
+	}
+	if !deleted {
+		// Either held, or deleted by someone else since it was looked up
+		if current, err := s.mediaRepo.GetByID(mediaID); err == nil && current.DeletedAt != nil {
+			return nil, ErrAlreadyDeleted
+		}
+		return nil, ErrLegalHold
+	}
+
+	err = s.recordCustody(media, userID, models.CustodyDelete,
+		fmt.Sprintf("reason=%q purge_after=%s", reason, purgeAfter.Format(time.RFC3339)))
+	if err != nil {
+		log.Printf("Failed to record deletion of media %d: %v", media.ID, err)
+	}
+
+	return s.mediaRepo.GetByID(mediaID)
+}
+
+// RestoreMedia undoes the deletion of a media item whose file has not been
+// purged yet
+func (s *MediaService) RestoreMedia(eventID, mediaID, userID int) (*models.Media, error) {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil || media.EventID != eventID {
+		return nil, errors.New("media not found")
+	}
+	if media.DeletedAt == nil {
+		return nil, errors.New("media is not deleted")
+	}
+
+	err = s.mediaRepo.Restore(mediaID)
+	if err == repository.ErrDuplicateMedia {
+		return nil, ErrRestoreDuplicate
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore media: %v", err)
+	}
+
+	if err = s.recordCustody(media, userID, models.CustodyRestore, ""); err != nil {
+		log.Printf("Failed to record restore of media %d: %v", media.ID, err)
+	}
+
+	return s.mediaRepo.GetByID(mediaID)
+}
+
+// SetMediaLegalHold places or releases a legal hold on a media item.
+// Deleted media can be held too, which stops them from being purged.
+func (s *MediaService) SetMediaLegalHold(eventID, mediaID, userID int, hold bool) (*models.Media, error) {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil || media.EventID != eventID {
+		return nil, errors.New("media not found")
+	}
+
+	if err = s.mediaRepo.SetLegalHold(mediaID, hold); err != nil {
+		return nil, fmt.Errorf("failed to update legal hold: %v", err)
+	}
+
+	err = s.recordCustody(media, userID, models.CustodyHold, fmt.Sprintf("hold=%t", hold))
+	if err != nil {
+		log.Printf("Failed to record legal hold of media %d: %v", media.ID, err)
+	}
+
+	media.LegalHold = hold
+	return media, nil
+}
+
+// GetDeletedMedia retrieves an event's deleted media that can still be
+// restored
+func (s *MediaService) GetDeletedMedia(eventID int) ([]models.Media, error) {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	return s.mediaRepo.GetDeletedByEventID(eventID)
+}
+
+// PurgeDeleted removes the files and records of deleted media whose grace
+// period has ended. Media placed under legal hold after deletion are kept.
+// A failure is logged and does not stop the rest; the error then reports
+// how many failed.
+func (s *MediaService) PurgeDeleted() (int, error) {
+	purgeable, err := s.mediaRepo.GetPurgeable(time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	purged, failed := 0, 0
+	for i := range purgeable {
+		if err := s.purge(&purgeable[i]); err != nil {
+			log.Printf("Failed to purge media %d: %v", purgeable[i].ID, err)
+			failed++
+			continue
+		}
+		purged++
+	}
+
+	if failed > 0 {
+		return purged, fmt.Errorf("%d of %d media could not be purged", failed, len(purgeable))
+	}
+	return purged, nil
+}
+
+// purge removes one deleted media item's record, file and thumbnails
+func (s *MediaService) purge(media *models.Media) error {
+	thumbnails, err := s.mediaRepo.GetThumbnails(media.ID)
+	if err != nil {
+		return err
+	}
+
+	// Record the purge first; the log outlives the media row
+	err = s.recordCustody(media, media.DeletedBy, models.CustodyPurge, "sha256="+media.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to record custody: %v", err)
+	}
+
+	if err = s.mediaRepo.Delete(media.ID); err != nil {
+		return fmt.Errorf("failed to delete record: %v", err)
+	}
+
+	// The file may still be shared with other media records
+	if err = s.releaseFile(media.SHA256, media.FilePath); err != nil {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+	for _, thumb := range thumbnails {
+		if err = s.storage.Delete(thumb.FilePath); err != nil {
+			log.Printf("Failed to delete %s thumbnail of media %d: %v", thumb.Size, media.ID, err)
+		}
+	}
+	return nil
+}
+
+// RunPurger purges deleted media every interval until the process exits
+func (s *MediaService) RunPurger(interval time.Duration) {
+	for range time.Tick(interval) {
+		purged, err := s.PurgeDeleted()
+		if err != nil {
+			log.Printf("Failed to purge deleted media: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted media files", purged)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/protest-tracker/internal/models"
+)
+
+// ErrQuarantined is returned when media that have not passed a malware scan
+// are opened
+var ErrQuarantined = errors.New("media is quarantined pending a malware scan")
+
+// ErrInfected is returned when media that failed a malware scan are opened
+var ErrInfected = errors.New("media failed the malware scan")
+
+// scanMedia runs a media item through the scanner and records the verdict.
+// Clean media go on to be probed and previewed. A scanner error leaves the
+// media quarantined and fails the job, so the scan is retried.
+func (s *MediaService) scanMedia(mediaID int) error {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		return err
+	}
+	if media.ScanStatus != models.ScanPending && media.ScanStatus != models.ScanError {
+		return nil
+	}
+
+	content, err := s.openContent(media)
+	if err != nil {
+		return err
+	}
+	result, scanErr := s.scanner.Scan(content)
+	content.Close()
+
+	now := time.Now().UTC()
+	status, details := models.ScanClean, ""
+	switch {
+	case scanErr != nil:
+		status, details = models.ScanError, scanErr.Error()
+	case result.Infected:
+		status, details = models.ScanInfected, result.Signature
+	}
+
+	if err = s.mediaRepo.SetScanResult(media.ID, status, details, &now); err != nil {
+		return fmt.Errorf("failed to save scan result: %v", err)
+	}
+
+	custody := fmt.Sprintf("scanner=%s result=%s", s.scanner.Name(), status)
+	if details != "" {
+		custody += " " + details
+	}
+	if err = s.recordCustody(media, 0, models.CustodyScan, custody); err != nil {
+		log.Printf("Failed to record scan of media %d: %v", media.ID, err)
+	}
+
+	switch status {
+	case models.ScanClean:
+		s.enqueue(JobProbeMedia, media)
+		if previewable(media) {
+			s.enqueue(JobPreviewMedia, media)
+		}
+		if inspectable(media) {
+			s.enqueue(JobInspectCredentials, media)
+		}
+	case models.ScanInfected:
+		log.Printf("WARNING: media %d in event %d is infected (%s) and has been quarantined", media.ID, media.EventID, details)
+	case models.ScanError:
+		return scanErr
+	}
+	return nil
+}
+
+// RescanMedia queues a media item for another malware scan, for example
+// after the scanner's signatures were updated. It stays quarantined until
+// the scan finishes.
+func (s *MediaService) RescanMedia(eventID, mediaID, userID int) (*models.Media, error) {
+	media, err := s.findMedia(eventID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.mediaRepo.SetScanResult(media.ID, models.ScanPending, "", nil); err != nil {
+		return nil, err
+	}
+	if err = s.recordCustody(media, userID, models.CustodyScan, "rescan requested"); err != nil {
+		log.Printf("Failed to record rescan of media %d: %v", media.ID, err)
+	}
+
+	media.ScanStatus, media.ScanResult, media.ScannedAt = models.ScanPending, "", nil
+	s.enqueue(JobScanMedia, media)
+	return media, nil
+}
+
+// GetQuarantinedMedia lists an event's media that are waiting for a scan,
+// failed one or could not be scanned
+func (s *MediaService) GetQuarantinedMedia(eventID int) ([]models.Media, error) {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return nil, errors.New("event not found")
+	}
+	return s.mediaRepo.GetQuarantinedByEventID(eventID)
+}
+
+// findCleanMedia is findMedia for operations that read the file. Media
+// that have not passed a malware scan are refused.
+func (s *MediaService) findCleanMedia(eventID, mediaID int) (*models.Media, error) {
+	media, err := s.findMedia(eventID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	switch media.ScanStatus {
+	case models.ScanClean:
+		return media, nil
+	case models.ScanInfected:
+		return nil, ErrInfected
+	}
+	return nil, ErrQuarantined
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/protest-tracker/internal/auth"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/repository"
+)
+
+// Share link lifetimes
+const (
+	DefaultShareLinkLifetime = 7 * 24 * time.Hour
+	MaxShareLinkLifetime     = 30 * 24 * time.Hour
+)
+
+// minSharePasswordLength is the shortest password accepted for a link
+const minSharePasswordLength = 8
+
+// Share link errors that handlers map to specific status codes
+var (
+	ErrShareLinkNotFound = errors.New("share link not found")
+	ErrShareLinkExpired  = errors.New("share link has expired")
+	ErrShareLinkRevoked  = errors.New("share link has been revoked")
+	ErrShareViewLimit    = errors.New("share link has no views left")
+	ErrSharePassword     = errors.New("share link password required or incorrect")
+)
+
+// ShareService manages signed links that give people without an account,
+// such as outside lawyers, access to a single media item or an event's
+// evidence package. Links expire, can be revoked, and may require a
+// password or allow only a number of views. Every use is logged.
+type ShareService struct {
+	shareRepo     *repository.ShareRepository
+	eventRepo     *repository.EventRepository
+	mediaService  *MediaService
+	exportService *ExportService
+	authService   *auth.Service
+	secret        []byte
+}
+
+func NewShareService(shareRepo *repository.ShareRepository, eventRepo *repository.EventRepository, mediaService *MediaService, exportService *ExportService, authService *auth.Service, secret string) *ShareService {
+	return &ShareService{
+		shareRepo:     shareRepo,
+		eventRepo:     eventRepo,
+		mediaService:  mediaService,
+		exportService: exportService,
+		authService:   authService,
+		secret:        []byte(secret),
+	}
+}
+
+// ShareRequest identifies the client using a share link, for the access log
+type ShareRequest struct {
+	Token     string
+	Password  string
+	IP        string
+	UserAgent string
+}
+
+// CreateLink creates a share link for an event, or for one of its media
+// items when req.MediaID is set
+func (s *ShareService) CreateLink(eventID, userID int, req *models.CreateShareLinkRequest) (*models.ShareLink, error) {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return nil, errors.New("event not found")
+	}
+	if req.MediaID != nil {
+		if _, err := s.mediaService.findCleanMedia(eventID, *req.MediaID); err != nil {
+			return nil, err
+		}
+	}
+
+	lifetime := DefaultShareLinkLifetime
+	if req.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || parsed <= 0 {
+			return nil, errors.New("invalid expiresIn")
+		}
+		if parsed > MaxShareLinkLifetime {
+			return nil, fmt.Errorf("share links may last at most %s", MaxShareLinkLifetime)
+		}
+		lifetime = parsed
+	}
+	if req.MaxViews != nil && *req.MaxViews < 1 {
+		return nil, errors.New("maxViews must be at least 1")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	link := &models.ShareLink{
+		EventID:   eventID,
+		MediaID:   req.MediaID,
+		CreatedBy: userID,
+		Note:      req.Note,
+		MaxViews:  req.MaxViews,
+		ExpiresAt: now.Add(lifetime),
+		CreatedAt: now,
+	}
+
+	if req.Password != "" {
+		if len(req.Password) < minSharePasswordLength {
+			return nil, fmt.Errorf("password must be at least %d characters", minSharePasswordLength)
+		}
+		hash, err := s.authService.HashPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		link.PasswordHash = hash
+		link.HasPassword = true
+	}
+
+	if err := s.shareRepo.Create(link); err != nil {
+		return nil, err
+	}
+
+	link.Token = s.token(link)
+	return link, nil
+}
+
+// GetLinks lists an event's share links with their tokens
+func (s *ShareService) GetLinks(eventID int) ([]models.ShareLink, error) {
+	links, err := s.shareRepo.GetByEventID(eventID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		links[i].Token = s.token(&links[i])
+	}
+	return links, nil
+}
+
+// RevokeLink stops a share link from working
+func (s *ShareService) RevokeLink(eventID, linkID int) (*models.ShareLink, error) {
+	link, err := s.findLink(eventID, linkID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = s.shareRepo.Revoke(link.ID, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return s.shareRepo.GetByID(link.ID)
+}
+
+// GetAccessLog lists every recorded use of a share link
+func (s *ShareService) GetAccessLog(eventID, linkID int) ([]models.ShareAccess, error) {
+	if _, err := s.findLink(eventID, linkID); err != nil {
+		return nil, err
+	}
+	return s.shareRepo.GetAccessLog(linkID)
+}
+
+// Describe lists what a share link gives access to. It does not use up a
+// view.
+func (s *ShareService) Describe(req *ShareRequest) (*models.SharedContent, error) {
+	link, err := s.authorize(req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := s.eventRepo.GetByID(link.EventID)
+	if err != nil {
+		return nil, ErrShareLinkNotFound
+	}
+
+	shared := &models.SharedContent{
+		EventID:   event.ID,
+		EventTime: event.Time,
+		Bundle:    link.MediaID == nil,
+		ExpiresAt: link.ExpiresAt,
+	}
+	if link.MaxViews != nil {
+		left := max(0, *link.MaxViews-link.ViewCount)
+		shared.ViewsLeft = &left
+	}
+
+	if link.MediaID != nil {
+		media, err := s.mediaService.findCleanMedia(link.EventID, *link.MediaID)
+		if err != nil {
+			return nil, ErrShareLinkNotFound
+		}
+		shared.Media = []models.Media{*media}
+	} else {
+		shared.Media, err = s.mediaService.GetEventMedia(link.EventID, &models.MediaFilter{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	withoutSource(shared.Media)
+	return shared, nil
+}
+
+// IsBundle reports whether a token is for an event's evidence package
+// rather than a single media item. Nothing is logged.
+func (s *ShareService) IsBundle(token string) (bool, error) {
+	link, err := s.verifyToken(token)
+	if err != nil {
+		return false, err
+	}
+	return link.MediaID == nil, nil
+}
+
+// OpenSharedMedia opens the media item behind a single-item share link.
+// Every request uses up a view and is written to the custody log under the
+// link creator, whatever part of the file it asks for. The caller is
+// responsible for closing the content.
+func (s *ShareService) OpenSharedMedia(req *ShareRequest) (*models.Media, *MediaContent, error) {
+	link, err := s.authorize(req, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if link.MediaID == nil {
+		return nil, nil, errors.New("share link is for an event bundle")
+	}
+
+	media, err := s.mediaService.findCleanMedia(link.EventID, *link.MediaID)
+	if err != nil {
+		return nil, nil, ErrShareLinkNotFound
+	}
+
+	err = s.mediaService.recordCustody(media, link.CreatedBy, models.CustodyDownload, fmt.Sprintf("share link %d", link.ID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to record custody: %v", err)
+	}
+
+	content, err := s.mediaService.openContent(media)
+	if err != nil {
+		return nil, nil, err
+	}
+	return media, content, nil
+}
+
+// PrepareSharedExport prepares the evidence package behind an event share
+// link. The export is recorded in the custody log under the link creator.
+// It leaves out the event's subscribers and statements and where media
+// were captured.
+func (s *ShareService) PrepareSharedExport(req *ShareRequest) (*EventExport, error) {
+	link, err := s.authorize(req, true)
+	if err != nil {
+		return nil, err
+	}
+	if link.MediaID != nil {
+		return nil, errors.New("share link is for a single media item")
+	}
+
+	return s.exportService.prepareExport(link.EventID, link.CreatedBy, fmt.Sprintf("share link %d", link.ID), true)
+}
+
+// authorize checks a share link's signature, state and password, using up
+// a view when consume is set. Every attempt on a genuine link is logged.
+func (s *ShareService) authorize(req *ShareRequest, consume bool) (*models.ShareLink, error) {
+	link, err := s.verifyToken(req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	outcome, err := s.check(link, req.Password, now)
+	if err == nil && consume {
+		ok, consumeErr := s.shareRepo.ConsumeView(link.ID, now)
+		if consumeErr != nil {
+			return nil, consumeErr
+		}
+		if !ok {
+			outcome, err = models.ShareAccessViewLimit, ErrShareViewLimit
+		}
+	}
+	if err == nil && !consume {
+		outcome = models.ShareAccessInfo
+	}
+
+	logErr := s.shareRepo.LogAccess(&models.ShareAccess{
+		LinkID:     link.ID,
+		Outcome:    outcome,
+		IP:         req.IP,
+		UserAgent:  req.UserAgent,
+		AccessedAt: now,
+	})
+	if logErr != nil {
+		log.Printf("Failed to log access to share link %d: %v", link.ID, logErr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// check reports why a link cannot be used right now, if at all
+func (s *ShareService) check(link *models.ShareLink, password string, now time.Time) (string, error) {
+	switch {
+	case link.RevokedAt != nil:
+		return models.ShareAccessRevoked, ErrShareLinkRevoked
+	case !now.Before(link.ExpiresAt):
+		return models.ShareAccessExpired, ErrShareLinkExpired
+	case link.MaxViews != nil && link.ViewCount >= *link.MaxViews:
+		return models.ShareAccessViewLimit, ErrShareViewLimit
+	case link.HasPassword && !s.authService.CheckPassword(password, link.PasswordHash):
+		return models.ShareAccessBadPassword, ErrSharePassword
+	}
+	return models.ShareAccessGranted, nil
+}
+
+// findLink retrieves a share link belonging to an event
+func (s *ShareService) findLink(eventID, linkID int) (*models.ShareLink, error) {
+	link, err := s.shareRepo.GetByID(linkID)
+	if err != nil || link.EventID != eventID {
+		return nil, ErrShareLinkNotFound
+	}
+	return link, nil
+}
+
+// token builds the public token of a link: its ID, its expiry and an HMAC
+// over both. Tokens cannot be forged or extended without the server secret.
+func (s *ShareService) token(link *models.ShareLink) string {
+	expires := link.ExpiresAt.Unix()
+	return fmt.Sprintf("%d.%d.%s", link.ID, expires, s.sign(link.ID, expires))
+}
+
+// verifyToken checks a token's signature and loads its link. Bad tokens are
+// rejected before touching the database and are not logged.
+func (s *ShareService) verifyToken(token string) (*models.ShareLink, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrShareLinkNotFound
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, ErrShareLinkNotFound
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrShareLinkNotFound
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(id, expires))) {
+		return nil, ErrShareLinkNotFound
+	}
+
+	link, err := s.shareRepo.GetByID(id)
+	if err != nil || link.ExpiresAt.Unix() != expires {
+		return nil, ErrShareLinkNotFound
+	}
+	return link, nil
+}
+
+// sign computes the signature part of a token
+func (s *ShareService) sign(id int, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "share-link:%d:%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/protest-tracker/internal/auth"
+	"github.com/protest-tracker/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newShareTest returns a share service without repositories; anything
+// that reaches the database panics
+func newShareTest(secret string) *ShareService {
+	return NewShareService(nil, nil, nil, nil, auth.NewService("jwt secret"), secret)
+}
+
+func TestShareTokenSignsIDAndExpiry(t *testing.T) {
+	s := newShareTest("share secret")
+	expires := time.Date(2024, 5, 8, 18, 0, 0, 0, time.UTC)
+	link := &models.ShareLink{ID: 42, ExpiresAt: expires}
+
+	token := s.token(link)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != "42" || parts[1] != fmt.Sprint(expires.Unix()) {
+		t.Fatalf("token = %q, want <id>.<expiry>.<signature>", token)
+	}
+	if parts[2] != s.sign(42, expires.Unix()) {
+		t.Errorf("signature does not match sign()")
+	}
+
+	tests := []struct {
+		name    string
+		service *ShareService
+		link    *models.ShareLink
+	}{
+		{"other secret", newShareTest("other secret"), link},
+		{"other link", s, &models.ShareLink{ID: 43, ExpiresAt: expires}},
+		{"other expiry", s, &models.ShareLink{ID: 42, ExpiresAt: expires.Add(time.Second)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := strings.Split(tt.service.token(tt.link), ".")[2]
+			if other == parts[2] {
+				t.Errorf("same signature as the original link")
+			}
+		})
+	}
+}
+
+// Forged, altered and malformed tokens are rejected before the database is
+// queried
+func TestShareTokenRejectsForgeries(t *testing.T) {
+	s := newShareTest("share secret")
+	expires := time.Now().Add(time.Hour).Unix()
+	signature := s.sign(42, expires)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"two parts", fmt.Sprintf("42.%d", expires)},
+		{"four parts", fmt.Sprintf("42.%d.%s.x", expires, signature)},
+		{"non-numeric id", fmt.Sprintf("x.%d.%s", expires, signature)},
+		{"non-numeric expiry", fmt.Sprintf("42.x.%s", signature)},
+		{"no signature", fmt.Sprintf("42.%d.", expires)},
+		{"wrong signature", fmt.Sprintf("42.%d.%s", expires, s.sign(42, expires+1))},
+		{"extended expiry", fmt.Sprintf("42.%d.%s", expires+86400, signature)},
+		{"other link", fmt.Sprintf("43.%d.%s", expires, signature)},
+		{"other secret", fmt.Sprintf("42.%d.%s", expires, newShareTest("guess").sign(42, expires))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.verifyToken(tt.token); err != ErrShareLinkNotFound {
+				t.Errorf("verifyToken = %v, want ErrShareLinkNotFound", err)
+			}
+		})
+	}
+}
+
+func TestShareLinkCheck(t *testing.T) {
+	s := newShareTest("share secret")
+	// The cheapest cost keeps the test fast; the cost is read from the hash
+	hashed, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := string(hashed)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	views := func(n int) *int { return &n }
+	revoked := now.Add(-time.Minute)
+
+	tests := []struct {
+		name     string
+		link     models.ShareLink
+		password string
+		outcome  string
+		err      error
+	}{
+		{"open link", models.ShareLink{ExpiresAt: now.Add(time.Hour)}, "", models.ShareAccessGranted, nil},
+		{"expires now", models.ShareLink{ExpiresAt: now}, "", models.ShareAccessExpired, ErrShareLinkExpired},
+		{"expired", models.ShareLink{ExpiresAt: now.Add(-time.Hour)}, "", models.ShareAccessExpired, ErrShareLinkExpired},
+		{"revoked", models.ShareLink{ExpiresAt: now.Add(time.Hour), RevokedAt: &revoked}, "", models.ShareAccessRevoked, ErrShareLinkRevoked},
+		{
+			"revoked after expiring",
+			models.ShareLink{ExpiresAt: now.Add(-time.Hour), RevokedAt: &revoked},
+			"", models.ShareAccessRevoked, ErrShareLinkRevoked,
+		},
+		{"views left", models.ShareLink{ExpiresAt: now.Add(time.Hour), MaxViews: views(3), ViewCount: 2}, "", models.ShareAccessGranted, nil},
+		{"views used up", models.ShareLink{ExpiresAt: now.Add(time.Hour), MaxViews: views(3), ViewCount: 3}, "", models.ShareAccessViewLimit, ErrShareViewLimit},
+		{
+			"password missing",
+			models.ShareLink{ExpiresAt: now.Add(time.Hour), HasPassword: true, PasswordHash: hash},
+			"", models.ShareAccessBadPassword, ErrSharePassword,
+		},
+		{
+			"password wrong",
+			models.ShareLink{ExpiresAt: now.Add(time.Hour), HasPassword: true, PasswordHash: hash},
+			"battery staple", models.ShareAccessBadPassword, ErrSharePassword,
+		},
+		{
+			"password right",
+			models.ShareLink{ExpiresAt: now.Add(time.Hour), HasPassword: true, PasswordHash: hash},
+			"correct horse", models.ShareAccessGranted, nil,
+		},
+		{
+			"password right but used up",
+			models.ShareLink{ExpiresAt: now.Add(time.Hour), HasPassword: true, PasswordHash: hash, MaxViews: views(1), ViewCount: 1},
+			"correct horse", models.ShareAccessViewLimit, ErrShareViewLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome, err := s.check(&tt.link, tt.password, now)
+			if outcome != tt.outcome || err != tt.err {
+				t.Errorf("check = %q, %v; want %q, %v", outcome, err, tt.outcome, tt.err)
+			}
+		})
+	}
+}
+
+// The summary of an export made through a share link leaves out the
+// event's witnesses and statements
+func TestSharedExportSummary(t *testing.T) {
+	statements := []models.WitnessStatement{{ID: 1, UserID: 5, Statement: "I saw the arrest", CreatedAt: time.Now()}}
+
+	for _, shared := range []bool{false, true} {
+		var summary strings.Builder
+		err := summaryTemplate.Execute(&summary, summaryData{
+			Event:       &models.ArrestEvent{ID: 3},
+			Subscribers: 4,
+			Statements:  statements,
+			Manifest:    &models.ExportManifest{GeneratedAt: time.Now()},
+			Shared:      shared,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, text := range []string{"Subscribed witnesses", "Witness statements", "I saw the arrest"} {
+			if strings.Contains(summary.String(), text) == shared {
+				t.Errorf("shared %v: summary contains %q: %v", shared, text, !shared)
+			}
+		}
+	}
+}
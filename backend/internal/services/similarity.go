@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/protest-tracker/internal/imaging"
+	"github.com/protest-tracker/internal/models"
+)
+
+// Perceptual hash distance thresholds, in differing bits out of 64. Up to
+// about 10 catches rescaled, recompressed and lightly cropped copies; higher
+// values start matching unrelated photos of similar scenes.
+const (
+	DefaultSimilarityThreshold = 10
+	MaxSimilarityThreshold     = 20
+)
+
+// ErrNotHashed is returned for media that have no perceptual hash, either
+// because they are not JPEG or PNG photos or because they are still queued
+var ErrNotHashed = errors.New("media has no perceptual hash")
+
+// hashedPhoto pairs a photo with its parsed perceptual hash
+type hashedPhoto struct {
+	media models.Media
+	hash  uint64
+}
+
+// FindSimilarMedia lists photos in any event whose perceptual hash is
+// within threshold bits of a media item, closest first. The item's own
+// redacted versions, and the original it was redacted from, are left out.
+func (s *MediaService) FindSimilarMedia(eventID, mediaID, threshold int) ([]models.SimilarMedia, error) {
+	media, err := s.findMedia(eventID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if media.PerceptualHash == "" {
+		return nil, ErrNotHashed
+	}
+	hash, err := strconv.ParseUint(media.PerceptualHash, 16, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	photos, err := s.hashedPhotos()
+	if err != nil {
+		return nil, err
+	}
+
+	similar := []models.SimilarMedia{}
+	for _, photo := range photos {
+		if sameOriginal(&photo.media, media) {
+			continue
+		}
+		if distance := imaging.HammingDistance(hash, photo.hash); distance <= threshold {
+			similar = append(similar, models.SimilarMedia{Media: photo.media, Distance: distance})
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	return similar, nil
+}
+
+// FindSimilarAcrossEvents lists pairs of similar photos attached to
+// different events, closest first, returning at most limit pairs. Every
+// pair of hashed photos is compared, which is fine for the few thousand
+// photos a deployment collects.
+func (s *MediaService) FindSimilarAcrossEvents(threshold, limit int) ([]models.SimilarPair, error) {
+	photos, err := s.hashedPhotos()
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []models.SimilarPair{}
+	for i := range photos {
+		for j := i + 1; j < len(photos); j++ {
+			a, b := &photos[i], &photos[j]
+			if a.media.EventID == b.media.EventID {
+				continue
+			}
+			if distance := imaging.HammingDistance(a.hash, b.hash); distance <= threshold {
+				pairs = append(pairs, models.SimilarPair{A: a.media, B: b.media, Distance: distance})
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Distance < pairs[j].Distance
+	})
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	return pairs, nil
+}
+
+// hashedPhotos loads every photo with a perceptual hash
+func (s *MediaService) hashedPhotos() ([]hashedPhoto, error) {
+	mediaList, err := s.mediaRepo.GetHashedPhotos()
+	if err != nil {
+		return nil, err
+	}
+
+	photos := make([]hashedPhoto, 0, len(mediaList))
+	for _, media := range mediaList {
+		hash, err := strconv.ParseUint(media.PerceptualHash, 16, 64)
+		if err != nil {
+			continue
+		}
+		photos = append(photos, hashedPhoto{media: media, hash: hash})
+	}
+	return photos, nil
+}
+
+// sameOriginal reports whether two media are the same item or versions of
+// the same original
+func sameOriginal(a, b *models.Media) bool {
+	return originalID(a) == originalID(b)
+}
+
+func originalID(media *models.Media) int {
+	if media.ParentID != nil {
+		return *media.ParentID
+	}
+	return media.ID
+}
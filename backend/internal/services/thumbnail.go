@@ -0,0 +1,174 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/protest-tracker/internal/imaging"
+	"github.com/protest-tracker/internal/models"
+)
+
+// ThumbnailSize is a named preview size bounded by MaxSide pixels
+type ThumbnailSize struct {
+	Name    string
+	MaxSide int
+}
+
+// ThumbnailSizes lists the previews generated for every photo and document,
+// largest first
+var ThumbnailSizes = []ThumbnailSize{
+	{Name: "large", MaxSide: 1280},
+	{Name: "medium", MaxSide: 480},
+	{Name: "small", MaxSide: 160},
+}
+
+// thumbnailTypes are the photo formats the standard library can decode.
+// HEIC photos get no thumbnails or perceptual hash.
+var thumbnailTypes = []string{"image/jpeg", "image/png"}
+
+// documentPreviewType is the document format previewed from the page scan
+// it embeds; see imaging.DecodePDFPreview
+const documentPreviewType = "application/pdf"
+
+// Thumbnail errors that handlers map to specific status codes
+var (
+	ErrThumbnailNotFound    = errors.New("thumbnail not available")
+	ErrInvalidThumbnailSize = errors.New("invalid thumbnail size")
+)
+
+// processMedia decodes a photo, or the preview image of a document, once,
+// records a photo's perceptual hash and stores every thumbnail size, each
+// scaled from the next larger one. Work already done on an earlier run is
+// skipped.
+func (s *MediaService) processMedia(mediaID int) error {
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		return err
+	}
+	if media.ScanStatus != models.ScanClean {
+		return nil
+	}
+
+	content, err := s.openContent(media)
+	if err != nil {
+		return err
+	}
+	var img *image.RGBA
+	if media.Type == "document" {
+		img, err = imaging.DecodePDFPreview(content)
+	} else {
+		img, err = imaging.Decode(content)
+	}
+	content.Close()
+	if err == imaging.ErrNoPreview {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", media.Type, err)
+	}
+
+	if media.Type == "photo" && media.PerceptualHash == "" {
+		if err = s.mediaRepo.SetPerceptualHash(media.ID, imaging.PerceptualHash(img)); err != nil {
+			return fmt.Errorf("failed to save perceptual hash: %v", err)
+		}
+	}
+
+	existing, err := s.mediaRepo.GetThumbnails(media.ID)
+	if err != nil {
+		return err
+	}
+	if len(existing) == len(ThumbnailSizes) {
+		return nil
+	}
+
+	for _, size := range ThumbnailSizes {
+		img = imaging.Fit(img, size.MaxSide)
+		data, err := imaging.EncodeJPEG(img)
+		if err != nil {
+			return err
+		}
+
+		stored, err := s.storeFile(thumbnailKey(media, size.Name), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+
+		err = s.mediaRepo.SaveThumbnail(&models.Thumbnail{
+			MediaID:    media.ID,
+			Size:       size.Name,
+			FilePath:   stored.Ref,
+			Width:      img.Bounds().Dx(),
+			Height:     img.Bounds().Dy(),
+			Bytes:      stored.Size,
+			KeyID:      stored.KeyID,
+			WrappedKey: stored.WrappedKey,
+		})
+		if err != nil {
+			s.storage.Delete(stored.Ref)
+			return fmt.Errorf("failed to save thumbnail record: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// OpenThumbnail opens a thumbnail of a media record belonging to an event
+// and records the access in the custody log. The caller is responsible for
+// closing the content.
+func (s *MediaService) OpenThumbnail(eventID, mediaID, userID int, size string) (*models.Thumbnail, *MediaContent, error) {
+	if !validThumbnailSize(size) {
+		return nil, nil, ErrInvalidThumbnailSize
+	}
+
+	media, err := s.findCleanMedia(eventID, mediaID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	thumb, err := s.mediaRepo.GetThumbnail(mediaID, size)
+	if err != nil {
+		return nil, nil, ErrThumbnailNotFound
+	}
+
+	// A thumbnail shows what the photo or video shows
+	err = s.recordCustody(media, userID, models.CustodyAccess, "thumbnail="+size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to record custody: %v", err)
+	}
+
+	content, err := s.openStored(thumb.FilePath, thumb.KeyID, thumb.WrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return thumb, content, nil
+}
+
+// thumbnailKey is the storage key of a thumbnail. Thumbnails belong to a
+// media record rather than to its possibly shared file.
+func thumbnailKey(media *models.Media, size string) string {
+	return fmt.Sprintf("thumbnails/event_%d/%d_%s.jpg", media.EventID, media.ID, size)
+}
+
+func validThumbnailSize(name string) bool {
+	for _, size := range ThumbnailSizes {
+		if size.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func thumbnailable(mimeType string) bool {
+	if mimeType == "" {
+		return true
+	}
+	for _, candidate := range thumbnailTypes {
+		if candidate == mimeType {
+			return true
+		}
+	}
+	return false
+}
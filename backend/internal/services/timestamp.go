@@ -0,0 +1,189 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/protest-tracker/internal/jobs"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/repository"
+	"github.com/protest-tracker/internal/timestamp"
+)
+
+// Jobs that obtain RFC 3161 timestamps. A TSA outage can last a while, so
+// they are tried more often than processing jobs.
+const (
+	JobTimestampMedia = "timestamp_media"
+	JobTimestampEvent = "timestamp_event"
+
+	timestampAttempts = 10
+)
+
+// eventRecord is the event record an event timestamp covers
+type eventRecord struct {
+	models.ArrestEvent
+	// Set when the record was taken after the event was created, such as
+	// for events from before a TSA was configured. It then shows the event
+	// as it stood at the time, edits included.
+	RecordedLate bool `json:"recordedLate,omitempty"`
+}
+
+// TimestampService has a time-stamping authority attest to when evidence
+// existed: the hash of every uploaded file and the record of every event
+// as it was created. Tokens are verified as they arrive and again at
+// export.
+type TimestampService struct {
+	mediaRepo *repository.MediaRepository
+	eventRepo *repository.EventRepository
+	tsa       *timestamp.Client
+	roots     *timestamp.Roots
+	jobs      *jobs.Queue
+}
+
+// NewTimestampService creates a timestamp service. When tsa is nil no new
+// timestamps are requested, but stored tokens are still verified against
+// roots.
+func NewTimestampService(mediaRepo *repository.MediaRepository, eventRepo *repository.EventRepository, tsa *timestamp.Client, roots *timestamp.Roots) *TimestampService {
+	return &TimestampService{
+		mediaRepo: mediaRepo,
+		eventRepo: eventRepo,
+		tsa:       tsa,
+		roots:     roots,
+	}
+}
+
+// RegisterJobs makes queue request timestamps, if a TSA is configured
+func (s *TimestampService) RegisterJobs(queue *jobs.Queue) {
+	if s.tsa == nil {
+		return
+	}
+	s.jobs = queue
+	queue.Register(JobTimestampMedia, timestampAttempts, s.timestampMedia)
+	queue.Register(JobTimestampEvent, timestampAttempts, s.timestampEvent)
+}
+
+// QueueBacklog schedules timestamps for media and events that do not have
+// one yet, such as those stored before a TSA was configured or whose job
+// gave up
+func (s *TimestampService) QueueBacklog() {
+	if s.jobs == nil {
+		return
+	}
+
+	mediaIDs, err := s.mediaRepo.GetUntimestamped()
+	if err != nil {
+		log.Printf("Failed to find media without a timestamp: %v", err)
+	}
+	for _, mediaID := range mediaIDs {
+		if err = s.jobs.Enqueue(JobTimestampMedia, mediaID, nil); err != nil {
+			log.Printf("Failed to queue timestamp of media %d: %v", mediaID, err)
+		}
+	}
+
+	eventIDs, err := s.eventRepo.GetUntimestamped()
+	if err != nil {
+		log.Printf("Failed to find events without a timestamp: %v", err)
+	}
+	for _, eventID := range eventIDs {
+		event, err := s.eventRepo.GetByID(eventID)
+		if err != nil {
+			log.Printf("Failed to load event %d: %v", eventID, err)
+			continue
+		}
+		s.stampEvent(event, true)
+	}
+}
+
+// StampMedia schedules a timestamp of a new media item's hash
+func (s *TimestampService) StampMedia(media *models.Media) {
+	if s == nil || s.jobs == nil {
+		return
+	}
+	if err := s.jobs.Enqueue(JobTimestampMedia, media.ID, nil); err != nil {
+		log.Printf("Failed to queue timestamp of media %d: %v", media.ID, err)
+	}
+}
+
+// StampEvent schedules a timestamp of a new event. The event is recorded
+// as it is now, so later edits do not change what is attested.
+func (s *TimestampService) StampEvent(event *models.ArrestEvent) {
+	s.stampEvent(event, false)
+}
+
+// stampEvent schedules a timestamp of the event as it is now, unless one
+// is already pending. late marks a record taken after the event was
+// created.
+func (s *TimestampService) stampEvent(event *models.ArrestEvent, late bool) {
+	if s == nil || s.jobs == nil {
+		return
+	}
+	record := eventRecord{ArrestEvent: *event, RecordedLate: late}
+	if err := s.jobs.EnqueueEvent(JobTimestampEvent, event.ID, record); err != nil {
+		log.Printf("Failed to queue timestamp of event %d: %v", event.ID, err)
+	}
+}
+
+// Verify checks a stored token against the SHA-256 digest it should cover
+func (s *TimestampService) Verify(token, digest []byte) (*timestamp.Info, error) {
+	return timestamp.Verify(token, digest, s.roots.CertPool())
+}
+
+// CACertificate returns the PEM certificates of the TSA tokens are
+// trusted under, or nil when none are configured
+func (s *TimestampService) CACertificate() []byte {
+	if s.roots == nil {
+		return nil
+	}
+	return s.roots.PEM
+}
+
+// timestampMedia obtains a timestamp over a media item's SHA-256
+func (s *TimestampService) timestampMedia(job *models.Job) error {
+	media, err := s.mediaRepo.GetByID(job.MediaID)
+	if err != nil {
+		return err
+	}
+	if media.TimestampedAt != nil || media.SHA256 == "" {
+		return nil
+	}
+
+	digest, err := hex.DecodeString(media.SHA256)
+	if err != nil {
+		return fmt.Errorf("invalid media hash: %v", err)
+	}
+	token, info, err := s.tsa.Stamp(digest)
+	if err != nil {
+		return err
+	}
+	return s.mediaRepo.SetTimestamp(media.ID, token, info.Time)
+}
+
+// timestampEvent obtains a timestamp over the SHA-256 of the event record
+// held in the job's payload, and stores the record with the token
+func (s *TimestampService) timestampEvent(job *models.Job) error {
+	var record eventRecord
+	if err := json.Unmarshal(job.Payload, &record); err != nil {
+		return fmt.Errorf("invalid event record: %v", err)
+	}
+	event, err := s.eventRepo.GetByID(record.ID)
+	if err == sql.ErrNoRows {
+		return nil // deleted meanwhile
+	}
+	if err != nil {
+		return err
+	}
+	if event.TimestampedAt != nil {
+		return nil
+	}
+
+	digest := sha256.Sum256(job.Payload)
+	token, info, err := s.tsa.Stamp(digest[:])
+	if err != nil {
+		return err
+	}
+	return s.eventRepo.SetTimestamp(event.ID, string(job.Payload), token, info.Time)
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/protest-tracker/internal/encryption"
+	"github.com/protest-tracker/internal/mediatype"
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/repository"
+)
+
+// Upload errors that handlers map to specific status codes
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadOffset     = errors.New("upload offset mismatch")
+	ErrUploadTooLarge   = errors.New("upload exceeds declared length")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// UploadService implements resumable uploads. Each chunk is staged in its
+// own file, named by its offset, until the declared length is reached; the
+// assembled upload is then checked against the client's hash and handed to
+// MediaService.
+type UploadService struct {
+	uploadRepo   *repository.UploadRepository
+	eventRepo    *repository.EventRepository
+	mediaService *MediaService
+	keyring      *encryption.Keyring
+	stagingDir   string
+	ttl          time.Duration
+}
+
+func NewUploadService(uploadRepo *repository.UploadRepository, eventRepo *repository.EventRepository, mediaService *MediaService, keyring *encryption.Keyring, stagingDir string, ttl time.Duration) *UploadService {
+	return &UploadService{
+		uploadRepo:   uploadRepo,
+		eventRepo:    eventRepo,
+		mediaService: mediaService,
+		keyring:      keyring,
+		stagingDir:   stagingDir,
+		ttl:          ttl,
+	}
+}
+
+// MaxSize is the largest upload accepted of any media type
+func (s *UploadService) MaxSize() int64 {
+	return s.mediaService.MaxUploadSize()
+}
+
+// CreateSession starts a resumable upload of length bytes. checksum is the
+// optional hex SHA-256 of the complete file. source is kept, subject to the
+// provenance settings, until the upload completes.
+func (s *UploadService) CreateSession(eventID, userID int, mediaType string, length int64, checksum string, source *models.UploadSource) (*models.UploadSession, error) {
+	_, err := s.eventRepo.GetByID(eventID)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	if !mediatype.Valid(mediaType) {
+		return nil, errors.New("invalid media type")
+	}
+	if length <= 0 {
+		return nil, errors.New("upload length is required")
+	}
+	if length > s.mediaService.limits.maxSize(mediaType) {
+		return nil, mediatype.ErrTooLarge
+	}
+	if err = s.mediaService.checkQuota(eventID, userID, length); err != nil {
+		return nil, err
+	}
+	if err = s.mediaService.checkFreeSpace(length); err != nil {
+		return nil, err
+	}
+	if checksum != "" {
+		if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != 32 {
+			return nil, errors.New("invalid sha256 checksum")
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	session := &models.UploadSession{
+		ID:        hex.EncodeToString(id),
+		EventID:   eventID,
+		UserID:    userID,
+		Type:      mediaType,
+		Length:    length,
+		SHA256:    checksum,
+		ExpiresAt: time.Now().UTC().Add(s.ttl),
+	}
+	source = s.mediaService.filterSource(source)
+	session.CapturedAt, session.UploadIP, session.DeviceFingerprint = source.CapturedAt, source.IP, source.DeviceFingerprint
+	if s.keyring != nil {
+		_, session.KeyID, session.WrappedKey, err = s.keyring.NewDataKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up encryption: %v", err)
+		}
+	}
+
+	if err = os.MkdirAll(s.stagingDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	if err = os.Mkdir(s.stagingPath(session.ID), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+
+	if err = s.uploadRepo.Create(session); err != nil {
+		os.RemoveAll(s.stagingPath(session.ID))
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetSession retrieves an upload session owned by userID
+func (s *UploadService) GetSession(eventID, userID int, id string) (*models.UploadSession, error) {
+	session, err := s.uploadRepo.GetByID(id)
+	if err != nil || session.EventID != eventID || session.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	if session.MediaID == 0 && time.Now().After(session.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// AppendChunk writes a chunk starting at offset. When chunkSHA256 is set
+// the chunk is discarded unless it matches. Once the declared length has
+// been received the upload is verified and turned into a media record.
+func (s *UploadService) AppendChunk(eventID, userID int, id string, offset int64, chunk io.Reader, chunkSHA256 []byte) (*models.UploadSession, error) {
+	lock, err := s.uploadRepo.Lock(id)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	session, err := s.GetSession(eventID, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.MediaID != 0 {
+		return nil, errors.New("upload already completed")
+	}
+	if offset != session.Offset {
+		return session, ErrUploadOffset
+	}
+
+	// Drop anything past the recorded offset left by an interrupted request
+	if err = s.dropChunks(id, offset); err != nil {
+		return nil, err
+	}
+
+	// Read one byte past the remaining length to detect oversized chunks
+	remaining := session.Length - offset
+	path := s.chunkPath(id, offset)
+	written, sum, copyErr, err := s.writeChunk(session, path, io.LimitReader(chunk, remaining+1))
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	switch {
+	case written > remaining:
+		os.Remove(path)
+		return session, ErrUploadTooLarge
+	case chunkSHA256 != nil && (copyErr != nil || !bytes.Equal(sum, chunkSHA256)):
+		os.Remove(path)
+		return session, ErrChecksumMismatch
+	case written == 0:
+		os.Remove(path)
+	}
+
+	// Keep whatever arrived before a dropped connection so the client can resume
+	session.Offset = offset + written
+	session.ExpiresAt = time.Now().UTC().Add(s.ttl)
+	if err = s.uploadRepo.UpdateOffset(id, session.Offset, session.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return session, fmt.Errorf("upload interrupted: %v", copyErr)
+	}
+
+	if session.Offset == session.Length {
+		if err = s.finish(session); err != nil {
+			return session, err
+		}
+	}
+
+	return session, nil
+}
+
+// finish verifies the assembled file and creates the media record
+func (s *UploadService) finish(session *models.UploadSession) error {
+	if session.SHA256 != "" {
+		reader, err := s.openStaging(session)
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		if hex.EncodeToString(hasher.Sum(nil)) != session.SHA256 {
+			// The data is unusable; discard it so the client can start over
+			s.discard(session.ID)
+			return ErrChecksumMismatch
+		}
+	}
+
+	reader, err := s.openStaging(session)
+	if err != nil {
+		return err
+	}
+	source := &models.UploadSource{
+		CapturedAt:        session.CapturedAt,
+		IP:                session.UploadIP,
+		DeviceFingerprint: session.DeviceFingerprint,
+	}
+	media, duplicate, err := s.mediaService.UploadMedia(session.EventID, session.UserID, reader, session.Type, source)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	session.MediaID = media.ID
+	session.Duplicate = duplicate
+	if err = s.uploadRepo.Complete(session.ID, media.ID, duplicate); err != nil {
+		return err
+	}
+	os.RemoveAll(s.stagingPath(session.ID))
+	return nil
+}
+
+// Terminate cancels an upload and removes its staged data
+func (s *UploadService) Terminate(eventID, userID int, id string) error {
+	lock, err := s.uploadRepo.Lock(id)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if _, err := s.GetSession(eventID, userID, id); err != nil {
+		return err
+	}
+	return s.discard(id)
+}
+
+// PurgeExpired removes sessions and staged data past their expiry
+func (s *UploadService) PurgeExpired() (int, error) {
+	ids, err := s.uploadRepo.DeleteExpired(time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		os.RemoveAll(s.stagingPath(id))
+	}
+	return len(ids), nil
+}
+
+// RunReaper purges expired sessions every interval until the process exits
+func (s *UploadService) RunReaper(interval time.Duration) {
+	for range time.Tick(interval) {
+		purged, err := s.PurgeExpired()
+		if err != nil {
+			log.Printf("Failed to purge expired uploads: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d expired uploads", purged)
+		}
+	}
+}
+
+func (s *UploadService) discard(id string) error {
+	os.RemoveAll(s.stagingPath(id))
+	return s.uploadRepo.Delete(id)
+}
+
+func (s *UploadService) stagingPath(id string) string {
+	return filepath.Join(s.stagingDir, id)
+}
+
+// chunkPath is where the chunk starting at offset is staged. Offsets are
+// zero-padded so that directory order is upload order.
+func (s *UploadService) chunkPath(id string, offset int64) string {
+	return filepath.Join(s.stagingPath(id), fmt.Sprintf("%020d", offset))
+}
+
+// writeChunk stages the data of one request in its own file. Encrypted
+// uploads seal each chunk with a fresh nonce, so data sent again after a
+// failed attempt is never encrypted with the same keystream. It returns how
+// many bytes were staged and their SHA-256; readErr is set when src failed
+// part way, in which case what arrived before is kept.
+func (s *UploadService) writeChunk(session *models.UploadSession, path string, src io.Reader) (written int64, sum []byte, readErr error, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to create staging file: %v", err)
+	}
+	defer file.Close()
+
+	var out io.Writer = file
+	var sealer *encryption.Writer
+	if session.KeyID != "" {
+		key, err := s.stagingKey(session)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if sealer, err = encryption.NewWriter(file, key); err != nil {
+			return 0, nil, nil, err
+		}
+		out = sealer
+	}
+
+	hasher := sha256.New()
+	reader := &errorReader{r: src}
+	written, err = io.Copy(io.MultiWriter(out, hasher), reader)
+	if err != nil && reader.err == nil {
+		return 0, nil, nil, fmt.Errorf("failed to write staging file: %v", err)
+	}
+	if sealer != nil {
+		if err = sealer.Close(); err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to write staging file: %v", err)
+		}
+	}
+	if err = file.Close(); err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to write staging file: %v", err)
+	}
+
+	return written, hasher.Sum(nil), reader.err, nil
+}
+
+// dropChunks removes the staged chunks of an upload starting at or after
+// offset
+func (s *UploadService) dropChunks(id string, offset int64) error {
+	entries, err := os.ReadDir(s.stagingPath(id))
+	if err != nil {
+		return fmt.Errorf("failed to read staging directory: %v", err)
+	}
+	for _, entry := range entries {
+		start, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil || start >= offset {
+			os.Remove(filepath.Join(s.stagingPath(id), entry.Name()))
+		}
+	}
+	return nil
+}
+
+func (s *UploadService) stagingKey(session *models.UploadSession) ([]byte, error) {
+	if s.keyring == nil {
+		return nil, errors.New("upload is encrypted but no master key is configured")
+	}
+	key, err := s.keyring.Unwrap(session.KeyID, session.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap staging key: %v", err)
+	}
+	return key, nil
+}
+
+// openStaging returns a reader over the plaintext of an upload's staged
+// chunks in order. It fails unless they cover exactly the bytes received.
+func (s *UploadService) openStaging(session *models.UploadSession) (io.ReadCloser, error) {
+	entries, err := os.ReadDir(s.stagingPath(session.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging directory: %v", err)
+	}
+
+	var key []byte
+	if session.KeyID != "" {
+		if key, err = s.stagingKey(session); err != nil {
+			return nil, err
+		}
+	}
+
+	staged := &stagedReader{}
+	var readers []io.Reader
+	var next int64
+	for _, entry := range entries {
+		start, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil || start != next {
+			staged.Close()
+			return nil, errors.New("staged upload is incomplete")
+		}
+
+		file, err := os.Open(filepath.Join(s.stagingPath(session.ID), entry.Name()))
+		if err != nil {
+			staged.Close()
+			return nil, fmt.Errorf("failed to open staging file: %v", err)
+		}
+		staged.files = append(staged.files, file)
+
+		info, err := file.Stat()
+		if err != nil {
+			staged.Close()
+			return nil, err
+		}
+		if key == nil {
+			readers = append(readers, file)
+			next += info.Size()
+			continue
+		}
+		plain, err := encryption.NewReader(file, info.Size(), key)
+		if err != nil {
+			staged.Close()
+			return nil, fmt.Errorf("failed to open staging file: %v", err)
+		}
+		readers = append(readers, plain)
+		next += plain.Size()
+	}
+	if next != session.Offset {
+		staged.Close()
+		return nil, errors.New("staged upload is incomplete")
+	}
+
+	staged.Reader = io.MultiReader(readers...)
+	return staged, nil
+}
+
+// stagedReader reads the staged chunks of an upload one after another
+type stagedReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *stagedReader) Close() error {
+	for _, file := range r.files {
+		file.Close()
+	}
+	return nil
+}
+
+// errorReader remembers the error its reader failed with, telling a
+// dropped client apart from a failed write
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/protest-tracker/internal/encryption"
+	"github.com/protest-tracker/internal/models"
+)
+
+// newStagingTest returns an upload service staging into a temporary
+// directory and a session with its staging directory created
+func newStagingTest(t *testing.T, encrypted bool, length int64) (*UploadService, *models.UploadSession) {
+	s := &UploadService{stagingDir: t.TempDir()}
+	session := &models.UploadSession{ID: "0123456789abcdef", Length: length}
+
+	if encrypted {
+		master := make([]byte, encryption.KeySize)
+		rand.Read(master)
+		keyring, err := encryption.NewKeyring(master)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.keyring = keyring
+		if _, session.KeyID, session.WrappedKey, err = keyring.NewDataKey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(s.stagingPath(session.ID), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return s, session
+}
+
+// stage writes data as the chunk starting at the session's offset and
+// advances it
+func stage(t *testing.T, s *UploadService, session *models.UploadSession, data []byte) {
+	written, sum, readErr, err := s.writeChunk(session, s.chunkPath(session.ID, session.Offset), bytes.NewReader(data))
+	if err != nil || readErr != nil {
+		t.Fatalf("writeChunk: %v, %v", err, readErr)
+	}
+	if want := sha256.Sum256(data); written != int64(len(data)) || !bytes.Equal(sum, want[:]) {
+		t.Fatalf("writeChunk staged %d bytes with hash %x", written, sum)
+	}
+	session.Offset += written
+}
+
+func readStaged(t *testing.T, s *UploadService, session *models.UploadSession) []byte {
+	reader, err := s.openStaging(session)
+	if err != nil {
+		t.Fatalf("openStaging: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading staged upload: %v", err)
+	}
+	return data
+}
+
+func TestStagingRoundTrip(t *testing.T) {
+	data := make([]byte, 3*encryption.ChunkSize+123)
+	rand.Read(data)
+
+	tests := []struct {
+		name   string
+		chunks []int
+	}{
+		{"single chunk", []int{len(data)}},
+		{"even chunks", []int{encryption.ChunkSize, encryption.ChunkSize, encryption.ChunkSize, 123}},
+		{"uneven chunks", []int{1, 70000, 5, len(data) - 70006}},
+	}
+
+	for _, tt := range tests {
+		for _, encrypted := range []bool{false, true} {
+			name := tt.name
+			if encrypted {
+				name += " encrypted"
+			}
+			t.Run(name, func(t *testing.T) {
+				s, session := newStagingTest(t, encrypted, int64(len(data)))
+				rest := data
+				for _, size := range tt.chunks {
+					stage(t, s, session, rest[:size])
+					rest = rest[size:]
+				}
+				if !bytes.Equal(readStaged(t, s, session), data) {
+					t.Error("staged upload does not match what was sent")
+				}
+			})
+		}
+	}
+}
+
+// failingReader returns its data and then err, like a dropped connection
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// What arrived before a dropped connection is kept so the client can resume
+// from there
+func TestStagingInterruptedChunk(t *testing.T) {
+	data := make([]byte, 200000)
+	rand.Read(data)
+	dropped := errors.New("connection reset")
+
+	for _, encrypted := range []bool{false, true} {
+		s, session := newStagingTest(t, encrypted, int64(len(data)))
+		stage(t, s, session, data[:1000])
+
+		src := &failingReader{data: data[1000:50000], err: dropped}
+		written, sum, readErr, err := s.writeChunk(session, s.chunkPath(session.ID, session.Offset), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := sha256.Sum256(data[1000:50000])
+		if readErr != dropped || written != 49000 || !bytes.Equal(sum, want[:]) {
+			t.Fatalf("writeChunk = %d, %x, %v", written, sum, readErr)
+		}
+		session.Offset += written
+
+		stage(t, s, session, data[50000:])
+		if !bytes.Equal(readStaged(t, s, session), data) {
+			t.Errorf("encrypted %v: resumed upload does not match what was sent", encrypted)
+		}
+	}
+}
+
+// A chunk sent again after a failed attempt replaces the failed one and is
+// sealed with a fresh nonce
+func TestStagingRetryAfterFailedChunk(t *testing.T) {
+	data := make([]byte, 100000)
+	rand.Read(data)
+	s, session := newStagingTest(t, true, int64(len(data)))
+	stage(t, s, session, data[:40000])
+
+	// A chunk that failed its checksum is dropped without moving the offset
+	path := s.chunkPath(session.ID, session.Offset)
+	if _, _, _, err := s.writeChunk(session, path, bytes.NewReader(data[40000:])); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.dropChunks(session.ID, session.Offset); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("failed chunk was not dropped: %v", err)
+	}
+	if _, err = os.Stat(s.chunkPath(session.ID, 0)); err != nil {
+		t.Fatalf("accepted chunk was dropped: %v", err)
+	}
+
+	stage(t, s, session, data[40000:])
+	second, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("retried chunk was encrypted exactly as before")
+	}
+	if !bytes.Equal(readStaged(t, s, session), data) {
+		t.Error("staged upload does not match what was sent")
+	}
+}
+
+// The staged chunks must cover exactly the bytes the session has received
+func TestOpenStagingIncomplete(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks map[int64]int
+		offset int64
+	}{
+		{"gap", map[int64]int{0: 100, 200: 100}, 300},
+		{"missing start", map[int64]int{100: 100}, 200},
+		{"short of offset", map[int64]int{0: 100}, 150},
+		{"past offset", map[int64]int{0: 100, 100: 100}, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, session := newStagingTest(t, false, 1000)
+			for start, size := range tt.chunks {
+				if err := os.WriteFile(s.chunkPath(session.ID, start), make([]byte, size), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			session.Offset = tt.offset
+			if reader, err := s.openStaging(session); err == nil {
+				reader.Close()
+				t.Error("openStaging succeeded")
+			}
+		})
+	}
+}
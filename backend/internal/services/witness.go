@@ -1,62 +1,245 @@
 package services
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/protest-tracker/internal/models"
+	"github.com/protest-tracker/internal/notify"
+	"github.com/protest-tracker/internal/realtime"
 	"github.com/protest-tracker/internal/repository"
 )
 
+// ErrNoNotifiers is returned when witnesses cannot be contacted because no
+// notification channel is configured
+var ErrNoNotifiers = errors.New("no notification channels are configured")
+
+// ErrContactRequestNotFound is returned for contact requests that do not
+// exist or belong to another event
+var ErrContactRequestNotFound = errors.New("contact request not found")
+
+// ErrNotSubscribed is returned when someone who has not subscribed to an
+// event as a witness tries to give a statement about it
+var ErrNotSubscribed = errors.New("only witnesses subscribed to the event can give a statement")
+
+// maxStatementLength bounds a witness statement, in bytes
+const maxStatementLength = 20000
+
 type WitnessService struct {
 	subscriptionRepo *repository.SubscriptionRepository
 	eventRepo        *repository.EventRepository
+	contactRepo      *repository.ContactRepository
+	statementRepo    *repository.StatementRepository
+	feed             *realtime.Hub
+
+	// Configured notifiers by channel
+	notifiers map[string]notify.Notifier
+
+	// Wakes the dispatcher when messages are queued
+	wake chan struct{}
 }
 
-func NewWitnessService(subscriptionRepo *repository.SubscriptionRepository, eventRepo *repository.EventRepository) *WitnessService {
+func NewWitnessService(subscriptionRepo *repository.SubscriptionRepository, eventRepo *repository.EventRepository, contactRepo *repository.ContactRepository, statementRepo *repository.StatementRepository, notifiers []notify.Notifier, feed *realtime.Hub) *WitnessService {
+	byChannel := make(map[string]notify.Notifier)
+	for _, notifier := range notifiers {
+		byChannel[notifier.Channel()] = notifier
+	}
 	return &WitnessService{
 		subscriptionRepo: subscriptionRepo,
 		eventRepo:        eventRepo,
+		contactRepo:      contactRepo,
+		statementRepo:    statementRepo,
+		feed:             feed,
+		notifiers:        byChannel,
+		wake:             make(chan struct{}, 1),
 	}
 }
 
-// ContactWitnesses sends a message to all witnesses subscribed to an event
-func (s *WitnessService) ContactWitnesses(eventID int, message string) error {
+// ContactWitnesses queues a message to all witnesses subscribed to an
+// event, over the channels each of them prefers. The message is sent in
+// the background; the contact request tracks each delivery.
+func (s *WitnessService) ContactWitnesses(eventID, sentBy int, subject, message string) (*models.ContactRequest, error) {
 	// Check if event exists
 	_, err := s.eventRepo.GetByID(eventID)
 	if err != nil {
-		return errors.New("event not found")
+		return nil, errors.New("event not found")
+	}
+
+	if len(s.notifiers) == 0 {
+		return nil, ErrNoNotifiers
 	}
 
 	// Get all subscribers for this event
 	subscribers, err := s.subscriptionRepo.GetSubscribersByEventID(eventID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(subscribers) == 0 {
-		return errors.New("no witnesses subscribed to this event")
+		return nil, errors.New("no witnesses subscribed to this event")
+	}
+
+	if subject == "" {
+		subject = fmt.Sprintf("Update on event %d", eventID)
+	}
+	req := &models.ContactRequest{
+		EventID:    eventID,
+		SentBy:     sentBy,
+		Subject:    subject,
+		Message:    message,
+		CreatedAt:  time.Now().UTC(),
+		Recipients: len(subscribers),
 	}
 
-	// In a real implementation, you would send emails/notifications here
-	// For now, we'll just log the action
-	var emails []string
+	var deliveries []models.Delivery
 	for _, subscriber := range subscribers {
-		emails = append(emails, subscriber.Email)
+		notifiers := s.channelsFor(&subscriber)
+		if len(notifiers) == 0 {
+			deliveries = append(deliveries, models.Delivery{
+				UserID:    subscriber.ID,
+				Status:    models.DeliveryFailed,
+				LastError: "no configured channel can reach this witness",
+			})
+			req.Failed++
+		}
+		for _, notifier := range notifiers {
+			deliveries = append(deliveries, models.Delivery{
+				UserID:  subscriber.ID,
+				Channel: notifier.Channel(),
+				Status:  models.DeliveryQueued,
+			})
+			req.Queued++
+		}
 	}
 
-	log.Printf("Contacting witnesses for event %d: %v", eventID, emails)
-	log.Printf("Message: %s", message)
+	if err := s.contactRepo.Create(req, deliveries); err != nil {
+		return nil, err
+	}
+	for i := range deliveries {
+		deliveries[i].UpdatedAt = req.CreatedAt
+		if deliveries[i].Status == models.DeliveryQueued {
+			deliveries[i].NextAttemptAt = &req.CreatedAt
+		}
+	}
+	req.Deliveries = deliveries
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
 
-	// TODO: Implement actual email/notification sending
-	// This could involve:
-	// - Email service integration (SendGrid, AWS SES, etc.)
-	// - Push notification service
-	// - SMS service
-	// - Webhook notifications
+	log.Printf("Queued %d notifications to witnesses of event %d", req.Queued, eventID)
+	return req, nil
+}
+
+// GetContactRequests lists the messages sent to an event's witnesses, with
+// how many deliveries are queued, sent, failed and bounced
+func (s *WitnessService) GetContactRequests(eventID int) ([]models.ContactRequest, error) {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return nil, errors.New("event not found")
+	}
+	requests, err := s.contactRepo.GetByEventID(eventID)
+	if err != nil {
+		return nil, err
+	}
+	if requests == nil {
+		requests = []models.ContactRequest{}
+	}
+	return requests, nil
+}
 
+// GetContactRequest retrieves a message sent to an event's witnesses with
+// the status of the delivery to each witness on each channel
+func (s *WitnessService) GetContactRequest(eventID, requestID int) (*models.ContactRequest, error) {
+	req, err := s.contactRepo.GetByID(requestID)
+	if err == sql.ErrNoRows || err == nil && req.EventID != eventID {
+		return nil, ErrContactRequestNotFound
+	}
+	return req, err
+}
+
+// SubmitStatement records a statement about an event from one of its
+// subscribed witnesses
+func (s *WitnessService) SubmitStatement(eventID, userID int, text string) (*models.WitnessStatement, error) {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("statement is required")
+	}
+	if len(text) > maxStatementLength {
+		return nil, fmt.Errorf("statement must be at most %d bytes", maxStatementLength)
+	}
+
+	subscribed, err := s.subscriptionRepo.IsSubscribed(eventID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !subscribed {
+		return nil, ErrNotSubscribed
+	}
+
+	statement := &models.WitnessStatement{
+		EventID:   eventID,
+		UserID:    userID,
+		Statement: text,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.statementRepo.Create(statement); err != nil {
+		return nil, err
+	}
+	s.feed.Publish(realtime.StatementCreated, eventID, statement, true)
+	return statement, nil
+}
+
+// GetStatements lists the statements witnesses have given about an event
+func (s *WitnessService) GetStatements(eventID int) ([]models.WitnessStatement, error) {
+	if _, err := s.eventRepo.GetByID(eventID); err != nil {
+		return nil, errors.New("event not found")
+	}
+	return s.statementRepo.GetByEventID(eventID)
+}
+
+// channelsFor picks the notifiers to reach a user with: every configured
+// channel they prefer and have an address for or, when none is left, the
+// first configured channel that can reach them
+func (s *WitnessService) channelsFor(user *models.User) []notify.Notifier {
+	var chosen []notify.Notifier
+	for _, channel := range user.NotificationChannels {
+		if notifier, ok := s.notifiers[channel]; ok && reachable(user, channel) {
+			chosen = append(chosen, notifier)
+		}
+	}
+	if len(chosen) > 0 {
+		return chosen
+	}
+
+	for _, channel := range notify.Channels {
+		if notifier, ok := s.notifiers[channel]; ok && reachable(user, channel) {
+			return []notify.Notifier{notifier}
+		}
+	}
 	return nil
 }
 
+// reachable reports whether a user has an address on a channel. Webhooks
+// are handed the user's details and left to find them.
+func reachable(user *models.User, channel string) bool {
+	switch channel {
+	case notify.ChannelEmail:
+		return user.Email != ""
+	case notify.ChannelSMS:
+		return user.PhoneNumber != ""
+	}
+	return true
+}
+
 // GetWitnessCount returns the number of witnesses for an event
 func (s *WitnessService) GetWitnessCount(eventID int) (int, error) {
 	subscribers, err := s.subscriptionRepo.GetSubscribersByEventID(eventID)
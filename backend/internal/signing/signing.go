@@ -0,0 +1,71 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Algorithm names the signature scheme in signed documents
+const Algorithm = "Ed25519"
+
+// Signer signs documents that recipients verify offline with the public key
+type Signer struct {
+	key ed25519.PrivateKey
+	id  string
+}
+
+// NewSigner creates a signer from a 32-byte Ed25519 seed
+func NewSigner(seed []byte) (*Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return &Signer{key: key, id: hex.EncodeToString(sum[:8])}, nil
+}
+
+// LoadSigner builds a signer from a base64-encoded seed taken from key or,
+// if empty, from the contents of keyFile. It returns nil when neither is
+// configured.
+func LoadSigner(key, keyFile string) (*Signer, error) {
+	if key == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key file: %v", err)
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %v", err)
+	}
+	return NewSigner(seed)
+}
+
+// KeyID returns a short fingerprint of the public key
+func (s *Signer) KeyID() string {
+	return s.id
+}
+
+// Sign returns the signature of data
+func (s *Signer) Sign(data []byte) []byte {
+	return ed25519.Sign(s.key, data)
+}
+
+// PublicKeyPEM returns the public key as a PEM "PUBLIC KEY" block, the form
+// `openssl pkeyutl -verify` accepts
+func (s *Signer) PublicKeyPEM() []byte {
+	der, _ := x509.MarshalPKIXPublicKey(s.key.Public())
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
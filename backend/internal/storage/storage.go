@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist in a store
+var ErrNotFound = errors.New("object not found")
+
+// ErrFreeSpaceUnknown is returned by FreeSpace for backends whose capacity
+// is not ours to measure, such as S3
+var ErrFreeSpaceUnknown = errors.New("free space cannot be determined")
+
+// BlobStore is a backend that media files are written to and read from.
+// Keys are slash-separated relative paths such as "event_1/123_photo.jpg".
+type BlobStore interface {
+	// Name identifies the backend in stored references ("local", "s3")
+	Name() string
+	Put(key string, r io.Reader) error
+	Open(key string) (Object, error)
+	Delete(key string) error
+}
+
+// Object is an open stored file
+type Object interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	Size() int64
+	ModTime() time.Time
+}
+
+// Registry resolves stored references to the backend holding them. New
+// objects are always written to the primary backend.
+//
+// A reference is "<backend>:<key>". References without a backend prefix are
+// plain filesystem paths written before storage backends were introduced.
+type Registry struct {
+	primary BlobStore
+	stores  map[string]BlobStore
+}
+
+// NewRegistry creates a registry writing to primary and able to read from
+// primary and others
+func NewRegistry(primary BlobStore, others ...BlobStore) *Registry {
+	r := &Registry{primary: primary, stores: make(map[string]BlobStore)}
+	for _, store := range append([]BlobStore{primary}, others...) {
+		r.stores[store.Name()] = store
+	}
+	return r
+}
+
+// Primary returns the backend new objects are written to
+func (r *Registry) Primary() BlobStore {
+	return r.primary
+}
+
+// Store returns the backend with the given name
+func (r *Registry) Store(name string) (BlobStore, bool) {
+	store, ok := r.stores[name]
+	return store, ok
+}
+
+// FreeSpace returns the bytes left for new objects on the primary backend
+func (r *Registry) FreeSpace() (int64, error) {
+	if measured, ok := r.primary.(interface{ FreeSpace() (int64, error) }); ok {
+		return measured.FreeSpace()
+	}
+	return 0, ErrFreeSpaceUnknown
+}
+
+// Put writes an object to the primary backend and returns its reference
+func (r *Registry) Put(key string, src io.Reader) (string, error) {
+	if err := r.primary.Put(key, src); err != nil {
+		return "", err
+	}
+	return Ref(r.primary, key), nil
+}
+
+// Open opens the object behind a reference
+func (r *Registry) Open(ref string) (Object, error) {
+	backend, key := ParseRef(ref)
+	if backend == "" {
+		return openFile(key)
+	}
+	store, ok := r.stores[backend]
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q is not configured", backend)
+	}
+	return store.Open(key)
+}
+
+// Delete removes the object behind a reference. Missing objects are not an
+// error.
+func (r *Registry) Delete(ref string) error {
+	backend, key := ParseRef(ref)
+	if backend == "" {
+		err := os.Remove(key)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	store, ok := r.stores[backend]
+	if !ok {
+		return fmt.Errorf("storage backend %q is not configured", backend)
+	}
+	return store.Delete(key)
+}
+
+// Ref builds the reference stored in the database for key in store
+func Ref(store BlobStore, key string) string {
+	return store.Name() + ":" + key
+}
+
+// ParseRef splits a reference into backend name and key. Legacy references
+// return an empty backend and the filesystem path as key.
+func ParseRef(ref string) (backend, key string) {
+	if i := strings.Index(ref, ":"); i > 0 && !strings.ContainsAny(ref[:i], `/\.`) {
+		return ref[:i], ref[i+1:]
+	}
+	return "", ref
+}
+
+// validKey rejects keys that could escape the store's namespace
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Load builds a registry whose primary backend is named by backend. The
+// local store is always available for reading; the S3 store is available
+// whenever s3.Endpoint is set, so files can be migrated between the two.
+func Load(backend, mediaDir string, s3 S3Config) (*Registry, error) {
+	local := NewLocalStore(mediaDir)
+	stores := []BlobStore{local}
+	if s3.Endpoint != "" {
+		s3Store, err := NewS3Store(s3)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, s3Store)
+	}
+
+	for i, store := range stores {
+		if store.Name() == backend {
+			others := append(append([]BlobStore{}, stores[:i]...), stores[i+1:]...)
+			return NewRegistry(store, others...), nil
+		}
+	}
+	return nil, fmt.Errorf("storage backend %q is not configured", backend)
+}
@@ -0,0 +1,189 @@
+package timestamp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxResponseSize bounds a time-stamping authority's reply
+const maxResponseSize = 1 << 20
+
+// Info describes a verified time-stamp token
+type Info struct {
+	// When the authority saw the hash
+	Time time.Time
+	// The authority's serial number for the token and the policy it was
+	// issued under
+	SerialNumber string
+	Policy       string
+	// Subject of the certificate that signed the token
+	Authority string
+}
+
+// Roots are the certificates a TSA's tokens are trusted under, and the PEM
+// file they were read from, which is handed on to those checking tokens
+// themselves
+type Roots struct {
+	Pool *x509.CertPool
+	PEM  []byte
+}
+
+// Client requests RFC 3161 time-stamp tokens from a time-stamping
+// authority (TSA) over HTTP
+type Client struct {
+	url   string
+	roots *x509.CertPool
+	http  *http.Client
+}
+
+// NewClient creates a client for the TSA at url. Tokens are checked
+// against roots as they arrive; see Verify.
+func NewClient(url string, roots *x509.CertPool, timeout time.Duration) *Client {
+	return &Client{
+		url:   url,
+		roots: roots,
+		http:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Load creates a client for the TSA at url and reads the certificates it
+// is trusted under from the PEM file caFile. The client is nil when no URL
+// is configured; the roots are nil when no CA file is.
+func Load(url, caFile string, timeout time.Duration) (*Client, *Roots, error) {
+	var roots *Roots
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read TSA certificates: %v", err)
+		}
+		roots = &Roots{Pool: x509.NewCertPool(), PEM: data}
+		if !roots.Pool.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if url == "" {
+		return nil, roots, nil
+	}
+	return NewClient(url, roots.CertPool(), timeout), roots, nil
+}
+
+// CertPool returns the certificates to check tokens against, or nil
+// without roots
+func (r *Roots) CertPool() *x509.CertPool {
+	if r == nil {
+		return nil
+	}
+	return r.Pool
+}
+
+// URL returns the address of the TSA
+func (c *Client) URL() string {
+	return c.url
+}
+
+// request is a TimeStampReq
+type request struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+// response is a TimeStampResp
+type response struct {
+	Status         statusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type statusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+// Stamp asks the TSA to timestamp a SHA-256 digest and returns the token,
+// which is verified before it is returned
+func (c *Client) Stamp(digest []byte) ([]byte, *Info, error) {
+	if len(digest) != 32 {
+		return nil, nil, fmt.Errorf("digest must be SHA-256")
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query, err := asn1.Marshal(request{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := c.http.Post(c.url, "application/timestamp-query", bytes.NewReader(query))
+	if err != nil {
+		return nil, nil, fmt.Errorf("TSA request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("TSA returned %s", resp.Status)
+	}
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read TSA response: %v", err)
+	}
+
+	var parsed response
+	if _, err = asn1.Unmarshal(reply, &parsed); err != nil {
+		return nil, nil, fmt.Errorf("malformed TSA response: %v", err)
+	}
+	// 0 is granted, 1 granted with modifications
+	if parsed.Status.Status > 1 {
+		return nil, nil, fmt.Errorf("TSA refused the request: %s", statusText(parsed.Status))
+	}
+	token := parsed.TimeStampToken.FullBytes
+	if len(token) == 0 {
+		return nil, nil, fmt.Errorf("TSA response holds no token")
+	}
+
+	tst, info, err := verify(token, digest, c.roots)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tst.Nonce == nil || tst.Nonce.Cmp(nonce) != 0 {
+		return nil, nil, fmt.Errorf("TSA response does not answer this request")
+	}
+	return token, info, nil
+}
+
+func statusText(status statusInfo) string {
+	text := []string{fmt.Sprintf("status %d", status.Status)}
+	for _, line := range status.StatusString {
+		text = append(text, string(line.Bytes))
+	}
+	if status.FailInfo.BitLength > 0 {
+		var bits []string
+		for i := 0; i < status.FailInfo.BitLength; i++ {
+			if status.FailInfo.At(i) == 1 {
+				bits = append(bits, fmt.Sprint(i))
+			}
+		}
+		text = append(text, "failure bits "+strings.Join(bits, ","))
+	}
+	return strings.Join(text, "; ")
+}
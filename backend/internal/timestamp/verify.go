@@ -0,0 +1,290 @@
+package timestamp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	// Register the digests a token may be signed with
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAPSS  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+var digestAlgorithms = map[string]crypto.Hash{
+	oidSHA256.String(): crypto.SHA256,
+	oidSHA384.String(): crypto.SHA384,
+	oidSHA512.String(): crypto.SHA512,
+}
+
+// A token is CMS SignedData (RFC 5652) wrapping a TSTInfo (RFC 3161)
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       accuracy      `asn1:"optional"`
+	Ordering       bool          `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// Verify checks that token timestamps the SHA-256 digest: the token must
+// name the digest and carry a valid signature by a certificate issued for
+// timestamping. With roots, that certificate must also chain to one of
+// them; without, anyone able to make such a certificate could have made
+// the token.
+func Verify(token, digest []byte, roots *x509.CertPool) (*Info, error) {
+	_, info, err := verify(token, digest, roots)
+	return info, err
+}
+
+func verify(token, digest []byte, roots *x509.CertPool) (*tstInfo, *Info, error) {
+	var content contentInfo
+	if _, err := asn1.Unmarshal(token, &content); err != nil {
+		return nil, nil, fmt.Errorf("malformed token: %v", err)
+	}
+	if !content.ContentType.Equal(oidSignedData) {
+		return nil, nil, errors.New("token is not signed data")
+	}
+	var signed signedData
+	if _, err := asn1.Unmarshal(content.Content.Bytes, &signed); err != nil {
+		return nil, nil, fmt.Errorf("malformed token: %v", err)
+	}
+	if !signed.EncapContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, nil, errors.New("token does not hold a timestamp")
+	}
+
+	var tst tstInfo
+	if _, err := asn1.Unmarshal(signed.EncapContentInfo.Content, &tst); err != nil {
+		return nil, nil, fmt.Errorf("malformed timestamp: %v", err)
+	}
+	if !tst.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) ||
+		!bytes.Equal(tst.MessageImprint.HashedMessage, digest) {
+		return nil, nil, errors.New("token is for a different hash")
+	}
+
+	if len(signed.SignerInfos) != 1 {
+		return nil, nil, errors.New("token must have exactly one signer")
+	}
+	signer := signed.SignerInfos[0]
+	certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed certificate in token: %v", err)
+	}
+	cert := findSigner(certs, signer.SID)
+	if cert == nil {
+		return nil, nil, errors.New("token does not include the TSA certificate")
+	}
+	if err = checkSigner(signer, signed.EncapContentInfo.Content, cert); err != nil {
+		return nil, nil, err
+	}
+
+	if !hasTimestampingUsage(cert) {
+		return nil, nil, errors.New("TSA certificate is not issued for timestamping")
+	}
+	if roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, other := range certs {
+			intermediates.AddCert(other)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   tst.GenTime,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("TSA certificate is not trusted: %v", err)
+		}
+	}
+
+	return &tst, &Info{
+		Time:         tst.GenTime.UTC(),
+		SerialNumber: tst.SerialNumber.String(),
+		Policy:       tst.Policy.String(),
+		Authority:    cert.Subject.String(),
+	}, nil
+}
+
+// findSigner picks the certificate named by a signer identifier: an issuer
+// and serial number, or a [0] subject key identifier
+func findSigner(certs []*x509.Certificate, sid asn1.RawValue) *x509.Certificate {
+	for _, cert := range certs {
+		switch {
+		case sid.Class == asn1.ClassUniversal && sid.Tag == asn1.TagSequence:
+			var id issuerAndSerial
+			if _, err := asn1.Unmarshal(sid.FullBytes, &id); err == nil &&
+				bytes.Equal(id.Issuer.FullBytes, cert.RawIssuer) && id.Serial.Cmp(cert.SerialNumber) == 0 {
+				return cert
+			}
+		case sid.Class == asn1.ClassContextSpecific && sid.Tag == 0:
+			if len(cert.SubjectKeyId) > 0 && bytes.Equal(sid.Bytes, cert.SubjectKeyId) {
+				return cert
+			}
+		}
+	}
+	return nil
+}
+
+// checkSigner verifies the signature over the timestamp. When signed
+// attributes are present, as RFC 3161 requires, they are what was signed
+// and must carry the digest of the content.
+func checkSigner(signer signerInfo, content []byte, cert *x509.Certificate) error {
+	hash, ok := digestAlgorithms[signer.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm %s", signer.DigestAlgorithm.Algorithm)
+	}
+
+	message := content
+	if len(signer.SignedAttrs.FullBytes) > 0 {
+		h := hash.New()
+		h.Write(content)
+		if err := checkAttributes(signer.SignedAttrs.Bytes, h.Sum(nil)); err != nil {
+			return err
+		}
+		// The attributes are signed as a SET, not with their [0] tag
+		message = append([]byte{0x31}, signer.SignedAttrs.FullBytes[1:]...)
+	}
+
+	h := hash.New()
+	h.Write(message)
+	digest := h.Sum(nil)
+
+	var err error
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if signer.SignatureAlgorithm.Algorithm.Equal(oidRSAPSS) {
+			err = rsa.VerifyPSS(key, hash, digest, signer.Signature, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signer.Signature)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, signer.Signature) {
+			err = errors.New("ecdsa: verification error")
+		}
+	case ed25519.PublicKey:
+		if !signer.SignatureAlgorithm.Algorithm.Equal(oidEd25519) || !ed25519.Verify(key, message, signer.Signature) {
+			err = errors.New("ed25519: verification error")
+		}
+	default:
+		return fmt.Errorf("unsupported TSA key type %T", cert.PublicKey)
+	}
+	if err != nil {
+		return fmt.Errorf("token signature is invalid: %v", err)
+	}
+	return nil
+}
+
+// checkAttributes checks the content type and message digest attributes
+// of a signer
+func checkAttributes(attrs, digest []byte) error {
+	var typeOK, digestOK bool
+	for rest := attrs; len(rest) > 0; {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return fmt.Errorf("malformed signed attributes: %v", err)
+		}
+		if len(attr.Values) != 1 {
+			continue
+		}
+		switch {
+		case attr.Type.Equal(oidContentType):
+			var contentType asn1.ObjectIdentifier
+			_, err = asn1.Unmarshal(attr.Values[0].FullBytes, &contentType)
+			typeOK = err == nil && contentType.Equal(oidTSTInfo)
+		case attr.Type.Equal(oidMessageDigest):
+			var value []byte
+			_, err = asn1.Unmarshal(attr.Values[0].FullBytes, &value)
+			digestOK = err == nil && bytes.Equal(value, digest)
+		}
+	}
+	if !typeOK || !digestOK {
+		return errors.New("token signature does not cover the timestamp")
+	}
+	return nil
+}
+
+func hasTimestampingUsage(cert *x509.Certificate) bool {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageTimeStamping {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package timestamp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+
+// testTSA is a certificate authority and a time-stamping authority it
+// issued
+type testTSA struct {
+	ca, cert *x509.Certificate
+	key      *ecdsa.PrivateKey
+}
+
+func newTestTSA(t *testing.T, usages ...x509.ExtKeyUsage) *testTSA {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testTSA{ca: ca, cert: cert, key: key}
+}
+
+func (tsa *testTSA) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(tsa.ca)
+	return pool
+}
+
+// tokenOptions change how a test token is built
+type tokenOptions struct {
+	imprintAlgorithm asn1.ObjectIdentifier
+	// alter changes the TSTInfo after it has been signed
+	alter            func(*tstInfo)
+	withoutCerts     bool
+	corruptSignature bool
+}
+
+// token builds an RFC 3161 token over digest, signed with signed attributes
+// as real authorities do
+func (tsa *testTSA) token(t *testing.T, digest []byte, genTime time.Time, opts tokenOptions) []byte {
+	t.Helper()
+	must := func(data []byte, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	algorithm := oidSHA256
+	if opts.imprintAlgorithm != nil {
+		algorithm = opts.imprintAlgorithm
+	}
+	info := tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: messageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm}, HashedMessage: digest},
+		SerialNumber:   big.NewInt(1234),
+		GenTime:        genTime,
+	}
+	content := must(asn1.Marshal(info))
+	contentDigest := sha256.Sum256(content)
+
+	// Signed attributes: the content type and the content's digest
+	var attrs []byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidTSTInfo},
+		{oidMessageDigest, contentDigest[:]},
+	} {
+		value := must(asn1.Marshal(attr.value))
+		attrs = append(attrs, must(asn1.Marshal(attribute{Type: attr.oid, Values: []asn1.RawValue{{FullBytes: value}}}))...)
+	}
+	signedSet := must(asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs}))
+	setDigest := sha256.Sum256(signedSet)
+	signature := must(ecdsa.SignASN1(rand.Reader, tsa.key, setDigest[:]))
+	if opts.corruptSignature {
+		signature[len(signature)-1] ^= 1
+	}
+
+	if opts.alter != nil {
+		opts.alter(&info)
+		content = must(asn1.Marshal(info))
+	}
+
+	sid := must(asn1.Marshal(issuerAndSerial{Issuer: asn1.RawValue{FullBytes: tsa.cert.RawIssuer}, Serial: tsa.cert.SerialNumber}))
+	signer := signerInfo{
+		Version:            1,
+		SID:                asn1.RawValue{FullBytes: sid},
+		DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+		SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+		Signature:          signature,
+	}
+
+	signed := signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapsulatedContentInfo{ContentType: oidTSTInfo, Content: content},
+		SignerInfos:      []signerInfo{signer},
+	}
+	if !opts.withoutCerts {
+		signed.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: tsa.cert.Raw}
+	}
+
+	return must(asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: must(asn1.Marshal(signed))},
+	}))
+}
+
+func TestVerify(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	digest := sha256.Sum256([]byte("evidence"))
+	genTime := time.Date(2024, 5, 1, 18, 4, 40, 0, time.UTC)
+
+	token := tsa.token(t, digest[:], genTime, tokenOptions{})
+	for _, roots := range []*x509.CertPool{nil, tsa.roots()} {
+		info, err := Verify(token, digest[:], roots)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if !info.Time.Equal(genTime) || info.SerialNumber != "1234" || info.Policy != "1.2.3.4" || info.Authority != "CN=Test TSA" {
+			t.Errorf("Verify = %+v", info)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	digest := sha256.Sum256([]byte("evidence"))
+	other := sha256.Sum256([]byte("other evidence"))
+	genTime := time.Date(2024, 5, 1, 18, 4, 40, 0, time.UTC)
+	valid := tsa.token(t, digest[:], genTime, tokenOptions{})
+
+	tests := []struct {
+		name   string
+		token  []byte
+		digest []byte
+		roots  *x509.CertPool
+		want   string
+	}{
+		{"garbage", []byte("not a token"), digest[:], nil, "malformed token"},
+		{"other hash", valid, other[:], nil, "different hash"},
+		{
+			"other imprint algorithm",
+			tsa.token(t, digest[:], genTime, tokenOptions{imprintAlgorithm: oidSHA512}),
+			digest[:], nil, "different hash",
+		},
+		{
+			"time changed after signing",
+			tsa.token(t, digest[:], genTime, tokenOptions{alter: func(info *tstInfo) { info.GenTime = info.GenTime.Add(-time.Hour) }}),
+			digest[:], nil, "does not cover",
+		},
+		{
+			"hash changed after signing",
+			tsa.token(t, other[:], genTime, tokenOptions{alter: func(info *tstInfo) { info.MessageImprint.HashedMessage = digest[:] }}),
+			digest[:], nil, "does not cover",
+		},
+		{"bad signature", tsa.token(t, digest[:], genTime, tokenOptions{corruptSignature: true}), digest[:], nil, "signature is invalid"},
+		{"no certificate", tsa.token(t, digest[:], genTime, tokenOptions{withoutCerts: true}), digest[:], nil, "certificate"},
+		{
+			"certificate not for timestamping",
+			newTestTSA(t, x509.ExtKeyUsageCodeSigning).token(t, digest[:], genTime, tokenOptions{}),
+			digest[:], nil, "not issued for timestamping",
+		},
+		{"untrusted authority", valid, digest[:], newTestTSA(t).roots(), "not trusted"},
+		{
+			"signed outside the certificate's validity",
+			tsa.token(t, digest[:], time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC), tokenOptions{}),
+			digest[:], tsa.roots(), "not trusted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(tt.token, tt.digest, tt.roots)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Verify = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
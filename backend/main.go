@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/protest-tracker/internal/api"
 	"github.com/protest-tracker/internal/config"
@@ -15,6 +16,18 @@ func main() {
 		log.Fatal(err)
 	}
 	defer db.Close()
-	server := api.NewServer(db, cfg)
+
+	// Maintenance commands, e.g. `protest-tracker rotate-keys`
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:], db, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	server, err := api.NewServer(db, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	server.Start()
 }